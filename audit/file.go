@@ -0,0 +1,268 @@
+package audit
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// format of the timestamp appended to the names of rotated files, which sorts them by age
+const rotatedFormat = "20060102T150405.000"
+
+// Options configure the file the audit log is written to.
+type Options struct {
+	Path     string
+	MaxSize  int64         // in bytes: the file is rotated before exceeding it, unless 0
+	MaxAge   time.Duration // the file is rotated once it has been open for longer, unless 0
+	MaxFiles int           // number of rotated files retained, the oldest being deleted first: all of them if 0
+	Compress bool          // whether rotated files are compressed with gzip
+}
+
+// File is a file that is rotated according to its options. It is safe for concurrent use.
+type File struct {
+	options Options
+	now     func() time.Time
+	logger  *slog.Logger
+
+	mu     sync.Mutex
+	file   *os.File // nil if closed, or if it could not be opened again (which the next write retries)
+	closed bool
+	size   int64
+	opened time.Time
+
+	// after a failed rotation, the next one is only attempted once the file has grown by another MaxSize, or once another MaxAge has
+	// elapsed: entries keep being written to the same file in the meantime
+	retrySize int64
+	retryAt   time.Time
+
+	// rotated files are compressed and pruned in the background, one at a time
+	background sync.Mutex
+	pending    sync.WaitGroup
+}
+
+// OpenFile opens (or creates) the file at options.Path, appending to it.
+func OpenFile(options Options, now func() time.Time, logger *slog.Logger) (*File, error) {
+	f := &File{options: options, now: now, logger: logger}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.options.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file, f.size, f.opened = file, info.Size(), f.now()
+	f.retrySize, f.retryAt = 0, time.Time{}
+	return nil
+}
+
+// Write writes p to the file, rotating it first if needed: p is never split across files.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	// e.g. after a rotation, if the directory is not writable (yet)
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, fmt.Errorf("unable to open %s: %w", f.options.Path, err)
+		}
+		f.logger.Info("Opened audit log again", "path", f.options.Path)
+	}
+
+	if f.size > 0 && f.due(len(p)) {
+		// logged, as errors of the writes are not reported by the handlers of the audit logger
+		if err := f.rotate(); err != nil {
+			f.logger.Error("Unable to rotate audit log", "path", f.options.Path, "error", err)
+			f.retrySize, f.retryAt = f.size+f.options.MaxSize, f.now().Add(f.options.MaxAge)
+
+			// unless the new file could not be opened, which the next write retries
+			if f.file == nil {
+				return 0, fmt.Errorf("unable to rotate %s: %w", f.options.Path, err)
+			}
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// due reports whether the file must be rotated before writing n more bytes.
+func (f *File) due(n int) bool {
+	if f.options.MaxSize > 0 && f.size+int64(n) > max(f.options.MaxSize, f.retrySize) {
+		return true
+	}
+
+	if f.options.MaxAge > 0 {
+		now := f.now()
+		return now.Sub(f.opened) >= f.options.MaxAge && !now.Before(f.retryAt)
+	}
+
+	return false
+}
+
+// rotate renames the file, appending the current time to its name, and opens a new one in its place.
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	ext := filepath.Ext(f.options.Path)
+	rotated := strings.TrimSuffix(f.options.Path, ext) + "-" + f.now().UTC().Format(rotatedFormat) + ext
+	if err := os.Rename(f.options.Path, rotated); err != nil {
+		// keep writing to the same file rather than losing entries
+		_ = f.open()
+		return err
+	}
+
+	// the rotated file is compressed and pruned even if the new one cannot be opened, which the next write retries
+	err := f.open()
+
+	f.pending.Add(1)
+	go func() {
+		defer f.pending.Done()
+		f.background.Lock()
+		defer f.background.Unlock()
+
+		if f.options.Compress {
+			if err := compress(rotated); err != nil {
+				f.logger.Error("Unable to compress rotated audit log", "path", rotated, "error", err)
+			}
+		}
+
+		if err := f.prune(); err != nil {
+			f.logger.Error("Unable to delete old audit logs", "path", f.options.Path, "error", err)
+		}
+	}()
+
+	return err
+}
+
+// compress replaces the file at path with its gzip-compressed copy, at path.gz.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	err = errors.Join(err, zw.Close(), dst.Close())
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// prune deletes the oldest rotated files, keeping at most MaxFiles of them.
+func (f *File) prune() error {
+	if f.options.MaxFiles <= 0 {
+		return nil
+	}
+
+	rotated, err := f.Rotated()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, path := range rotated[:max(0, len(rotated)-f.options.MaxFiles)] {
+		errs = append(errs, os.Remove(path))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Rotated returns the paths of the rotated files, oldest first.
+func (f *File) Rotated() ([]string, error) {
+	ext := filepath.Ext(f.options.Path)
+	prefix := strings.TrimSuffix(filepath.Base(f.options.Path), ext) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(f.options.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	var rotated []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)[len(prefix):]
+		if _, err := time.Parse(rotatedFormat, stamp); err == nil && (strings.HasSuffix(name, ext) || strings.HasSuffix(name, ext+".gz")) {
+			rotated = append(rotated, filepath.Join(filepath.Dir(f.options.Path), name))
+		}
+	}
+
+	slices.Sort(rotated)
+	return rotated, nil
+}
+
+// Reopen closes the file and opens the one at its path again, e.g. after an external tool (such as logrotate) has moved it.
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+
+	return f.open()
+}
+
+// Close closes the file, after waiting for rotated files to be compressed and pruned.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pending.Wait()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
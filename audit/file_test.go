@@ -0,0 +1,216 @@
+package audit
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(time.Millisecond) // rotated files must have distinct names
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func TestFile_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	c := &clock{now: time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)}
+	f, err := OpenFile(Options{Path: path, MaxSize: 10, MaxFiles: 2, Compress: true}, c.Now, slog.Default())
+	require.NoError(t, err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "fourth\n", string(current))
+
+	// the file with "first" has been deleted
+	rotated, err := f.Rotated()
+	require.NoError(t, err)
+	require.Len(t, rotated, 2)
+	assert.Equal(t, "second\n", gunzip(t, rotated[0]))
+	assert.Equal(t, "third\n", gunzip(t, rotated[1]))
+}
+
+func TestFile_RotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	c := &clock{now: time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)}
+	f, err := OpenFile(Options{Path: path, MaxAge: time.Hour}, c.Now, slog.Default())
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	c.Add(time.Hour)
+	_, err = f.Write([]byte("third\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	rotated, err := f.Rotated()
+	require.NoError(t, err)
+	require.Len(t, rotated, 1)
+	assert.True(t, strings.HasSuffix(rotated[0], "audit-20240902T110000.004.log"), rotated[0])
+
+	content, err := os.ReadFile(rotated[0])
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(content))
+}
+
+func TestFile_KeepsWritingWhenRotationFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+
+	// the file cannot be renamed, as a non-empty directory already has the name of the rotated file
+	target := filepath.Join(dir, "audit-20240902T100000.000.log")
+	require.NoError(t, os.MkdirAll(filepath.Join(target, "taken"), 0o755))
+
+	var logs strings.Builder
+	f, err := OpenFile(Options{Path: path, MaxSize: 10}, func() time.Time { return now }, slog.New(slog.NewTextHandler(&logs, nil)))
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "3\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n3\nfourth\n", string(content))
+
+	// attempted before "second" and, once the file has grown by another MaxSize, before "fourth"
+	assert.Equal(t, 2, strings.Count(logs.String(), "Unable to rotate audit log"))
+
+	require.NoError(t, os.RemoveAll(target))
+	_, err = f.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "fifth\n", string(content))
+}
+
+func TestFile_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenFile(Options{Path: path}, time.Now, slog.Default())
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	// as logrotate would do
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, f.Reopen())
+
+	_, err = f.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(content))
+}
+
+func TestFile_RetriesOpening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenFile(Options{Path: path}, time.Now, slog.Default())
+	require.NoError(t, err)
+	defer f.Close()
+
+	// the file cannot be opened again, as a directory took its place
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, os.Mkdir(path, 0o755))
+	assert.Error(t, f.Reopen())
+
+	_, err = f.Write([]byte("lost\n"))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, os.ErrClosed)
+
+	// the next write opens it
+	require.NoError(t, os.Remove(path))
+	_, err = f.Write([]byte("first\n"))
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(content))
+
+	require.NoError(t, f.Close())
+	_, err = f.Write([]byte("second\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestFile_ConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenFile(Options{Path: path, MaxSize: 100}, (&clock{now: time.Now()}).Now, slog.Default())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_, err := f.Write([]byte("0123456789\n"))
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, f.Close())
+
+	rotated, err := f.Rotated()
+	require.NoError(t, err)
+
+	var lines int
+	for _, p := range append(rotated, path) {
+		content, err := os.ReadFile(p)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(content), 100)
+		lines += strings.Count(string(content), "0123456789\n")
+	}
+	assert.Equal(t, 1000, lines)
+}
+
+func gunzip(t *testing.T, path string) string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	content, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	return string(content)
+}
@@ -1,22 +1,73 @@
 package audit
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"log/slog"
-	"os"
+	"net/netip"
+	"time"
 )
 
+// Privacy modes, determining what is logged about each query
+const (
+	PrivacyFull       = "full"       // everything
+	PrivacyAnonymised = "anonymised" // everything, but the client address is truncated (or replaced by its HMAC, if a key is set)
+	PrivacyNamesOnly  = "names-only" // only blocked (or dropped) queries, without their client nor their response
+)
+
+const (
+	anonymisedBitsIPv4 = 24 // e.g. 192.168.1.20 is logged as 192.168.1.0
+	anonymisedBitsIPv6 = 48
+	hmacSize           = 16 // bytes of the HMAC logged in place of the client address
+)
+
+// Privacy determines what is logged about each query.
+type Privacy struct {
+	Mode string // one of: full (default), anonymised, names-only
+	Key  []byte // if set, anonymised client addresses are replaced by their HMAC-SHA256 rather than truncated
+}
+
+// Record describes a query handled by the DNS server, along with its response.
+type Record struct {
+	ID       uint16
+	Client   netip.Addr
+	Name     string
+	Type     string
+	Decision string   // one of: forwarded, blocked, dropped, paused, local
+	List     string   // list registering the domain, if any
+	RCode    string   // empty if the query has been dropped
+	Answers  []string // e.g. "A 192.0.2.1", "CNAME cdn.example.com"
+}
+
+// Blocked reports whether the query has been blocked (i.e. answered by the sinkhole, or dropped).
+func (r Record) Blocked() bool {
+	return r.Decision == "blocked" || r.Decision == "dropped"
+}
+
 type Logger struct {
 	underlying *slog.Logger
 	enabled    bool
-	file       *os.File
+	privacy    Privacy
+	file       *File
 }
 
-func New(enabled bool) (*Logger, error) {
+// New returns a logger writing to the file described by options, unless disabled.
+func New(enabled bool, options Options, privacy Privacy, logger *slog.Logger) (*Logger, error) {
 	if !enabled {
 		return &Logger{enabled: false}, nil
 	}
 
-	file, err := os.OpenFile(os.TempDir()+"/audit.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	switch privacy.Mode {
+	case "":
+		privacy.Mode = PrivacyFull
+	case PrivacyFull, PrivacyAnonymised, PrivacyNamesOnly:
+	default:
+		return nil, fmt.Errorf("unknown privacy mode: %q", privacy.Mode)
+	}
+
+	file, err := OpenFile(options, time.Now, logger.With("source", "audit"))
 	if err != nil {
 		return nil, err
 	}
@@ -24,16 +75,74 @@ func New(enabled bool) (*Logger, error) {
 	return &Logger{
 		enabled:    true,
 		underlying: slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		privacy:    privacy,
 		file:       file,
 	}, nil
 }
 
-func (l *Logger) Log(id uint16, type_ uint16, query []byte, response []byte) {
+// Enabled reports whether queries are logged at all, so that callers can skip building their records otherwise.
+func (l *Logger) Enabled() bool {
+	return l.enabled
+}
+
+// Log logs a query handled by the DNS server, along with its response, according to the privacy mode.
+func (l *Logger) Log(record Record) {
 	if !l.enabled {
 		return
 	}
 
-	l.underlying.Debug("AUDIT", "id", id, "type", type_, "query", query, "response", response)
+	if l.privacy.Mode == PrivacyNamesOnly {
+		if record.Blocked() {
+			l.underlying.Debug("AUDIT", "name", record.Name, "type", record.Type, "decision", record.Decision, "list", record.List)
+		}
+		return
+	}
+
+	client := record.Client.String()
+	if l.privacy.Mode == PrivacyAnonymised {
+		client = l.anonymise(record.Client)
+	}
+
+	l.underlying.Debug("AUDIT",
+		"id", record.ID,
+		"client", client,
+		"name", record.Name,
+		"type", record.Type,
+		"decision", record.Decision,
+		"list", record.List,
+		"rcode", record.RCode,
+		"answers", record.Answers,
+	)
+}
+
+// anonymise returns the HMAC of the address if a key is set, or the address with its host bits cleared otherwise.
+func (l *Logger) anonymise(addr netip.Addr) string {
+	if len(l.privacy.Key) > 0 {
+		mac := hmac.New(sha256.New, l.privacy.Key)
+		mac.Write(addr.AsSlice())
+		return hex.EncodeToString(mac.Sum(nil)[:hmacSize])
+	}
+
+	bits := anonymisedBitsIPv6
+	if addr.Is4() {
+		bits = anonymisedBitsIPv4
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+
+	return prefix.Addr().String()
+}
+
+// Reopen reopens the file, e.g. after an external tool (such as logrotate) has moved it.
+func (l *Logger) Reopen() error {
+	if !l.enabled {
+		return nil
+	}
+
+	return l.file.Reopen()
 }
 
 func (l *Logger) Close() error {
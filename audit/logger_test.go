@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	forwarded = Record{
+		ID:       0x1234,
+		Client:   netip.MustParseAddr("192.168.1.20"),
+		Name:     "www.example.com",
+		Type:     "A",
+		Decision: "forwarded",
+		RCode:    "NOERROR",
+		Answers:  []string{"CNAME cdn.example.com", "A 192.0.2.1"},
+	}
+	blocked = Record{
+		ID:       0x5678,
+		Client:   netip.MustParseAddr("2001:db8:1:2::20"),
+		Name:     "ads.yyy",
+		Type:     "AAAA",
+		Decision: "blocked",
+		List:     "ads",
+		RCode:    "NOERROR",
+		Answers:  []string{"AAAA ::"},
+	}
+)
+
+// logged logs the records with the provided privacy, returning the fields of each entry of the audit log.
+func logged(t *testing.T, privacy Privacy, records ...Record) []map[string]any {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(true, Options{Path: path}, privacy, slog.Default())
+	require.NoError(t, err)
+
+	for _, record := range records {
+		l.Log(record)
+	}
+	require.NoError(t, l.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		delete(entry, "time")
+		delete(entry, "level")
+		delete(entry, "msg")
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestLogger_Full(t *testing.T) {
+	entries := logged(t, Privacy{}, forwarded)
+	assert.Equal(t, []map[string]any{{
+		"id":       float64(0x1234),
+		"client":   "192.168.1.20",
+		"name":     "www.example.com",
+		"type":     "A",
+		"decision": "forwarded",
+		"list":     "",
+		"rcode":    "NOERROR",
+		"answers":  []any{"CNAME cdn.example.com", "A 192.0.2.1"},
+	}}, entries)
+}
+
+func TestLogger_Anonymised(t *testing.T) {
+	entries := logged(t, Privacy{Mode: PrivacyAnonymised}, forwarded, blocked)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "192.168.1.0", entries[0]["client"])
+	assert.Equal(t, "2001:db8:1::", entries[1]["client"])
+
+	entries = logged(t, Privacy{Mode: PrivacyAnonymised, Key: []byte("secret")}, forwarded, forwarded)
+	require.Len(t, entries, 2)
+	assert.Len(t, entries[0]["client"], 2*hmacSize)
+	assert.NotContains(t, entries[0]["client"], "192.168")
+	// the same client can still be told apart from others
+	assert.Equal(t, entries[0]["client"], entries[1]["client"])
+}
+
+func TestLogger_NamesOnly(t *testing.T) {
+	entries := logged(t, Privacy{Mode: PrivacyNamesOnly}, forwarded, blocked)
+	assert.Equal(t, []map[string]any{{
+		"name":     "ads.yyy",
+		"type":     "AAAA",
+		"decision": "blocked",
+		"list":     "ads",
+	}}, entries)
+}
+
+func TestNew_RejectsUnknownPrivacyMode(t *testing.T) {
+	_, err := New(true, Options{Path: filepath.Join(t.TempDir(), "audit.log")}, Privacy{Mode: "partial"}, slog.Default())
+	assert.EqualError(t, err, `unknown privacy mode: "partial"`)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/custom"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/metrics"
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/schedule"
+	"github.com/fedragon/sinkhole/internal/systemd"
+)
+
+// app holds the policy in effect, applying changes to the sinkhole and saving them to the policy file.
+type app struct {
+	cfg       config.Config
+	sinkhole  *dns.Sinkhole
+	scheduler *schedule.Scheduler
+	custom    *custom.Store
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	pending []string // keys of the settings changed since startup, which only take effect after a restart
+	policy  policy.Policy
+	lists   []dns.List   // lists of the policy, in the same order
+	domains int          // registered by the lists
+	loaded  bool         // whether a policy has been applied yet
+	release func() error // releases the snapshot the lists have been loaded from, if any
+}
+
+// newApp returns an app without lists: Reload loads them, along with the policy.
+func newApp(cfg config.Config, customStore *custom.Store, logger *slog.Logger) *app {
+	scheduler := schedule.NewScheduler(time.Now)
+
+	sinkhole := dns.NewSinkholeWithLists(nil, logger)
+	sinkhole.SetAllowlist(customStore.Allowlist())
+	sinkhole.SetScheduler(scheduler)
+
+	return &app{
+		cfg:       cfg,
+		sinkhole:  sinkhole,
+		scheduler: scheduler,
+		custom:    customStore,
+		logger:    logger,
+		release:   func() error { return nil },
+	}
+}
+
+// Config returns the configuration in effect.
+func (a *app) Config() config.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.cfg
+}
+
+// Policy returns the policy in effect.
+func (a *app) Policy() policy.Policy {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.policy
+}
+
+// PendingRestart returns the keys of the settings changed since startup, which only take effect after a restart.
+func (a *app) PendingRestart() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.pending
+}
+
+// Reload reads the policy and the files of its lists again, replacing the ones in effect.
+func (a *app) Reload() error {
+	return a.SetConfig(a.Config(), a.PendingRestart())
+}
+
+// SetConfig replaces the configuration in effect, reading the policy and the files of its lists accordingly: if any of them is invalid,
+// the configuration in effect is kept. Pending are the keys of the settings changed in the meantime, which cfg does not apply.
+func (a *app) SetConfig(cfg config.Config, pending []string) error {
+	p, err := loadPolicy(cfg)
+	if err != nil {
+		return err
+	}
+
+	lists, release, err := loadLists(cfg, p.Lists, a.logger)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.apply(p, lists, false); err != nil {
+		_ = release()
+		return err
+	}
+	a.cfg, a.pending = cfg, pending
+
+	// the sinkhole no longer uses the previous lists
+	previous := a.release
+	a.release = release
+	return previous()
+}
+
+// Domains returns the number of lists in effect and of the domains they register, or false if none has been loaded yet.
+func (a *app) Domains() (int, int, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.lists), a.domains, a.loaded
+}
+
+// Close releases the lists.
+func (a *app) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.release()
+}
+
+// AddList reads the file of the list and adds it to the policy.
+func (a *app) AddList(list policy.List) (policy.List, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.policy.WithList(list)
+	if err != nil {
+		return list, err
+	}
+
+	if err := p.Validate(); err != nil {
+		return list, err
+	}
+	list, _ = p.List(list.Name)
+
+	l, err := readList(a.cfg, list, a.logger)
+	if err != nil {
+		return list, err
+	}
+
+	return list, a.apply(p, append(slices.Clone(a.lists), l), true)
+}
+
+// SetListDisabled disables (or enables) the list, without reading its file again.
+func (a *app) SetListDisabled(name string, disabled bool) (policy.List, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.policy.WithListDisabled(name, disabled)
+	if err != nil {
+		return policy.List{}, err
+	}
+
+	lists := slices.Clone(a.lists)
+	for i := range lists {
+		if lists[i].Name == name {
+			lists[i].Disabled = disabled
+		}
+	}
+
+	list, _ := p.List(name)
+	return list, a.apply(p, lists, true)
+}
+
+// RemoveList removes the list from the policy, unless any group or schedule refers to it.
+func (a *app) RemoveList(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.policy.WithoutList(name)
+	if err != nil {
+		return err
+	}
+
+	lists := slices.DeleteFunc(slices.Clone(a.lists), func(l dns.List) bool { return l.Name == name })
+	return a.apply(p, lists, true)
+}
+
+// PutGroup adds the group to the policy, replacing any group with the same name.
+func (a *app) PutGroup(group policy.Group) (policy.Group, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p := a.policy.WithGroup(group)
+	if err := a.apply(p, a.lists, true); err != nil {
+		return group, err
+	}
+
+	i := slices.IndexFunc(p.Groups, func(g policy.Group) bool { return g.Name == group.Name })
+	return p.Groups[i], nil
+}
+
+// RemoveGroup removes the group from the policy, unless any schedule refers to it.
+func (a *app) RemoveGroup(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	p, err := a.policy.WithoutGroup(name)
+	if err != nil {
+		return err
+	}
+
+	return a.apply(p, a.lists, true)
+}
+
+// apply validates the policy and, if it is valid, saves it (if requested) and replaces the one in effect, along with its lists.
+// It must be called with a.mu held.
+func (a *app) apply(p policy.Policy, lists []dns.List, save bool) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	matcher, groups, err := newGroups(p)
+	if err != nil {
+		return err
+	}
+
+	schedules, err := schedule.NewSchedules(p)
+	if err != nil {
+		return err
+	}
+
+	if save {
+		if a.cfg.PolicyPath == "" {
+			return fmt.Errorf("%w: no policy path configured", policy.ErrSave)
+		}
+
+		if err := policy.Save(a.cfg.PolicyPath, p); err != nil {
+			return err
+		}
+	}
+
+	// custom entries come first, so that they apply regardless of the lists enabled for each group
+	all := append([]dns.List{{Name: policy.CustomList, Registry: a.custom.Blocklist(), Always: true}}, lists...)
+	a.sinkhole.SetLists(all)
+	a.sinkhole.SetGroups(matcher, groups...)
+	a.scheduler.Set(schedules...)
+
+	var domains int
+	for _, list := range lists {
+		domains += list.Registry.Len()
+	}
+	metrics.NonRoutableDomains.Set(float64(domains))
+	if _, err := systemd.Notify(systemd.Status("Blocking %d domains from %d lists", domains, len(lists))); err != nil {
+		a.logger.Error("Unable to notify systemd", "error", err)
+	}
+
+	a.policy = p
+	a.lists = lists
+	a.domains = domains
+	a.loaded = true
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fedragon/sinkhole/internal/auth"
+)
+
+// hashPassword reads a password from r, writing its hash to w as expected in the credentials file.
+func hashPassword(r io.Reader, w io.Writer) error {
+	password, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("empty password")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, hash)
+	return err
+}
+
+// newToken generates a random API token with the name and scope in args, writing it to w along with the line to add to the credentials file.
+func newToken(args []string, w io.Writer) error {
+	if len(args) != 2 || (args[1] != auth.ScopeRead && args[1] != auth.ScopeAdmin) {
+		return errors.New("usage: new-token <name> <read|admin>")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err := fmt.Fprintf(w, "token: %s\ncredentials: %s %s %s %s\n", token, auth.KindToken, args[0], args[1], auth.HashToken(token))
+	return err
+}
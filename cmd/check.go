@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/custom"
+	"github.com/fedragon/sinkhole/internal/schedule"
+)
+
+// checkConfig checks the files the configuration refers to, which config.Load does not read: the policy and the files of its lists, the
+// custom entries, the local records and the forwarding rules. It reports all the errors found.
+func checkConfig(cfg config.Config) error {
+	var errs []error
+
+	if p, err := loadPolicy(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("policy: %w", err))
+	} else {
+		if _, _, err := newGroups(p); err != nil {
+			errs = append(errs, fmt.Errorf("policy: %w", err))
+		}
+		if _, err := schedule.NewSchedules(p); err != nil {
+			errs = append(errs, fmt.Errorf("policy: %w", err))
+		}
+		for _, list := range p.Lists {
+			if _, err := os.Stat(list.Path); err != nil {
+				errs = append(errs, fmt.Errorf("list %q: %w", list.Name, err))
+			}
+		}
+	}
+
+	if _, err := custom.Load(cfg.CustomPath); err != nil {
+		errs = append(errs, fmt.Errorf("custom entries: %w", err))
+	}
+
+	if _, err := loadRecords(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("records: %w", err))
+	}
+
+	if _, err := forwardingRules(cfg); err != nil {
+		errs = append(errs, fmt.Errorf("forwarding rules: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/health"
+	"github.com/fedragon/sinkhole/internal/upstream"
+)
+
+// how long the serve loop of the DNS server may go without iterating, before the process is deemed unhealthy (unless systemd expects
+// watchdog pings): a single query may take a few seconds, if its upstreams time out
+const defaultStallTimeout = 30 * time.Second
+
+// stallTimeout returns how long the serve loop may go without iterating before it is deemed stalled: half the watchdog interval if
+// systemd expects pings, so that it stops receiving them (and restarts the service) within 1.5 intervals of a stall.
+func stallTimeout(watchdogInterval time.Duration, watchdogEnabled bool) time.Duration {
+	if watchdogEnabled {
+		return watchdogInterval / 2
+	}
+
+	return defaultStallTimeout
+}
+
+// newHealthChecker returns a checker deeming the process alive as long as the serve loop of the DNS server iterates, and ready once the
+// lists are loaded, the DNS server listens and at least one upstream answers probes.
+func newHealthChecker(app *app, server *dns.Server, prober *upstream.Prober, stall time.Duration, started time.Time, logger *slog.Logger) *health.Checker {
+	checker := health.New(logger)
+
+	checker.AddLiveness("process", func() (string, error) {
+		return fmt.Sprintf("version %s, up for %v", Version, time.Since(started).Round(time.Second)), nil
+	})
+	checker.AddLiveness("serve_loop", checkServeLoop(server, stall))
+
+	checker.AddReadiness("blocklist", func() (string, error) {
+		lists, domains, loaded := app.Domains()
+		if !loaded {
+			return "", errors.New("not loaded")
+		}
+		return fmt.Sprintf("%d domains in %d lists", domains, lists), nil
+	})
+	checker.AddReadiness("dns_listener", func() (string, error) {
+		addr := server.Listening()
+		if addr == nil {
+			return "", errors.New("not bound")
+		}
+		return "listening on " + addr.String(), nil
+	})
+	checker.AddReadiness("upstreams", prober.Check)
+
+	return checker
+}
+
+// checkServeLoop returns a check failing once the serve loop of the DNS server has not iterated for longer than stall.
+func checkServeLoop(server *dns.Server, stall time.Duration) health.Check {
+	return func() (string, error) {
+		last := server.LastServed()
+		if last.IsZero() {
+			return "not started yet", nil
+		}
+
+		if since := time.Since(last); since > stall {
+			return "", fmt.Errorf("stalled for %v", since.Round(time.Second))
+		}
+		return "running", nil
+	}
+}
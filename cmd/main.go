@@ -1,8 +1,10 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -10,79 +12,267 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	_ "time/tzdata" // schedules can refer to any timezone, even if the host lacks the timezone database
 
-	"github.com/kelseyhightower/envconfig"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/fedragon/sinkhole/audit"
+	"github.com/fedragon/sinkhole/internal/api"
+	"github.com/fedragon/sinkhole/internal/auth"
+	"github.com/fedragon/sinkhole/internal/capture"
 	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/custom"
+	"github.com/fedragon/sinkhole/internal/dashboard"
 	"github.com/fedragon/sinkhole/internal/dns"
-	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/dnstap"
 	"github.com/fedragon/sinkhole/internal/metrics"
+	"github.com/fedragon/sinkhole/internal/pause"
+	"github.com/fedragon/sinkhole/internal/privileges"
+	"github.com/fedragon/sinkhole/internal/querylog"
+	"github.com/fedragon/sinkhole/internal/records"
+	"github.com/fedragon/sinkhole/internal/stats"
+	"github.com/fedragon/sinkhole/internal/systemd"
+	"github.com/fedragon/sinkhole/internal/tracing"
 	"github.com/fedragon/sinkhole/internal/upstream"
 )
 
 var Version = "dev"
 
+// how often the state of the schedules is exported as metrics
+const schedulerMetricsInterval = 15 * time.Second
+
+// how long pending spans may take to be exported, on shutdown
+const tracingShutdownTimeout = 5 * time.Second
+
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM)
+	started := time.Now()
+
+	// set by failures that must not look like a clean exit (e.g. to systemd, which only restarts failed services), once deferred
+	// functions have run
+	var exitCode int
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	// the level is set once the configuration is loaded, and can be changed by reloading it
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelDebug)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+
+	cfg, err := config.Load(os.Getenv(config.PathEnv))
+	if len(os.Args) > 1 && os.Args[1] == "--check-config" {
+		if err == nil {
+			err = checkConfig(cfg)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Configuration is valid")
+		return
+	}
+	if err != nil {
+		logger.Error("Invalid configuration", "path", os.Getenv(config.PathEnv), "error", err)
+		return
+	}
+	level.Set(cfg.LogLevel)
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compile":
+			pol, err := loadPolicy(cfg)
+			if err != nil {
+				logger.Error("Invalid policy", "path", cfg.PolicyPath, "error", err)
+				return
+			}
 
-	var cfg config.Config
-	if err := envconfig.Process("", &cfg); err != nil {
-		logger.Error("Unable to parse configuration", "error", err)
+			if err := compile(cfg, pol.Lists, logger); err != nil {
+				logger.Error("Unable to compile snapshot", "path", cfg.SnapshotPath, "error", err)
+			}
+		case "hash-password":
+			if err := hashPassword(os.Stdin, os.Stdout); err != nil {
+				logger.Error("Unable to hash password", "error", err)
+			}
+		case "new-token":
+			if err := newToken(os.Args[2:], os.Stdout); err != nil {
+				logger.Error("Unable to generate token", "error", err)
+			}
+		default:
+			logger.Error("Unknown command", "command", os.Args[1])
+		}
 		return
 	}
 
-	auditLogger, err := audit.New(cfg.AuditLogEnabled)
+	if cfg.ChrootDir != "" {
+		if err := checkChroot(cfg); err != nil {
+			logger.Error("Invalid chroot configuration", "directory", cfg.ChrootDir, "error", err)
+			exitCode = 1
+			return
+		}
+	}
+
+	if cfg.TracingEndpoint != "" {
+		provider, err := tracing.NewProvider(ctx, cfg.TracingEndpoint, cfg.TracingSampleRatio, Version)
+		if err != nil {
+			logger.Error("Invalid tracing configuration", "error", err)
+			return
+		}
+		tracing.Enable(provider)
+		defer func() {
+			sctx, scancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer scancel()
+			if err := provider.Shutdown(sctx); err != nil {
+				logger.Error("Unable to shut tracing down", "error", err)
+			}
+		}()
+	}
+
+	privacy := audit.Privacy{Mode: cfg.AuditLogPrivacy}
+	if cfg.AuditLogHMACKeyPath != "" {
+		key, err := os.ReadFile(cfg.AuditLogHMACKeyPath)
+		if err != nil {
+			logger.Error("Unable to read audit log HMAC key", "path", cfg.AuditLogHMACKeyPath, "error", err)
+			return
+		}
+		privacy.Key = bytes.TrimSpace(key)
+	}
+
+	auditLogger, err := audit.New(cfg.AuditLogEnabled, audit.Options{
+		Path:     cfg.AuditLogPath,
+		MaxSize:  cfg.AuditLogMaxSizeMB << 20,
+		MaxAge:   cfg.AuditLogMaxAge,
+		MaxFiles: cfg.AuditLogMaxFiles,
+		Compress: cfg.AuditLogCompress,
+	}, privacy, logger)
 	if err != nil {
-		logger.Error("Unable to create audit logger", "error", err)
+		logger.Error("Unable to create audit logger", "path", cfg.AuditLogPath, "error", err)
 		return
 	}
 	defer auditLogger.Close()
 
-	upstream, err := upstream.NewClient(cfg.UpstreamServerAddr)
+	// SIGHUP reloads the configuration and reopens the audit log (e.g. after logrotate has moved it), once the server is started
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	metrics.NonRoutableDomains.Set(0)
+
+	customEntries, err := custom.Load(cfg.CustomPath)
 	if err != nil {
-		logger.Error("Unable to connect to upstream DNS resolver", "address", cfg.UpstreamServerAddr, "error", err)
+		logger.Error("Unable to load custom entries", "path", cfg.CustomPath, "error", err)
 		return
 	}
-	defer upstream.Close()
 
-	logger.Debug("Reading non-routable domains from hosts file", "path", cfg.HostsPath)
+	app := newApp(cfg, customEntries, logger)
+	if err := app.Reload(); err != nil {
+		logger.Error("Unable to load non-routable domains", "path", cfg.PolicyPath, "error", err)
+		return
+	}
+	defer app.Close()
+
+	sinkhole, scheduler := app.sinkhole, app.scheduler
+
+	pauses := pause.New(time.Now)
+	sinkhole.SetPauser(pauses)
 
-	file, err := os.Open(cfg.HostsPath)
+	localRecords, err := loadRecords(cfg)
 	if err != nil {
-		logger.Error("Unable to open hosts file", "path", cfg.HostsPath, "error", err)
+		logger.Error("Unable to load local records", "path", cfg.RecordsPath, "error", err)
 		return
 	}
-	defer file.Close()
 
-	metrics.NonRoutableDomains.Set(0)
+	queryStats := stats.New(time.Now)
+
+	var queryLog *querylog.Log
+	if cfg.QueryLogPath != "" {
+		queryLog, err = querylog.Open(cfg.QueryLogPath, cfg.QueryLogRetention, time.Now, logger)
+		if err != nil {
+			logger.Error("Unable to open query log", "path", cfg.QueryLogPath, "error", err)
+			return
+		}
+		defer queryLog.Close()
+	}
+
+	var captures *capture.Manager
+	if cfg.ApiEnabled {
+		captures, err = capture.NewManager(cfg.CaptureDir, time.Now, logger)
+		if err != nil {
+			logger.Error("Unable to create capture directory", "path", cfg.CaptureDir, "error", err)
+			return
+		}
+	}
+
+	forwarder, err := newForwarder(cfg)
+	if err != nil {
+		logger.Error("Unable to connect to upstream DNS resolvers", "addresses", cfg.FallbackUpstreams(), "rules", cfg.ForwardingRulesPath, "error", err)
+		return
+	}
+
+	var prober *upstream.Prober
+	if cfg.HealthEnabled {
+		prober = upstream.NewProber(forwarder, cfg.HealthProbeInterval, time.Now)
+	}
 
-	sinkhole := dns.NewSinkhole(logger)
+	server := dns.NewServer(dns.NewLocalRecords(localRecords, logger), sinkhole, forwarder, logger, auditLogger)
+	reloader := newReloader(cfg, app, server, forwarder, prober, level, logger)
+	defer reloader.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
+	httpEnabled := cfg.MetricsEnabled || cfg.DebugEndpointEnabled || cfg.ApiEnabled || cfg.HealthEnabled
+	dnsConn, httpListener, err := listen(cfg, httpEnabled, logger)
+	if err != nil {
+		logger.Error("Unable to listen", "dns_address", cfg.LocalServerAddr, "http_address", cfg.HttpServerAddr, "error", err)
+		return
+	}
 
-	var count int
-	for line := range hosts.Parse(scanner) {
-		if line.Err != nil {
-			logger.Error("Unable to parse hosts file", "error", line.Err)
+	// loaded before dropping privileges, as the key and the credentials may only be readable by root
+	var authenticator *auth.Authenticator
+	if httpEnabled && cfg.HttpAuthPath != "" {
+		if authenticator, err = auth.Load(cfg.HttpAuthPath, logger); err != nil {
+			logger.Error("Unable to load HTTP credentials", "path", cfg.HttpAuthPath, "error", err)
 			return
 		}
+	}
 
-		sinkhole.Register(line.Domain)
-		count++
+	var certificates []tls.Certificate
+	if httpEnabled && cfg.HttpTLSCertPath != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.HttpTLSCertPath, cfg.HttpTLSKeyPath)
+		if err != nil {
+			logger.Error("Unable to load TLS certificate", "certificate", cfg.HttpTLSCertPath, "key", cfg.HttpTLSKeyPath, "error", err)
+			return
+		}
+		certificates = append(certificates, certificate)
 	}
 
-	metrics.NonRoutableDomains.Set(float64(count))
-	logger.Debug("Finished registering non-routable domains", "count", count)
+	if opts := (privileges.Options{User: cfg.RunAsUser, Group: cfg.RunAsGroup, Chroot: cfg.ChrootDir}); opts.Enabled() {
+		// opens the notification socket (if any), which is kept open and could no longer be reached once the root is changed
+		if _, err := systemd.Notify(systemd.Status("Dropping privileges")); err != nil {
+			logger.Error("Unable to notify systemd", "error", err)
+		}
+
+		if err := privileges.Drop(opts); err != nil {
+			logger.Error("Unable to drop privileges", "user", cfg.RunAsUser, "group", cfg.RunAsGroup, "chroot", cfg.ChrootDir, "error", err)
+			exitCode = 1
+			return
+		}
+		logger.Info("Dropped privileges", "uid", os.Getuid(), "gid", os.Getgid(), "chroot", cfg.ChrootDir)
+	}
+
+	watchdogInterval, watchdogEnabled, err := systemd.WatchdogInterval()
+	if err != nil {
+		logger.Error("Invalid watchdog configuration", "error", err)
+		watchdogEnabled = false
+	}
 
 	group, gCtx := errgroup.WithContext(ctx)
-	if cfg.MetricsEnabled || cfg.DebugEndpointEnabled {
+	if httpEnabled {
 		httpHandler := http.ServeMux{}
 
 		if cfg.DebugEndpointEnabled {
@@ -97,18 +287,57 @@ func main() {
 			httpHandler.Handle("/metrics", promhttp.Handler())
 		}
 
+		if cfg.ApiEnabled {
+			managementAPI := api.New(localRecords, customEntries, sinkhole, scheduler, pauses, app, queryStats, logger)
+			if queryLog != nil {
+				managementAPI.SetQueryLog(queryLog)
+			}
+			managementAPI.SetCaptures(captures)
+			managementAPI.Register(&httpHandler)
+		}
+
+		if cfg.DashboardEnabled {
+			dashboard.Register(&httpHandler)
+		}
+
 		httpHandler.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
 			_, _ = w.Write([]byte(Version))
 		})
 
+		var handler http.Handler = &httpHandler
+		if authenticator != nil {
+			handler = authenticator.Wrap(handler)
+		} else if cfg.ApiEnabled {
+			logger.Warn("The management API is enabled without authentication: set HTTP_AUTH_PATH to require credentials")
+		}
+
+		if cfg.HealthEnabled {
+			// health endpoints are served without credentials, so that monitoring and orchestrators can poll them
+			group.Go(func() error {
+				prober.Run(gCtx)
+				return nil
+			})
+
+			healthHandler := http.NewServeMux()
+			newHealthChecker(app, server, prober, stallTimeout(watchdogInterval, watchdogEnabled), started, logger).Register(healthHandler)
+			healthHandler.Handle("/", handler)
+			handler = healthHandler
+		}
+
 		httpServer := &http.Server{
-			Addr:    cfg.HttpServerAddr,
-			Handler: &httpHandler,
+			Addr:      cfg.HttpServerAddr,
+			Handler:   handler,
+			TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12, Certificates: certificates},
 		}
 
 		group.Go(func() error {
-			logger.Debug("Starting HTTP server", "address", cfg.HttpServerAddr)
-			return httpServer.ListenAndServe()
+			if len(certificates) > 0 {
+				logger.Debug("Starting HTTPS server", "address", httpListener.Addr().String())
+				return httpServer.ServeTLS(httpListener, "", "")
+			}
+
+			logger.Debug("Starting HTTP server", "address", httpListener.Addr().String())
+			return httpServer.Serve(httpListener)
 		})
 		group.Go(func() error {
 			<-gCtx.Done()
@@ -120,9 +349,82 @@ func main() {
 	}
 
 	group.Go(func() error {
-		return dns.NewServer(sinkhole, upstream, logger, auditLogger).Serve(gCtx, cfg.LocalServerAddr)
+		return scheduler.Run(gCtx, schedulerMetricsInterval)
+	})
+
+	if cfg.ApiEnabled {
+		server.AddRecorder(queryStats)
+		server.AddTap(captures)
+	}
+	if cfg.DnstapAddr != "" {
+		identity := cfg.DnstapIdentity
+		if identity == "" {
+			identity, _ = os.Hostname()
+		}
+
+		output, err := dnstap.NewOutput(cfg.DnstapAddr, identity, "sinkhole "+Version, logger)
+		if err != nil {
+			logger.Error("Invalid dnstap configuration", "error", err)
+			return
+		}
+		server.AddTap(output)
+		group.Go(func() error {
+			return output.Run(gCtx)
+		})
+	}
+	if queryLog != nil {
+		server.AddRecorder(queryLog)
+		group.Go(func() error {
+			return queryLog.Run(gCtx)
+		})
+	}
+
+	group.Go(func() error {
+		return server.ServeConn(gCtx, dnsConn)
+	})
+
+	// with Type=notify, systemd only deems the service started once the lists are loaded and the sockets bound
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		logger.Error("Unable to notify systemd", "error", err)
+	}
+	group.Go(func() error {
+		<-gCtx.Done()
+		if _, err := systemd.Notify(systemd.Stopping); err != nil {
+			logger.Error("Unable to notify systemd", "error", err)
+		}
+		return nil
 	})
 
+	group.Go(func() error {
+		for {
+			select {
+			case <-gCtx.Done():
+				return nil
+			case <-hangups:
+			}
+
+			if _, err := systemd.Notify(systemd.Reloading); err != nil {
+				logger.Error("Unable to notify systemd", "error", err)
+			}
+			if err := auditLogger.Reopen(); err != nil {
+				logger.Error("Unable to reopen audit log", "path", cfg.AuditLogPath, "error", err)
+			}
+			if err := reloader.Reload(); err != nil {
+				logger.Error("Unable to reload configuration, keeping the one in effect", "path", os.Getenv(config.PathEnv), "error", err)
+			}
+			if _, err := systemd.Notify(systemd.Ready); err != nil {
+				logger.Error("Unable to notify systemd", "error", err)
+			}
+		}
+	})
+
+	if watchdogEnabled {
+		group.Go(func() error {
+			watchdog(gCtx, server, watchdogInterval, logger)
+			return nil
+		})
+	}
+
 	if err := group.Wait(); err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("Fatal error", "error", err)
@@ -131,3 +433,57 @@ func main() {
 		return
 	}
 }
+
+// newForwarder returns a forwarder sending queries to the fallback upstreams, unless a forwarding rule applies to them.
+func newForwarder(cfg config.Config) (*upstream.Forwarder, error) {
+	rules, err := forwardingRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback, err := upstream.NewSet(upstream.TransportUDP, upstream.DefaultTimeout, cfg.FallbackUpstreams()...)
+	if err != nil {
+		return nil, err
+	}
+
+	return upstream.NewForwarder(fallback, rules...)
+}
+
+// forwardingRules returns the forwarding rules of the file at cfg.ForwardingRulesPath, if any, followed by those of the configuration.
+func forwardingRules(cfg config.Config) ([]upstream.Rule, error) {
+	var rules []upstream.Rule
+	if cfg.ForwardingRulesPath != "" {
+		file, err := os.Open(cfg.ForwardingRulesPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		if rules, err = upstream.ParseRules(file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range cfg.Forwarding {
+		rule := upstream.Rule{Addrs: r.Upstreams, Transport: cmp.Or(r.Transport, upstream.TransportUDP), Timeout: cmp.Or(r.Timeout, upstream.DefaultTimeout)}
+		for _, name := range r.Zones {
+			zones, err := upstream.Zones(name)
+			if err != nil {
+				return nil, err
+			}
+			rule.Zones = append(rule.Zones, zones...)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// loadRecords returns the local records of the configuration, if any, or else those of the file at cfg.RecordsPath.
+func loadRecords(cfg config.Config) (*records.Store, error) {
+	if cfg.Records != nil {
+		return records.New(cfg.Records)
+	}
+
+	return records.Load(cfg.RecordsPath)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/clients"
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/registry"
+)
+
+// how often the ARP table is read again, to match clients by MAC address
+const arpTableRefresh = 30 * time.Second
+
+// loadPolicy returns the policy of the configuration or else reads the policy file (if any), falling back to a single list read from the
+// hosts file if the policy defines no lists.
+func loadPolicy(cfg config.Config) (policy.Policy, error) {
+	var p policy.Policy
+	if cfg.Policy != nil {
+		p = *cfg.Policy
+	} else if cfg.PolicyPath != "" {
+		var err error
+		if p, err = policy.Load(cfg.PolicyPath); err != nil {
+			return p, err
+		}
+	}
+
+	if len(p.Lists) == 0 {
+		p.Lists = []policy.List{{Name: dns.DefaultList, Path: cfg.HostsPath, Format: cfg.HostsFormat}}
+	}
+
+	return p, p.Validate()
+}
+
+// newGroups returns the groups of clients defined by the policy, along with the matcher assigning clients to them.
+func newGroups(p policy.Policy) (*clients.Matcher, []*dns.Group, error) {
+	matcher := clients.NewMatcher(clients.NewARPTable(clients.DefaultARPTablePath, arpTableRefresh))
+
+	var groups []*dns.Group
+	for _, g := range p.Groups {
+		for _, client := range g.Clients {
+			if err := matcher.Add(client, g.Name); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		group := &dns.Group{Name: g.Name, Lists: g.Lists, BlockMode: g.BlockMode}
+		if len(g.Allowlist) > 0 {
+			allowlist := registry.NewMap()
+			for _, domain := range g.Allowlist {
+				allowlist.Add(domain, hosts.Rule{Action: hosts.Passthru})
+			}
+			group.Allowlist = allowlist
+		}
+
+		groups = append(groups, group)
+	}
+
+	return matcher, groups, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/fedragon/sinkhole/internal/config"
+)
+
+// checkChroot checks that the files opened once the root has been changed to cfg.ChrootDir can still be found: the working directory
+// must be the new root, so that relative paths keep pointing to the same files, and no path can be absolute.
+func checkChroot(cfg config.Config) error {
+	dir, err := filepath.Abs(cfg.ChrootDir)
+	if err != nil {
+		return err
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	if filepath.Clean(wd) != dir {
+		return fmt.Errorf("the working directory (%s) must be the new root", wd)
+	}
+
+	paths := []struct{ name, path string }{
+		{config.PathEnv, os.Getenv(config.PathEnv)}, // read again on reload
+		{"HOSTS_PATH", cfg.HostsPath},
+		{"POLICY_PATH", cfg.PolicyPath},
+		{"CUSTOM_PATH", cfg.CustomPath},
+		{"SNAPSHOT_PATH", cfg.SnapshotPath},
+		{"RECORDS_PATH", cfg.RecordsPath},
+		{"FORWARDING_RULES_PATH", cfg.ForwardingRulesPath},
+		{"HTTP_AUTH_PATH", cfg.HttpAuthPath},
+		{"QUERY_LOG_PATH", cfg.QueryLogPath},
+		{"CAPTURE_DIR", cfg.CaptureDir},
+		{"AUDIT_LOG_PATH", cfg.AuditLogPath},
+	}
+	for _, p := range paths {
+		if filepath.IsAbs(p.path) {
+			return fmt.Errorf("%s must be relative to the new root: %s", p.name, p.path)
+		}
+	}
+
+	// the ARP table of the host, which resolves the MAC addresses of clients, cannot be read from the new root (an invalid policy is
+	// reported once loaded)
+	if p, err := loadPolicy(cfg); err == nil {
+		for _, group := range p.Groups {
+			for _, client := range group.Clients {
+				if _, err := net.ParseMAC(client); err == nil {
+					return fmt.Errorf("group %q: MAC address clients (%s) cannot be resolved once the root is changed", group.Name, client)
+				}
+			}
+		}
+	}
+
+	return nil
+}
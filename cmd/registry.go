@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/registry"
+)
+
+// loadLists returns the lists of domains: they are read from the snapshot if it is up to date, or parsed from their files otherwise.
+// The returned function releases the snapshot, if any.
+func loadLists(cfg config.Config, lists []policy.List, logger *slog.Logger) ([]dns.List, func() error, error) {
+	noop := func() error { return nil }
+
+	snapshot, err := openSnapshot(cfg, lists)
+	if err != nil {
+		logger.Warn("Ignoring snapshot", "path", cfg.SnapshotPath, "error", err)
+	} else if snapshot != nil {
+		var result []dns.List
+		for _, list := range lists {
+			section, _ := snapshot.Section(list.Name)
+			logger.Debug("Loaded non-routable domains from snapshot", "path", cfg.SnapshotPath, "list", list.Name, "count", section.Registry.Len())
+			result = append(result, dns.List{Name: list.Name, Registry: section.Registry, Disabled: list.Disabled})
+		}
+		return result, snapshot.Close, nil
+	}
+
+	var result []dns.List
+	for _, list := range lists {
+		l, err := readList(cfg, list, logger)
+		if err != nil {
+			return nil, noop, err
+		}
+		result = append(result, l)
+	}
+
+	return result, noop, nil
+}
+
+// readList parses the file of the list into a new registry of the configured kind.
+func readList(cfg config.Config, list policy.List, logger *slog.Logger) (dns.List, error) {
+	var reg dns.Registry
+	switch cfg.Registry {
+	case "map":
+		reg = registry.NewMap()
+	case "compact":
+		reg = registry.NewCompact(cfg.BloomFilterEnabled)
+	default:
+		return dns.List{}, fmt.Errorf("unknown registry: %q", cfg.Registry)
+	}
+
+	if err := parseList(list, reg, logger); err != nil {
+		return dns.List{}, err
+	}
+
+	return dns.List{Name: list.Name, Registry: reg, Disabled: list.Disabled}, nil
+}
+
+// openSnapshot opens the snapshot, if it exists and it is newer than the files of the lists it has been compiled from. It returns nil otherwise.
+func openSnapshot(cfg config.Config, lists []policy.List) (*registry.Snapshot, error) {
+	info, err := os.Stat(cfg.SnapshotPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, list := range lists {
+		listInfo, err := os.Stat(list.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.ModTime().After(listInfo.ModTime()) {
+			return nil, fmt.Errorf("snapshot is older than the file of list %q", list.Name)
+		}
+	}
+
+	snapshot, err := registry.OpenSnapshot(cfg.SnapshotPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, list := range lists {
+		section, ok := snapshot.Section(list.Name)
+		if !ok || section.Source != source(list) {
+			_ = snapshot.Close()
+			return nil, fmt.Errorf("snapshot has been compiled from a different file for list %q", list.Name)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// compile parses the files of the lists and writes a snapshot of their domains, to be loaded at startup.
+func compile(cfg config.Config, lists []policy.List, logger *slog.Logger) error {
+	var sections []registry.Section
+	for _, list := range lists {
+		reg := registry.NewCompact(cfg.BloomFilterEnabled)
+		if err := parseList(list, reg, logger); err != nil {
+			return err
+		}
+		sections = append(sections, registry.Section{Name: list.Name, Source: source(list), Registry: reg})
+	}
+
+	// write to a temporary file first, so that a running sinkhole never sees a partial snapshot
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.SnapshotPath), filepath.Base(cfg.SnapshotPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := registry.WriteSnapshot(tmp, sections); err != nil {
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), cfg.SnapshotPath); err != nil {
+		return err
+	}
+
+	logger.Debug("Compiled snapshot", "path", cfg.SnapshotPath, "lists", len(sections))
+	return nil
+}
+
+// parseList registers the domains listed in the file of the list with reg.
+func parseList(list policy.List, reg dns.Registry, logger *slog.Logger) error {
+	logger.Debug("Reading non-routable domains from list file", "list", list.Name, "path", list.Path)
+
+	file, err := os.Open(list.Path)
+	if err != nil {
+		return fmt.Errorf("unable to open file of list %q: %w", list.Name, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+
+	summary, err := hosts.Load(hosts.Format(list.Format), scanner, reg.Add, func(res hosts.Result) {
+		logger.Warn("Skipping list entry", "list", list.Name, "path", list.Path, "line", res.Line, "reason", res.Warning)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to parse file of list %q: %w", list.Name, err)
+	}
+
+	if compact, ok := reg.(*registry.Compact); ok {
+		compact.Compact()
+	}
+
+	logger.Debug("Finished registering non-routable domains", "list", list.Name, "count", summary.Domains, "duplicates", summary.Duplicates, "skipped", summary.Skipped)
+	return nil
+}
+
+// source describes the file of the list, so that a snapshot is never loaded in place of a different file (or format).
+func source(list policy.List) string {
+	path, err := filepath.Abs(list.Path)
+	if err != nil {
+		path = list.Path
+	}
+
+	return list.Format + ":" + path
+}
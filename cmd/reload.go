@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/upstream"
+)
+
+// how long a replaced forwarder is kept open, so that the queries it is forwarding can complete
+const forwarderDrainTimeout = 10 * time.Second
+
+// reloadable are the keys of the settings applied by a reload: changes to any other setting (e.g. the listen addresses, which would
+// require binding the sockets again) only take effect after a restart.
+var reloadable = []string{
+	"log_level",
+	"upstream_server_addr", "upstreams", "forwarding_rules_path", "forwarding",
+	"hosts_path", "hosts_format", "policy_path", "policy",
+	"registry", "bloom_filter_enabled", "snapshot_path",
+}
+
+// reloader reads the configuration again, applying the changes to the upstreams, the lists, the groups (along with their block modes) and
+// the log level without closing the sockets.
+type reloader struct {
+	initial config.Config // the configuration the process started with
+	app     *app
+	server  *dns.Server
+	prober  *upstream.Prober // nil unless health checks are enabled
+	level   *slog.LevelVar
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	forwarder *upstream.Forwarder
+}
+
+func newReloader(cfg config.Config, app *app, server *dns.Server, forwarder *upstream.Forwarder, prober *upstream.Prober, level *slog.LevelVar, logger *slog.Logger) *reloader {
+	return &reloader{initial: cfg, app: app, server: server, prober: prober, level: level, logger: logger, forwarder: forwarder}
+}
+
+// Reload reads the configuration again and applies it, along with the files it refers to (e.g. the policy, the lists and the forwarding
+// rules): if any of them is invalid, the configuration in effect is kept.
+func (r *reloader) Reload() error {
+	cfg, err := config.Load(os.Getenv(config.PathEnv))
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	forwarder, err := newForwarder(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to connect to upstream DNS resolvers: %w", err)
+	}
+
+	// settings that cannot be reloaded keep the values in effect, which the API reports
+	pending := restartRequired(r.initial, cfg)
+	if err := r.app.SetConfig(r.initial.With(cfg, reloadable), pending); err != nil {
+		_ = forwarder.Close()
+		return fmt.Errorf("unable to load non-routable domains: %w", err)
+	}
+
+	r.server.SetUpstream(forwarder)
+	if r.prober != nil {
+		r.prober.SetForwarder(forwarder)
+	}
+	previous := r.forwarder
+	r.forwarder = forwarder
+	time.AfterFunc(forwarderDrainTimeout, func() { _ = previous.Close() })
+
+	r.level.Set(cfg.LogLevel)
+
+	r.logger.Info("Reloaded configuration", "upstreams", cfg.FallbackUpstreams(), "log_level", cfg.LogLevel)
+	if len(pending) > 0 {
+		r.logger.Warn("Some settings changed since startup require a restart to take effect", "keys", pending)
+	}
+
+	return nil
+}
+
+// Close closes the forwarder in effect.
+func (r *reloader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.forwarder.Close()
+}
+
+// restartRequired returns the keys of the settings changed since startup that a reload does not apply.
+func restartRequired(initial, cfg config.Config) []string {
+	return slices.DeleteFunc(initial.Changed(cfg), func(key string) bool {
+		return slices.Contains(reloadable, key)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/systemd"
+)
+
+// listen returns the sockets of the DNS server and, if httpEnabled, of the HTTP server. Sockets passed by systemd (socket activation)
+// take precedence: the first datagram one is used by the DNS server, the first stream one by the HTTP server. The others are bound to
+// the configured addresses.
+func listen(cfg config.Config, httpEnabled bool, logger *slog.Logger) (*net.UDPConn, net.Listener, error) {
+	files, err := systemd.Files()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dnsConn *net.UDPConn
+	var httpListener net.Listener
+	for _, file := range files {
+		conn, listener := activated(file)
+		switch {
+		case conn != nil && dnsConn == nil:
+			logger.Info("Using DNS socket passed by systemd", "address", conn.LocalAddr().String())
+			dnsConn = conn
+		case listener != nil && httpListener == nil && httpEnabled:
+			logger.Info("Using HTTP socket passed by systemd", "address", listener.Addr().String())
+			httpListener = listener
+		default:
+			logger.Warn("Ignoring socket passed by systemd", "name", file.Name())
+			if conn != nil {
+				_ = conn.Close()
+			}
+			if listener != nil {
+				_ = listener.Close()
+			}
+		}
+	}
+
+	if dnsConn == nil {
+		if dnsConn, err = dns.Listen(cfg.LocalServerAddr); err != nil {
+			if httpListener != nil {
+				_ = httpListener.Close()
+			}
+			return nil, nil, err
+		}
+	}
+
+	if httpListener == nil && httpEnabled {
+		if httpListener, err = net.Listen("tcp", cfg.HttpServerAddr); err != nil {
+			_ = dnsConn.Close()
+			return nil, nil, err
+		}
+	}
+
+	return dnsConn, httpListener, nil
+}
+
+// activated returns the UDP connection or the listener of a socket passed by systemd, if it is either of them. The file is closed, as
+// they use a copy of its descriptor.
+func activated(file *os.File) (*net.UDPConn, net.Listener) {
+	defer file.Close()
+
+	if conn, err := net.FilePacketConn(file); err == nil {
+		if udp, ok := conn.(*net.UDPConn); ok {
+			return udp, nil
+		}
+		_ = conn.Close()
+		return nil, nil
+	}
+
+	if listener, err := net.FileListener(file); err == nil {
+		return nil, listener
+	}
+
+	return nil, nil
+}
+
+// watchdog pings the systemd watchdog twice per interval, as long as the serve loop of the DNS server is running: if it stalls, pings
+// stop and systemd restarts the service.
+func watchdog(ctx context.Context, server *dns.Server, interval time.Duration, logger *slog.Logger) {
+	check := checkServeLoop(server, stallTimeout(interval, true))
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := check(); err != nil {
+			logger.Warn("Skipping watchdog ping", "error", err)
+			continue
+		}
+
+		if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+			logger.Error("Unable to ping watchdog", "error", err)
+		}
+	}
+}
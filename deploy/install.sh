@@ -1,30 +1,37 @@
 #!/usr/bin/env sh
 
-# clean up old sinkhole service (if any)
-active=$(systemctl is-active sinkhole.service 2> /dev/null)
-if [ "$active" = "active" ]; then
-  echo "stopping existing sinkhole.service..."
-  systemctl stop sinkhole.service
-fi
-
-enabled=$(systemctl is-enabled sinkhole.service 2> /dev/null)
-if [ "$enabled" = "enabled" ]; then
-  echo "disabling existing sinkhole.service..."
-  systemctl disable sinkhole.service
-  systemctl daemon-reload
-fi
-
-rm /etc/systemd/system/sinkhole.service
-
-# install new sinkhole service
+# clean up old sinkhole units (if any): the service first, as it requires the socket
+for unit in sinkhole.service sinkhole.socket; do
+  active=$(systemctl is-active "$unit" 2> /dev/null)
+  if [ "$active" = "active" ]; then
+    echo "stopping existing $unit..."
+    systemctl stop "$unit"
+  fi
+
+  enabled=$(systemctl is-enabled "$unit" 2> /dev/null)
+  if [ "$enabled" = "enabled" ]; then
+    echo "disabling existing $unit..."
+    systemctl disable "$unit"
+  fi
+
+  rm -f "/etc/systemd/system/$unit"
+done
+systemctl daemon-reload
+
+# install new sinkhole units
 mkdir -p sink/bin
 mv hosts sink/
 mv hole sink/bin/
 
 mv sinkhole.service /etc/systemd/system/
+mv sinkhole.socket /etc/systemd/system/
+
+echo "compiling hosts snapshot..."
+(cd sink && HOSTS_PATH=hosts SNAPSHOT_PATH=hosts.snapshot bin/hole compile)
 
-echo "enabling sinkhole.service..."
+echo "enabling sinkhole.socket and sinkhole.service..."
 systemctl daemon-reload
+systemctl enable sinkhole.socket
 systemctl enable sinkhole.service
 
-echo "run 'sudo systemctl start sinkhole.service' to start sinkhole"
+echo "run 'sudo systemctl start sinkhole.socket sinkhole.service' to start sinkhole"
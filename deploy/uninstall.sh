@@ -1,17 +1,19 @@
 #!/usr/bin/env sh
 
-# stop sinkhole service (if any)
-active=$(systemctl is-active sinkhole.service 2> /dev/null)
-if [ "$active" = "active" ]; then
-  echo "stopping existing sinkhole.service..."
-  systemctl stop sinkhole.service
-fi
+# stop sinkhole units (if any): the service first, as it requires the socket
+for unit in sinkhole.service sinkhole.socket; do
+  active=$(systemctl is-active "$unit" 2> /dev/null)
+  if [ "$active" = "active" ]; then
+    echo "stopping existing $unit..."
+    systemctl stop "$unit"
+  fi
 
-enabled=$(systemctl is-enabled sinkhole.service 2> /dev/null)
-if [ "$enabled" = "enabled" ]; then
-  echo "disabling existing sinkhole.service..."
-  systemctl disable sinkhole.service
-  systemctl daemon-reload
-fi
+  enabled=$(systemctl is-enabled "$unit" 2> /dev/null)
+  if [ "$enabled" = "enabled" ]; then
+    echo "disabling existing $unit..."
+    systemctl disable "$unit"
+  fi
 
-rm /etc/systemd/system/sinkhole.service
+  rm -f "/etc/systemd/system/$unit"
+done
+systemctl daemon-reload
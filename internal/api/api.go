@@ -0,0 +1,264 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/capture"
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/custom"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/pause"
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/querylog"
+	"github.com/fedragon/sinkhole/internal/records"
+	"github.com/fedragon/sinkhole/internal/schedule"
+	"github.com/fedragon/sinkhole/internal/stats"
+)
+
+var (
+	errUnknownName = errors.New("unknown name")
+	errNotFound    = errors.New("not found")
+)
+
+// OpenAPI description of the API
+//
+//go:embed openapi.yaml
+var spec []byte
+
+// Manager applies changes to the policy, saving them.
+type Manager interface {
+	Config() config.Config
+	// PendingRestart returns the keys of the settings changed since startup, which only take effect after a restart.
+	PendingRestart() []string
+	Policy() policy.Policy
+	AddList(list policy.List) (policy.List, error)
+	SetListDisabled(name string, disabled bool) (policy.List, error)
+	RemoveList(name string) error
+	PutGroup(group policy.Group) (policy.Group, error)
+	RemoveGroup(name string) error
+	// Reload reads the policy and the files of its lists again.
+	Reload() error
+}
+
+// API exposes the management endpoints of the sinkhole, under /api/v1.
+type API struct {
+	records   *records.Store
+	custom    *custom.Store
+	sinkhole  *dns.Sinkhole
+	scheduler *schedule.Scheduler
+	pauses    *pause.Pauses
+	manager   Manager
+	stats     *stats.Stats
+	queryLog  *querylog.Log    // nil if disabled
+	captures  *capture.Manager // nil if disabled
+	logger    *slog.Logger
+}
+
+func New(records *records.Store, custom *custom.Store, sinkhole *dns.Sinkhole, scheduler *schedule.Scheduler, pauses *pause.Pauses, manager Manager,
+	stats *stats.Stats, logger *slog.Logger) *API {
+	return &API{
+		records:   records,
+		custom:    custom,
+		sinkhole:  sinkhole,
+		scheduler: scheduler,
+		pauses:    pauses,
+		manager:   manager,
+		stats:     stats,
+		logger:    logger.With("source", "api"),
+	}
+}
+
+// Register registers the API's routes with mux.
+func (a *API) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/openapi.yaml", a.openAPI)
+	mux.HandleFunc("GET /api/v1/config", a.getConfig)
+	mux.HandleFunc("POST /api/v1/reload", a.reload)
+	mux.HandleFunc("GET /api/v1/stats", a.getStats)
+	mux.HandleFunc("GET /api/v1/queries", a.listQueries)
+	mux.HandleFunc("GET /api/v1/querylog", a.searchQueryLog)
+	mux.HandleFunc("GET /api/v1/captures", a.listCaptures)
+	mux.HandleFunc("POST /api/v1/captures", a.startCapture)
+	mux.HandleFunc("GET /api/v1/captures/{id}", a.getCapture)
+	mux.HandleFunc("POST /api/v1/captures/{id}/stop", a.stopCapture)
+	mux.HandleFunc("GET /api/v1/captures/{id}/pcap", a.downloadCapture)
+	mux.HandleFunc("DELETE /api/v1/captures/{id}", a.removeCapture)
+	mux.HandleFunc("GET /api/v1/domains", a.listDomains)
+	mux.HandleFunc("GET /api/v1/domains/{domain}", a.getDomain)
+	mux.HandleFunc("GET /api/v1/custom", a.listCustom)
+	mux.HandleFunc("POST /api/v1/custom", a.addCustom)
+	mux.HandleFunc("DELETE /api/v1/custom/{action}/{domain}", a.removeCustom)
+	mux.HandleFunc("GET /api/v1/lists", a.listLists)
+	mux.HandleFunc("POST /api/v1/lists", a.addList)
+	mux.HandleFunc("PATCH /api/v1/lists/{name}", a.updateList)
+	mux.HandleFunc("DELETE /api/v1/lists/{name}", a.removeList)
+	mux.HandleFunc("GET /api/v1/groups", a.listGroups)
+	mux.HandleFunc("PUT /api/v1/groups/{name}", a.putGroup)
+	mux.HandleFunc("DELETE /api/v1/groups/{name}", a.removeGroup)
+	mux.HandleFunc("GET /api/v1/records", a.listRecords)
+	mux.HandleFunc("POST /api/v1/records", a.addRecord)
+	mux.HandleFunc("DELETE /api/v1/records/{name}/{type}", a.removeRecord)
+	mux.HandleFunc("GET /api/v1/schedules", a.listSchedules)
+	mux.HandleFunc("GET /api/v1/pauses", a.listPauses)
+	mux.HandleFunc("POST /api/v1/pauses", a.addPause)
+	mux.HandleFunc("DELETE /api/v1/pauses/{scope}", a.removePause)
+	mux.HandleFunc("DELETE /api/v1/pauses/{scope}/{name}", a.removePause)
+	mux.HandleFunc("/api/v1/", a.notFound)
+}
+
+func (a *API) openAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(spec)
+}
+
+func (a *API) notFound(w http.ResponseWriter, r *http.Request) {
+	a.writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s %s", errNotFound, r.Method, r.URL.Path))
+}
+
+func (a *API) listRecords(w http.ResponseWriter, _ *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.records.All())
+}
+
+func (a *API) addRecord(w http.ResponseWriter, r *http.Request) {
+	var record records.Record
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	record, err := a.records.Add(record)
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusCreated, record)
+}
+
+// removeRecord removes the records with the provided name and type, optionally only those with the value in the `value` query parameter.
+func (a *API) removeRecord(w http.ResponseWriter, r *http.Request) {
+	err := a.records.Remove(r.PathValue("name"), r.PathValue("type"), r.URL.Query().Get("value"))
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listSchedules returns the schedules, along with whether they are currently active.
+func (a *API) listSchedules(w http.ResponseWriter, _ *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.scheduler.Status())
+}
+
+// listPauses returns the pauses of blocking that have not expired yet, along with their remaining time.
+func (a *API) listPauses(w http.ResponseWriter, _ *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.pauses.All())
+}
+
+type pauseRequest struct {
+	Scope    string `json:"scope"`    // one of: global, group, list
+	Name     string `json:"name"`     // name of the group or list
+	Duration string `json:"duration"` // e.g. 5m
+}
+
+// addPause pauses blocking globally, or for a group or list, for the requested duration.
+func (a *API) addPause(w http.ResponseWriter, r *http.Request) {
+	var req pauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := a.checkName(req.Scope, req.Name); err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	p, err := a.pauses.Pause(req.Scope, req.Name, duration)
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.logger.Info("Paused blocking", "scope", p.Scope, "name", p.Name, "until", p.Until)
+	a.writeJSON(w, http.StatusCreated, p)
+}
+
+// removePause re-enables blocking before the pause expires.
+func (a *API) removePause(w http.ResponseWriter, r *http.Request) {
+	scope, name := r.PathValue("scope"), r.PathValue("name")
+	if err := a.pauses.Resume(scope, name); err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.logger.Info("Resumed blocking", "scope", scope, "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkName checks that the group or list to pause exists.
+func (a *API) checkName(scope, name string) error {
+	var names []string
+	switch scope {
+	case pause.ScopeGroup:
+		names = a.sinkhole.Groups()
+	case pause.ScopeList:
+		names = a.sinkhole.Lists()
+	default:
+		return nil
+	}
+
+	if name != "" && !slices.Contains(names, name) {
+		return fmt.Errorf("%w: %s %q", errUnknownName, scope, name)
+	}
+
+	return nil
+}
+
+// statusOf maps errors returned by the underlying components to HTTP status codes.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, records.ErrNotFound), errors.Is(err, custom.ErrNotFound), errors.Is(err, policy.ErrNotFound),
+		errors.Is(err, pause.ErrNotPaused), errors.Is(err, errUnknownName), errors.Is(err, errNotFound), errors.Is(err, capture.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, policy.ErrExists), errors.Is(err, capture.ErrRunning):
+		return http.StatusConflict
+	case errors.Is(err, records.ErrSave), errors.Is(err, custom.ErrSave), errors.Is(err, policy.ErrSave), errors.Is(err, capture.ErrFile):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (a *API) writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		a.logger.Error("Unable to handle request", "error", err)
+	}
+
+	a.writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func (a *API) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Error("Unable to write response", "error", err)
+	}
+}
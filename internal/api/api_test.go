@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/capture"
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/custom"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/pause"
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/querylog"
+	"github.com/fedragon/sinkhole/internal/records"
+	"github.com/fedragon/sinkhole/internal/registry"
+	"github.com/fedragon/sinkhole/internal/schedule"
+	"github.com/fedragon/sinkhole/internal/stats"
+)
+
+// manager applies changes to the policy in memory only.
+type manager struct {
+	policy policy.Policy
+}
+
+func (m *manager) Config() config.Config    { return config.Config{Registry: "map"} }
+func (m *manager) Policy() policy.Policy    { return m.policy }
+func (m *manager) PendingRestart() []string { return nil }
+func (m *manager) Reload() error            { return nil }
+func (m *manager) RemoveList(string) error  { return nil }
+
+func (m *manager) AddList(list policy.List) (policy.List, error) {
+	p, err := m.policy.WithList(list)
+	if err != nil {
+		return list, err
+	}
+	m.policy = p
+	return list, nil
+}
+
+func (m *manager) SetListDisabled(name string, disabled bool) (policy.List, error) {
+	p, err := m.policy.WithListDisabled(name, disabled)
+	if err != nil {
+		return policy.List{}, err
+	}
+	m.policy = p
+	list, _ := p.List(name)
+	return list, nil
+}
+
+func (m *manager) PutGroup(group policy.Group) (policy.Group, error) {
+	m.policy = m.policy.WithGroup(group)
+	return group, nil
+}
+
+func (m *manager) RemoveGroup(name string) error {
+	p, err := m.policy.WithoutGroup(name)
+	if err != nil {
+		return err
+	}
+	m.policy = p
+	return nil
+}
+
+func newServer(t *testing.T) (*httptest.Server, *stats.Stats) {
+	a, queryStats := newAPI(t)
+	return serve(t, a), queryStats
+}
+
+// newAPI returns an API managing an "ads" list, along with the statistics it serves.
+func newAPI(t *testing.T) (*API, *stats.Stats) {
+	dir := t.TempDir()
+	localRecords, err := records.Load(filepath.Join(dir, "records"))
+	require.NoError(t, err)
+	customEntries, err := custom.Load(filepath.Join(dir, "custom"))
+	require.NoError(t, err)
+
+	ads := registry.NewMap()
+	for _, domain := range []string{"c.ads.yyy", "a.ads.yyy", "b.ads.yyy", "*.tracker.yyy"} {
+		ads.Add(domain, hosts.Rule{Action: hosts.Block})
+	}
+	ads.Add("safe.yyy", hosts.Rule{Action: hosts.Rewrite, Target: "safe.example.net"})
+
+	sinkhole := dns.NewSinkholeWithLists([]dns.List{
+		{Name: policy.CustomList, Registry: customEntries.Blocklist(), Always: true},
+		{Name: "ads", Registry: ads},
+	}, slog.Default())
+	sinkhole.SetAllowlist(customEntries.Allowlist())
+
+	m := &manager{policy: policy.Policy{Lists: []policy.List{{Name: "ads", Path: "ads.txt", Format: "hosts"}}}}
+	queryStats := stats.New(time.Now)
+
+	return New(localRecords, customEntries, sinkhole, schedule.NewScheduler(time.Now), pause.New(time.Now), m, queryStats, slog.Default()), queryStats
+}
+
+func serve(t *testing.T, a *API) *httptest.Server {
+	mux := http.NewServeMux()
+	a.Register(mux)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func do(t *testing.T, server *httptest.Server, method, path, body string, v any) int {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	res, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	if v != nil {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+	}
+
+	return res.StatusCode
+}
+
+func TestAPI_ListDomains(t *testing.T) {
+	server, _ := newServer(t)
+
+	var page domainsResponse
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/domains?q=ADS&offset=1&limit=1", "", &page))
+	assert.Equal(t, domainsResponse{Total: 3, Domains: []Domain{{Domain: "b.ads.yyy", List: "ads", Action: "block"}}}, page)
+
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/domains?q=safe", "", &page))
+	assert.Equal(t, []Domain{{Domain: "safe.yyy", List: "ads", Action: "rewrite", Target: "safe.example.net"}}, page.Domains)
+
+	page = domainsResponse{}
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, fmt.Sprintf("/api/v1/domains?offset=%d", math.MaxInt), "", &page))
+	assert.Equal(t, domainsResponse{Total: 5, Domains: []Domain{}}, page)
+
+	var res errorResponse
+	assert.Equal(t, http.StatusBadRequest, do(t, server, http.MethodGet, "/api/v1/domains?limit=5000", "", &res))
+	assert.Contains(t, res.Error, "invalid limit")
+	assert.Equal(t, http.StatusNotFound, do(t, server, http.MethodGet, "/api/v1/domains?list=social", "", &res))
+}
+
+func TestAPI_CustomEntries(t *testing.T) {
+	server, _ := newServer(t)
+
+	var entry custom.Entry
+	assert.Equal(t, http.StatusCreated, do(t, server, http.MethodPost, "/api/v1/custom", `{"domain": "Social.yyy", "action": "block"}`, &entry))
+	assert.Equal(t, custom.Entry{Domain: "social.yyy", Action: custom.ActionBlock}, entry)
+	assert.Equal(t, http.StatusCreated, do(t, server, http.MethodPost, "/api/v1/custom", `{"domain": "www.tracker.yyy", "action": "allow"}`, &entry))
+
+	var domain domainResponse
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/domains/social.yyy", "", &domain))
+	assert.Equal(t, domainResponse{Domain: "social.yyy", Matches: []Domain{{Domain: "social.yyy", List: policy.CustomList, Action: "block"}}}, domain)
+
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/domains/www.tracker.yyy", "", &domain))
+	assert.True(t, domain.Allowed)
+	assert.Equal(t, []Domain{{Domain: "www.tracker.yyy", List: "ads", Action: "block"}}, domain.Matches)
+
+	var res errorResponse
+	assert.Equal(t, http.StatusBadRequest, do(t, server, http.MethodPost, "/api/v1/custom", `{"domain": "social.yyy", "action": "deny"}`, &res))
+	assert.Equal(t, http.StatusNotFound, do(t, server, http.MethodDelete, "/api/v1/custom/allow/social.yyy", "", &res))
+	assert.Equal(t, http.StatusNoContent, do(t, server, http.MethodDelete, "/api/v1/custom/block/social.yyy", "", nil))
+	assert.Equal(t, http.StatusNotFound, do(t, server, http.MethodGet, "/api/v1/domains/social.yyy", "", &res))
+}
+
+func TestAPI_ListsAndGroups(t *testing.T) {
+	server, _ := newServer(t)
+
+	var lists []list
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/lists", "", &lists))
+	assert.Equal(t, []list{{List: policy.List{Name: "ads", Path: "ads.txt", Format: "hosts"}, Domains: 5}}, lists)
+
+	var l list
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodPatch, "/api/v1/lists/ads", `{"disabled": true}`, &l))
+	assert.True(t, l.Disabled)
+
+	var res errorResponse
+	assert.Equal(t, http.StatusNotFound, do(t, server, http.MethodPatch, "/api/v1/lists/social", `{"disabled": true}`, &res))
+	assert.Equal(t, http.StatusConflict, do(t, server, http.MethodPost, "/api/v1/lists", `{"name": "ads", "path": "other.txt"}`, &res))
+
+	var group policy.Group
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodPut, "/api/v1/groups/kids", `{"clients": ["192.168.1.20"]}`, &group))
+	assert.Equal(t, "kids", group.Name)
+
+	var groups []policy.Group
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/groups", "", &groups))
+	assert.Len(t, groups, 1)
+	assert.Equal(t, http.StatusNoContent, do(t, server, http.MethodDelete, "/api/v1/groups/kids", "", nil))
+	assert.Equal(t, http.StatusNotFound, do(t, server, http.MethodDelete, "/api/v1/groups/kids", "", &res))
+}
+
+func TestAPI_Stats(t *testing.T) {
+	server, queryStats := newServer(t)
+	for _, name := range []string{"a.ads.yyy", "example.com", "a.ads.yyy"} {
+		queryStats.Record(dns.Event{Time: time.Now(), Client: netip.MustParseAddr("192.168.1.10"), Name: name, Type: message.TypeA, Decision: dns.Blocked})
+	}
+
+	var summary stats.Summary
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/stats?top=1", "", &summary))
+	assert.Equal(t, uint64(3), summary.Total)
+	assert.Equal(t, []stats.Count{{Key: "a.ads.yyy", Count: 2}}, summary.TopBlocked)
+
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/stats?top=1&window=1h", "", &summary))
+	assert.Equal(t, "1h0m0s", summary.Window)
+	assert.Equal(t, []stats.Count{{Key: "192.168.1.10", Count: 3}}, summary.TopClients)
+
+	var res errorResponse
+	assert.Equal(t, http.StatusBadRequest, do(t, server, http.MethodGet, "/api/v1/stats?window=48h", "", &res))
+
+	var queries []stats.Query
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/queries?after=1", "", &queries))
+	assert.Len(t, queries, 2)
+	assert.Equal(t, "example.com", queries[0].Name)
+
+	assert.Equal(t, http.StatusBadRequest, do(t, server, http.MethodGet, "/api/v1/queries?after=-1", "", &res))
+}
+
+func TestAPI_QueryLog(t *testing.T) {
+	a, _ := newAPI(t)
+
+	var res errorResponse
+	assert.Equal(t, http.StatusNotFound, do(t, serve(t, a), http.MethodGet, "/api/v1/querylog", "", &res))
+
+	queryLog, err := querylog.Open(filepath.Join(t.TempDir(), "queries.db"), time.Hour, time.Now, slog.Default())
+	require.NoError(t, err)
+	defer queryLog.Close()
+	a.SetQueryLog(queryLog)
+	server := serve(t, a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, name := range []string{"a.ads.yyy", "example.com", "b.ads.yyy"} {
+		queryLog.Record(dns.Event{Time: time.Now(), Client: netip.MustParseAddr("192.168.1.10"), Name: name, Type: message.TypeA, Decision: dns.Blocked})
+	}
+	cancel()
+	require.NoError(t, queryLog.Run(ctx)) // writes the queued queries
+
+	var page querylog.Page
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, "/api/v1/querylog?name=ads&limit=1", "", &page))
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "b.ads.yyy", page.Entries[0].Name)
+
+	next := page.Next
+	page = querylog.Page{}
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodGet, fmt.Sprintf("/api/v1/querylog?name=ads&before=%d", next), "", &page))
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, "a.ads.yyy", page.Entries[0].Name)
+	assert.Zero(t, page.Next)
+
+	assert.Equal(t, http.StatusBadRequest, do(t, server, http.MethodGet, "/api/v1/querylog?from=yesterday", "", &res))
+	assert.True(t, strings.HasPrefix(res.Error, "invalid from: "), res.Error)
+}
+
+func TestAPI_Captures(t *testing.T) {
+	a, _ := newAPI(t)
+
+	var res errorResponse
+	assert.Equal(t, http.StatusNotFound, do(t, serve(t, a), http.MethodGet, "/api/v1/captures", "", &res))
+
+	captures, err := capture.NewManager(t.TempDir(), time.Now, slog.Default())
+	require.NoError(t, err)
+	a.SetCaptures(captures)
+	server := serve(t, a)
+
+	assert.Equal(t, http.StatusBadRequest, do(t, server, http.MethodPost, "/api/v1/captures", `{"client": "nobody"}`, &res))
+	assert.True(t, strings.HasPrefix(res.Error, "invalid client: "), res.Error)
+	assert.Equal(t, http.StatusBadRequest, do(t, server, http.MethodPost, "/api/v1/captures", `{"duration": "2h"}`, &res))
+
+	var c capture.Capture
+	assert.Equal(t, http.StatusCreated, do(t, server, http.MethodPost, "/api/v1/captures", `{"client": "192.168.1.20", "domain": "example.com"}`, &c))
+	assert.Equal(t, "192.168.1.20", c.Client)
+	assert.Equal(t, int64(capture.DefaultMaxBytes), c.MaxBytes)
+	assert.Equal(t, capture.DefaultDuration, c.Until.Sub(c.Started))
+	assert.Equal(t, http.StatusConflict, do(t, server, http.MethodPost, "/api/v1/captures", `{}`, &res))
+
+	assert.Equal(t, http.StatusOK, do(t, server, http.MethodPost, "/api/v1/captures/"+c.ID+"/stop", "", &c))
+	assert.Equal(t, capture.StoppedByRequest, c.StoppedBy)
+
+	download, err := server.Client().Get(server.URL + "/api/v1/captures/" + c.ID + "/pcap")
+	require.NoError(t, err)
+	defer download.Body.Close()
+	assert.Equal(t, http.StatusOK, download.StatusCode)
+	assert.Equal(t, "application/vnd.tcpdump.pcap", download.Header.Get("Content-Type"))
+	data, err := io.ReadAll(download.Body)
+	require.NoError(t, err)
+	assert.Len(t, data, int(c.Bytes))
+
+	assert.Equal(t, http.StatusNoContent, do(t, server, http.MethodDelete, "/api/v1/captures/"+c.ID, "", nil))
+	assert.Equal(t, http.StatusNotFound, do(t, server, http.MethodGet, "/api/v1/captures/"+c.ID, "", &res))
+}
+
+func TestAPI_ReturnsJSONErrorsForUnknownRoutes(t *testing.T) {
+	server, _ := newServer(t)
+
+	var res errorResponse
+	assert.Equal(t, http.StatusNotFound, do(t, server, http.MethodGet, "/api/v1/unknown", "", &res))
+	assert.Equal(t, "not found: GET /api/v1/unknown", res.Error)
+}
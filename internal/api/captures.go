@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/capture"
+)
+
+// SetCaptures enables capturing DNS traffic: it must be called before serving any request.
+func (a *API) SetCaptures(captures *capture.Manager) {
+	a.captures = captures
+}
+
+type captureRequest struct {
+	Client   string `json:"client"`    // only capture the messages of this client
+	Domain   string `json:"domain"`    // only capture the messages about this domain, or its subdomains
+	Duration string `json:"duration"`  // e.g. 5m, defaults to 1m
+	MaxBytes int64  `json:"max_bytes"` // maximum size of the file, defaults to 10 MiB
+}
+
+// startCapture starts capturing the DNS messages matching the request, until the duration elapses or the file reaches the maximum size.
+func (a *API) startCapture(w http.ResponseWriter, r *http.Request) {
+	if !a.capturesEnabled(w) {
+		return
+	}
+
+	var req captureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var err error
+	filter := capture.Filter{Domain: req.Domain}
+	if req.Client != "" {
+		if filter.Client, err = netip.ParseAddr(req.Client); err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid client: %w", err))
+			return
+		}
+	}
+
+	duration := capture.DefaultDuration
+	if req.Duration != "" {
+		if duration, err = time.ParseDuration(req.Duration); err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %w", err))
+			return
+		}
+	}
+
+	maxBytes := req.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = capture.DefaultMaxBytes
+	}
+
+	c, err := a.captures.Start(filter, duration, maxBytes)
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusCreated, c)
+}
+
+func (a *API) listCaptures(w http.ResponseWriter, _ *http.Request) {
+	if !a.capturesEnabled(w) {
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, a.captures.All())
+}
+
+func (a *API) getCapture(w http.ResponseWriter, r *http.Request) {
+	if !a.capturesEnabled(w) {
+		return
+	}
+
+	c, err := a.captures.Get(r.PathValue("id"))
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, c)
+}
+
+// stopCapture stops a running capture, keeping its file.
+func (a *API) stopCapture(w http.ResponseWriter, r *http.Request) {
+	if !a.capturesEnabled(w) {
+		return
+	}
+
+	c, err := a.captures.Stop(r.PathValue("id"))
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, c)
+}
+
+// downloadCapture returns the pcap file of a capture: if it is still running, only the messages captured so far are returned.
+func (a *API) downloadCapture(w http.ResponseWriter, r *http.Request) {
+	if !a.capturesEnabled(w) {
+		return
+	}
+
+	file, c, err := a.captures.Open(r.PathValue("id"))
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "sinkhole-"+c.ID+".pcap"))
+	w.Header().Set("Content-Length", strconv.FormatInt(c.Bytes, 10))
+	if _, err := io.Copy(w, file); err != nil {
+		a.logger.Error("Unable to write capture", "id", c.ID, "error", err)
+	}
+}
+
+// removeCapture stops a capture, if still running, and deletes its file.
+func (a *API) removeCapture(w http.ResponseWriter, r *http.Request) {
+	if !a.capturesEnabled(w) {
+		return
+	}
+
+	if err := a.captures.Remove(r.PathValue("id")); err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) capturesEnabled(w http.ResponseWriter) bool {
+	if a.captures == nil {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("%w: captures are disabled", errNotFound))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fedragon/sinkhole/internal/custom"
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Domain is a domain registered by a list, along with its rule.
+type Domain struct {
+	Domain string `json:"domain"`
+	List   string `json:"list"`
+	Action string `json:"action"`           // one of: block, nxdomain, nodata, passthru, drop, rewrite
+	Target string `json:"target,omitempty"` // only set for rewrite
+}
+
+type domainsResponse struct {
+	Total   int      `json:"total"` // number of domains matching the search, across all pages
+	Domains []Domain `json:"domains"`
+}
+
+// listDomains returns a page of the registered domains (sorted by name) containing the `q` query parameter, optionally only those
+// registered by the `list` one. Pages are selected via the `offset` and `limit` query parameters.
+func (a *API) listDomains(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	search := strings.ToLower(query.Get("q"))
+	list := query.Get("list")
+
+	offset, err := intParam(query.Get("offset"), 0, -1)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %w", err))
+		return
+	}
+
+	limit, err := intParam(query.Get("limit"), defaultLimit, maxLimit)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+		return
+	}
+
+	lists := a.sinkhole.Lists()
+	if list != "" && !slices.Contains(lists, list) {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("%w: list %q", errUnknownName, list))
+		return
+	}
+
+	// any offset beyond the registered domains selects an empty page: capping it keeps the bound below from overflowing
+	var registered int
+	for _, name := range lists {
+		n, _ := a.sinkhole.Size(name)
+		registered += n
+	}
+	offset = min(offset, registered)
+
+	compare := func(x, y Domain) int {
+		return cmp.Or(strings.Compare(x.Domain, y.Domain), strings.Compare(x.List, y.List))
+	}
+
+	// only keep the first offset+limit domains, sorting and truncating them whenever they grow twice as many
+	bound := offset + limit
+	var total int
+	var page []Domain
+	a.sinkhole.Range(func(name, domain string, rule hosts.Rule) bool {
+		if (list != "" && name != list) || !strings.Contains(domain, search) {
+			return true
+		}
+
+		total++
+		page = append(page, Domain{Domain: domain, List: name, Action: rule.Action.String(), Target: rule.Target})
+		if len(page) >= 2*bound+defaultLimit {
+			slices.SortFunc(page, compare)
+			page = page[:bound]
+		}
+
+		return true
+	})
+
+	slices.SortFunc(page, compare)
+	page = page[min(offset, len(page)):min(bound, len(page))]
+	if page == nil {
+		page = []Domain{}
+	}
+
+	a.writeJSON(w, http.StatusOK, domainsResponse{Total: total, Domains: page})
+}
+
+type domainResponse struct {
+	Domain  string   `json:"domain"`
+	Allowed bool     `json:"allowed"` // whether the domain is allowed for all groups
+	Matches []Domain `json:"matches"`
+}
+
+// getDomain returns the rules registered for the domain (or, via wildcards, for its parent domains) by each list.
+func (a *API) getDomain(w http.ResponseWriter, r *http.Request) {
+	domain, err := hosts.Normalize(r.PathValue("domain"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid domain %q: %w", r.PathValue("domain"), err))
+		return
+	}
+
+	res := domainResponse{Domain: domain, Allowed: a.sinkhole.Allowed(domain), Matches: []Domain{}}
+	for _, match := range a.sinkhole.Find(domain) {
+		res.Matches = append(res.Matches, Domain{Domain: domain, List: match.List, Action: match.Rule.Action.String(), Target: match.Rule.Target})
+	}
+
+	if len(res.Matches) == 0 && !res.Allowed {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("%w: domain %q", errNotFound, domain))
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, res)
+}
+
+func (a *API) listCustom(w http.ResponseWriter, _ *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.custom.All())
+}
+
+// addCustom blocks or allows a domain for all groups, on top of the lists.
+func (a *API) addCustom(w http.ResponseWriter, r *http.Request) {
+	var entry custom.Entry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entry, err := a.custom.Add(entry)
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.logger.Info("Added custom entry", "domain", entry.Domain, "action", entry.Action)
+	a.writeJSON(w, http.StatusCreated, entry)
+}
+
+func (a *API) removeCustom(w http.ResponseWriter, r *http.Request) {
+	action, domain := r.PathValue("action"), r.PathValue("domain")
+	if err := a.custom.Remove(action, domain); err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.logger.Info("Removed custom entry", "domain", domain, "action", action)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// intParam parses the value of a query parameter, returning def if it is empty: the value must not be negative, nor greater than
+// limit (unless it is negative).
+func intParam(value string, def, limit int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 || (limit >= 0 && n > limit) {
+		return 0, fmt.Errorf("out of range: %d", n)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fedragon/sinkhole/internal/config"
+	"github.com/fedragon/sinkhole/internal/policy"
+)
+
+type configResponse struct {
+	Config         config.Config `json:"config"`
+	Policy         policy.Policy `json:"policy"`
+	PendingRestart []string      `json:"pending_restart,omitempty"` // keys of the settings changed since startup, not in effect yet
+}
+
+// getConfig returns the configuration and the policy in effect, along with the settings that only take effect after a restart.
+func (a *API) getConfig(w http.ResponseWriter, _ *http.Request) {
+	a.writeJSON(w, http.StatusOK, configResponse{Config: a.manager.Config(), Policy: a.manager.Policy(), PendingRestart: a.manager.PendingRestart()})
+}
+
+// reload reads the policy and the files of its lists again, e.g. after they have been updated.
+func (a *API) reload(w http.ResponseWriter, _ *http.Request) {
+	if err := a.manager.Reload(); err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.logger.Info("Reloaded policy")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type list struct {
+	policy.List
+	Domains int `json:"domains"` // number of domains registered by the list
+}
+
+func (a *API) listLists(w http.ResponseWriter, _ *http.Request) {
+	lists := []list{}
+	for _, l := range a.manager.Policy().Lists {
+		domains, _ := a.sinkhole.Size(l.Name)
+		lists = append(lists, list{List: l, Domains: domains})
+	}
+
+	a.writeJSON(w, http.StatusOK, lists)
+}
+
+// addList reads the file of the list, adding it to the policy.
+func (a *API) addList(w http.ResponseWriter, r *http.Request) {
+	var req policy.List
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	l, err := a.manager.AddList(req)
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	domains, _ := a.sinkhole.Size(l.Name)
+	a.logger.Info("Added list", "name", l.Name, "path", l.Path, "domains", domains)
+	a.writeJSON(w, http.StatusCreated, list{List: l, Domains: domains})
+}
+
+type listUpdate struct {
+	Disabled bool `json:"disabled"`
+}
+
+// updateList disables (or enables) a list.
+func (a *API) updateList(w http.ResponseWriter, r *http.Request) {
+	var req listUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	l, err := a.manager.SetListDisabled(r.PathValue("name"), req.Disabled)
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	domains, _ := a.sinkhole.Size(l.Name)
+	a.logger.Info("Updated list", "name", l.Name, "disabled", l.Disabled)
+	a.writeJSON(w, http.StatusOK, list{List: l, Domains: domains})
+}
+
+// removeList removes a list from the policy, unless any group or schedule refers to it.
+func (a *API) removeList(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.manager.RemoveList(name); err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.logger.Info("Removed list", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) listGroups(w http.ResponseWriter, _ *http.Request) {
+	groups := a.manager.Policy().Groups
+	if groups == nil {
+		groups = []policy.Group{}
+	}
+
+	a.writeJSON(w, http.StatusOK, groups)
+}
+
+// putGroup creates or replaces the group with the name in the path.
+func (a *API) putGroup(w http.ResponseWriter, r *http.Request) {
+	var group policy.Group
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	group.Name = r.PathValue("name")
+
+	group, err := a.manager.PutGroup(group)
+	if err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.logger.Info("Updated group", "name", group.Name)
+	a.writeJSON(w, http.StatusOK, group)
+}
+
+// removeGroup removes a group from the policy, unless any schedule refers to it.
+func (a *API) removeGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := a.manager.RemoveGroup(name); err != nil {
+		a.writeError(w, statusOf(err), err)
+		return
+	}
+
+	a.logger.Info("Removed group", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/querylog"
+)
+
+// SetQueryLog enables searching the query log: it must be called before serving any request.
+func (a *API) SetQueryLog(queryLog *querylog.Log) {
+	a.queryLog = queryLog
+}
+
+// searchQueryLog returns a page of the query log, newest first, filtered by the query parameters: passing the `next` value of a page as
+// the `before` parameter returns the following one.
+func (a *API) searchQueryLog(w http.ResponseWriter, r *http.Request) {
+	if a.queryLog == nil {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("%w: the query log is disabled", errNotFound))
+		return
+	}
+
+	query := r.URL.Query()
+	filter := querylog.Filter{
+		Client:   query.Get("client"),
+		Name:     query.Get("name"),
+		Type:     query.Get("type"),
+		Decision: query.Get("decision"),
+		List:     query.Get("list"),
+		Upstream: query.Get("upstream"),
+		RCode:    query.Get("rcode"),
+	}
+
+	var err error
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", name, err))
+				return
+			}
+		}
+	}
+
+	if value := query.Get("before"); value != "" {
+		if filter.Before, err = strconv.ParseUint(value, 10, 64); err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid before: %w", err))
+			return
+		}
+	}
+
+	if filter.Limit, err = intParam(query.Get("limit"), defaultLimit, maxLimit); err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+		return
+	}
+
+	page, err := a.queryLog.Search(filter)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, page)
+}
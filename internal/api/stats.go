@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/stats"
+)
+
+const (
+	defaultTop = 10
+	maxTop     = 100
+)
+
+// getStats returns the number of queries over time, along with the domains and clients with the most queries (as many as the `top`
+// query parameter) over the last `window` (e.g. 1h, 24 hours by default).
+func (a *API) getStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	n, err := intParam(query.Get("top"), defaultTop, maxTop)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid top: %w", err))
+		return
+	}
+
+	window := stats.DefaultWindow
+	if value := query.Get("window"); value != "" {
+		if window, err = time.ParseDuration(value); err != nil || window <= 0 || window > stats.DefaultWindow {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid window: must be a positive duration of at most %v", stats.DefaultWindow))
+			return
+		}
+	}
+
+	a.writeJSON(w, http.StatusOK, a.stats.Summary(n, window))
+}
+
+// listQueries returns the recent queries handled after the one with the ID in the `after` query parameter, oldest first: polling it
+// with the ID of the last query returned follows the queries live.
+func (a *API) listQueries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var after uint64
+	if value := query.Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid after: %w", err))
+			return
+		}
+	}
+
+	limit, err := intParam(query.Get("limit"), defaultLimit, maxLimit)
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, a.stats.Queries(after, limit))
+}
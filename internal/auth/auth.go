@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scopes of a credential.
+const (
+	ScopeRead  = "read"  // read-only requests (e.g. metrics, stats)
+	ScopeAdmin = "admin" // all requests, including mutations
+)
+
+// Kinds of credential.
+const (
+	KindToken = "token" // sent as `Authorization: Bearer <token>`
+	KindUser  = "user"  // sent via basic auth
+)
+
+const (
+	maxFailures   = 5           // failed attempts of a client before it is blocked
+	failureWindow = time.Minute // period over which failed attempts are counted
+	blockDuration = time.Minute // how long a client is blocked for
+	tokenPrefix   = "sha256:"
+)
+
+// hash compared against when the user does not exist, so that unknown users cannot be told apart by the response time
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Credential is an API token or a user, along with its scope.
+type Credential struct {
+	Kind  string
+	Name  string
+	Scope string
+	Hash  string // "sha256:<hex>" for tokens, bcrypt hash for users' passwords
+}
+
+// Authenticator checks the credentials of HTTP requests, rate-limiting the clients that fail to authenticate.
+type Authenticator struct {
+	tokens map[string]Credential // keyed by the hex-encoded SHA-256 hash of the token
+	users  map[string]Credential
+	now    func() time.Time
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	failures map[netip.Addr]*failures
+}
+
+type failures struct {
+	count        int
+	since        time.Time
+	blockedUntil time.Time
+}
+
+// Load reads the credentials from the file at path.
+//
+// Each line of the file contains a credential, in the format `token <name> <scope> sha256:<hex>` or `user <name> <scope> <bcrypt hash>`.
+// Blank lines and lines starting with `#` are ignored.
+func Load(path string, logger *slog.Logger) (*Authenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	credentials, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(time.Now, logger, credentials...), nil
+}
+
+// Parse reads credentials from r.
+func Parse(r io.Reader) ([]Credential, error) {
+	var credentials []Credential
+
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected <kind> <name> <scope> <hash>", n)
+		}
+
+		c := Credential{Kind: fields[0], Name: fields[1], Scope: fields[2], Hash: fields[3]}
+		if err := validate(c); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		credentials = append(credentials, c)
+	}
+
+	return credentials, scanner.Err()
+}
+
+func validate(c Credential) error {
+	if c.Scope != ScopeRead && c.Scope != ScopeAdmin {
+		return fmt.Errorf("invalid scope %q: expected one of read, admin", c.Scope)
+	}
+
+	switch c.Kind {
+	case KindToken:
+		digest, ok := strings.CutPrefix(c.Hash, tokenPrefix)
+		if b, err := hex.DecodeString(digest); !ok || err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid hash of token %q: expected %s<hex>", c.Name, tokenPrefix)
+		}
+	case KindUser:
+		if _, err := bcrypt.Cost([]byte(c.Hash)); err != nil {
+			return fmt.Errorf("invalid hash of user %q: %w", c.Name, err)
+		}
+	default:
+		return fmt.Errorf("invalid kind %q: expected one of token, user", c.Kind)
+	}
+
+	return nil
+}
+
+// New returns an Authenticator accepting the provided credentials, blocking clients according to the time returned by now.
+func New(now func() time.Time, logger *slog.Logger, credentials ...Credential) *Authenticator {
+	a := &Authenticator{
+		tokens:   make(map[string]Credential),
+		users:    make(map[string]Credential),
+		now:      now,
+		logger:   logger.With("source", "auth"),
+		failures: make(map[netip.Addr]*failures),
+	}
+
+	for _, c := range credentials {
+		if c.Kind == KindToken {
+			a.tokens[strings.TrimPrefix(c.Hash, tokenPrefix)] = c
+		} else {
+			a.users[c.Name] = c
+		}
+	}
+
+	return a
+}
+
+// HashToken returns the hash of an API token, as stored in the credentials file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenPrefix + hex.EncodeToString(sum[:])
+}
+
+// HashPassword returns the bcrypt hash of a user's password, as stored in the credentials file.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// ScopeOf returns the scope required by the request: read-only methods only need ScopeRead.
+func ScopeOf(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	default:
+		return ScopeAdmin
+	}
+}
+
+// Wrap returns a handler serving the requests authenticated with credentials having the scope they require via next.
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientOf(r)
+		if until, blocked := a.blocked(client); blocked {
+			// round up, so that clients never retry before they are unblocked
+			w.Header().Set("Retry-After", strconv.Itoa(int((until.Sub(a.now())+time.Second-1)/time.Second)))
+			writeError(w, http.StatusTooManyRequests, "too many failed authentication attempts")
+			return
+		}
+
+		c, ok := a.authenticate(r)
+		if !ok {
+			a.fail(client)
+			w.Header().Set("WWW-Authenticate", `Basic realm="sinkhole"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		if c.Scope != ScopeAdmin && ScopeOf(r) == ScopeAdmin {
+			writeError(w, http.StatusForbidden, "forbidden: "+ScopeAdmin+" scope required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the credential the request has been authenticated with, if any.
+func (a *Authenticator) authenticate(r *http.Request) (Credential, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		sum := sha256.Sum256([]byte(token))
+		c, ok := a.tokens[hex.EncodeToString(sum[:])]
+		return c, ok
+	}
+
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return Credential{}, false
+	}
+
+	c, exists := a.users[name]
+	hash := []byte(c.Hash)
+	if !exists {
+		hash = dummyHash()
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !exists {
+		return Credential{}, false
+	}
+
+	return c, true
+}
+
+// blocked reports whether the client is blocked, and until when.
+func (a *Authenticator) blocked(client netip.Addr) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.failures[client]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return f.blockedUntil, a.now().Before(f.blockedUntil)
+}
+
+// fail records a failed attempt of the client, blocking it once it has failed too many times.
+func (a *Authenticator) fail(client netip.Addr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	for addr, f := range a.failures {
+		if now.Sub(f.since) >= failureWindow && !now.Before(f.blockedUntil) {
+			delete(a.failures, addr)
+		}
+	}
+
+	f, ok := a.failures[client]
+	if !ok {
+		f = &failures{since: now}
+		a.failures[client] = f
+	}
+
+	f.count++
+	if f.count >= maxFailures {
+		f.blockedUntil = now.Add(blockDuration)
+		f.count = 0
+		f.since = now
+		a.logger.Warn("Blocking client after too many failed authentication attempts", "client", client, "until", f.blockedUntil)
+	}
+}
+
+func clientOf(r *http.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addrPort.Addr().Unmap()
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
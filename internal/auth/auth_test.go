@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAuthenticator(t *testing.T, now func() time.Time) http.Handler {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	credentials, err := Parse(strings.NewReader(`
+# credentials
+token grafana read ` + HashToken("read-token") + `
+user alice admin ` + string(hash) + `
+`))
+	require.NoError(t, err)
+
+	return New(now, slog.Default(), credentials...).Wrap(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func serve(handler http.Handler, method string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/v1/lists", nil)
+	r.RemoteAddr = "192.168.1.20:40000"
+	if setup != nil {
+		setup(r)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestParse_FailsOnInvalidCredentials(t *testing.T) {
+	for _, input := range []string{
+		"token grafana read",
+		"token grafana write " + HashToken("x"),
+		"token grafana read sha256:abcd",
+		"user alice admin plaintext",
+		"key alice admin " + HashToken("x"),
+	} {
+		_, err := Parse(strings.NewReader(input))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestAuthenticator_EnforcesScopes(t *testing.T) {
+	sut := newAuthenticator(t, time.Now)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(sut, http.MethodGet, nil).Code)
+
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer read-token") }
+	assert.Equal(t, http.StatusOK, serve(sut, http.MethodGet, bearer).Code)
+	assert.Equal(t, http.StatusForbidden, serve(sut, http.MethodPost, bearer).Code)
+
+	basic := func(r *http.Request) { r.SetBasicAuth("alice", "secret") }
+	assert.Equal(t, http.StatusOK, serve(sut, http.MethodGet, basic).Code)
+	assert.Equal(t, http.StatusOK, serve(sut, http.MethodDelete, basic).Code)
+}
+
+func TestAuthenticator_RateLimitsFailedAttempts(t *testing.T) {
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+	sut := newAuthenticator(t, func() time.Time { return now })
+
+	wrong := func(r *http.Request) { r.SetBasicAuth("alice", "guess") }
+	for range maxFailures {
+		assert.Equal(t, http.StatusUnauthorized, serve(sut, http.MethodGet, wrong).Code)
+	}
+
+	// even valid credentials are rejected while the client is blocked
+	res := serve(sut, http.MethodGet, func(r *http.Request) { r.SetBasicAuth("alice", "secret") })
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+
+	// other clients are not affected
+	res = serve(sut, http.MethodGet, func(r *http.Request) {
+		r.RemoteAddr = "192.168.1.30:40000"
+		r.SetBasicAuth("alice", "secret")
+	})
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	now = now.Add(blockDuration)
+	assert.Equal(t, http.StatusOK, serve(sut, http.MethodGet, func(r *http.Request) { r.SetBasicAuth("alice", "secret") }).Code)
+}
@@ -0,0 +1,382 @@
+package capture
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+)
+
+const (
+	DefaultDuration = time.Minute
+	MaxDuration     = time.Hour
+	DefaultMaxBytes = 10 << 20
+	MaxBytes        = 100 << 20
+
+	maxKept = 10 // captures kept on disk, the oldest being deleted first
+)
+
+// Reasons why a capture stopped
+const (
+	StoppedByDuration = "duration"
+	StoppedBySize     = "size"
+	StoppedByRequest  = "request"
+	StoppedByError    = "error"
+)
+
+var (
+	ErrNotFound = errors.New("capture not found")
+	ErrRunning  = errors.New("a capture is already running")
+	ErrInvalid  = errors.New("invalid capture")
+	ErrFile     = errors.New("unable to access capture file")
+)
+
+// Filter selects the packets that are captured: empty fields match all packets.
+type Filter struct {
+	Client netip.Addr
+	Domain string // also matches its subdomains
+}
+
+// matches reports whether the filter matches a message exchanged on behalf of client.
+func (f Filter) matches(client netip.Addr, query []byte) bool {
+	if f.Client.IsValid() && client != f.Client {
+		return false
+	}
+
+	if f.Domain == "" {
+		return true
+	}
+
+	q, err := message.UnmarshalQuery(query)
+	if err != nil {
+		return false
+	}
+
+	name := strings.ToLower(q.Question.Name)
+	return name == f.Domain || strings.HasSuffix(name, "."+f.Domain)
+}
+
+// Capture describes a capture of the DNS messages exchanged by the server.
+type Capture struct {
+	ID        string    `json:"id"`
+	Client    string    `json:"client,omitempty"`
+	Domain    string    `json:"domain,omitempty"`
+	Started   time.Time `json:"started"`
+	Until     time.Time `json:"until"`
+	MaxBytes  int64     `json:"max_bytes"`
+	Bytes     int64     `json:"bytes"`
+	Packets   int       `json:"packets"`
+	Running   bool      `json:"running"`
+	StoppedBy string    `json:"stopped_by,omitempty"` // one of: duration, size, request, error
+}
+
+type capture struct {
+	Capture
+	filter Filter
+	path   string
+	file   *os.File // nil once stopped
+	timer  *time.Timer
+}
+
+// Manager runs captures, one at a time, writing them to pcap files in a directory.
+type Manager struct {
+	dir    string
+	now    func() time.Time
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	captures []*capture // oldest first
+	active   atomic.Pointer[capture]
+}
+
+// NewManager returns a manager writing captures to dir, which is created if needed. Captures left over by a previous run are deleted.
+func NewManager(dir string, now func() time.Time, logger *slog.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*.pcap"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range leftovers {
+		_ = os.Remove(path)
+	}
+
+	return &Manager{dir: dir, now: now, logger: logger.With("source", "capture")}, nil
+}
+
+// Start starts capturing the messages selected by filter, until duration has elapsed or the file would exceed maxBytes.
+func (m *Manager) Start(filter Filter, duration time.Duration, maxBytes int64) (Capture, error) {
+	if duration <= 0 || duration > MaxDuration {
+		return Capture{}, fmt.Errorf("%w: duration must be positive and at most %v", ErrInvalid, MaxDuration)
+	}
+	if maxBytes <= 0 || maxBytes > MaxBytes {
+		return Capture{}, fmt.Errorf("%w: maximum size must be positive and at most %d bytes", ErrInvalid, MaxBytes)
+	}
+	filter.Client = filter.Client.Unmap()
+	filter.Domain = strings.ToLower(strings.TrimSuffix(filter.Domain, "."))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.active.Load() != nil {
+		return Capture{}, ErrRunning
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Capture{}, err
+	}
+
+	path := filepath.Join(m.dir, id+".pcap")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return Capture{}, fmt.Errorf("%w: %w", ErrFile, err)
+	}
+
+	header := fileHeader()
+	if _, err := file.Write(header); err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+		return Capture{}, fmt.Errorf("%w: %w", ErrFile, err)
+	}
+
+	now := m.now()
+	c := &capture{
+		Capture: Capture{
+			ID:       id,
+			Domain:   filter.Domain,
+			Started:  now,
+			Until:    now.Add(duration),
+			MaxBytes: maxBytes,
+			Bytes:    int64(len(header)),
+			Running:  true,
+		},
+		filter: filter,
+		path:   path,
+		file:   file,
+	}
+	if filter.Client.IsValid() {
+		c.Client = filter.Client.String()
+	}
+	c.timer = time.AfterFunc(duration, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.stop(c, StoppedByDuration)
+	})
+
+	m.captures = append(m.captures, c)
+	m.prune()
+	m.active.Store(c)
+
+	m.logger.Info("Started capture", "id", id, "client", c.Client, "domain", c.Domain, "until", c.Until)
+	return c.Capture, nil
+}
+
+// stop stops the capture, if still running: m.mu must be held.
+func (m *Manager) stop(c *capture, reason string) {
+	if c.file == nil {
+		return
+	}
+
+	c.timer.Stop()
+	if err := c.file.Close(); err != nil {
+		m.logger.Error("Unable to close capture", "id", c.ID, "error", err)
+	}
+	c.file, c.Running, c.StoppedBy = nil, false, reason
+	m.active.CompareAndSwap(c, nil)
+
+	m.logger.Info("Stopped capture", "id", c.ID, "reason", reason, "packets", c.Packets, "bytes", c.Bytes)
+}
+
+// prune deletes the oldest captures that are not running, keeping at most maxKept of them: m.mu must be held.
+func (m *Manager) prune() {
+	for len(m.captures) > maxKept && !m.captures[0].Running {
+		if err := os.Remove(m.captures[0].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.logger.Error("Unable to delete capture", "id", m.captures[0].ID, "error", err)
+		}
+		m.captures = m.captures[1:]
+	}
+}
+
+// Stop stops a capture before it reaches its duration or size.
+func (m *Manager) Stop(id string) (Capture, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(id)
+	if err != nil {
+		return Capture{}, err
+	}
+
+	m.stop(c, StoppedByRequest)
+	return c.Capture, nil
+}
+
+// Remove stops a capture, if still running, and deletes its file.
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, c := range m.captures {
+		if c.ID == id {
+			m.stop(c, StoppedByRequest)
+			m.captures = append(m.captures[:i], m.captures[i+1:]...)
+			if err := os.Remove(c.path); err != nil {
+				return fmt.Errorf("%w: %w", ErrFile, err)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// All returns all captures, oldest first.
+func (m *Manager) All() []Capture {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	captures := make([]Capture, 0, len(m.captures))
+	for _, c := range m.captures {
+		captures = append(captures, c.Capture)
+	}
+
+	return captures
+}
+
+// Get returns a capture.
+func (m *Manager) Get(id string) (Capture, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(id)
+	if err != nil {
+		return Capture{}, err
+	}
+
+	return c.Capture, nil
+}
+
+// Open returns a reader of the pcap file of a capture: if it is still running, it only reads the packets captured so far.
+func (m *Manager) Open(id string) (io.ReadCloser, Capture, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, err := m.find(id)
+	if err != nil {
+		return nil, Capture{}, err
+	}
+
+	file, err := os.Open(c.path)
+	if err != nil {
+		return nil, Capture{}, fmt.Errorf("%w: %w", ErrFile, err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, c.Bytes), file}, c.Capture, nil
+}
+
+func (m *Manager) find(id string) (*capture, error) {
+	for _, c := range m.captures {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Tap writes the message to the running capture, if any and if it matches its filter.
+func (m *Manager) Tap(msg dns.TapMessage) {
+	if m.active.Load() == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.active.Load()
+	if c == nil || !c.filter.matches(msg.Client.Addr().Unmap(), msg.Query) {
+		return
+	}
+
+	client, upstream := unmap(msg.Client), unmap(msg.Server)
+
+	var records []byte
+	switch msg.Kind {
+	case dns.ClientQuery:
+		records = record(msg.QueryTime, client, server(upstream, client), msg.Query)
+	case dns.ClientResponse:
+		records = record(msg.ResponseTime, server(upstream, client), client, msg.Response)
+	case dns.ForwarderQuery, dns.ForwarderResponse:
+		// no packet can be made up if no upstream answered; the address the query has been forwarded from is unknown
+		if !upstream.IsValid() {
+			return
+		}
+		local := server(netip.AddrPort{}, upstream)
+
+		if msg.Kind == dns.ForwarderQuery {
+			records = record(msg.QueryTime, local, upstream, msg.Query)
+		} else {
+			records = record(msg.ResponseTime, upstream, local, msg.Response)
+		}
+	default:
+		return
+	}
+
+	if c.Bytes+int64(len(records)) > c.MaxBytes {
+		m.stop(c, StoppedBySize)
+		return
+	}
+
+	n, err := c.file.Write(records)
+	c.Bytes += int64(n)
+	if err != nil {
+		m.logger.Error("Unable to write capture", "id", c.ID, "error", err)
+		m.stop(c, StoppedByError)
+		return
+	}
+	c.Packets++
+}
+
+// server returns the address of the server, as seen by peer: the same family is needed to make up a packet between them.
+func server(addr, peer netip.AddrPort) netip.AddrPort {
+	if addr.IsValid() && addr.Addr().Is4() == peer.Addr().Is4() {
+		return addr
+	}
+
+	if peer.Addr().Is4() {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), addr.Port())
+	}
+	return netip.AddrPortFrom(netip.IPv6Unspecified(), addr.Port())
+}
+
+func unmap(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
+
+// newID returns a random identifier.
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
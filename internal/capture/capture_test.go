@@ -0,0 +1,193 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+)
+
+var (
+	client   = netip.MustParseAddrPort("192.168.1.20:5353")
+	local    = netip.MustParseAddrPort("[::ffff:192.168.1.2]:53")
+	upstream = netip.MustParseAddrPort("[2620:fe::fe]:53")
+)
+
+// query returns a raw query of an A record for name.
+func query(t *testing.T, name string) []byte {
+	data := []byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	encoded, err := message.MarshalName(name)
+	require.NoError(t, err)
+
+	return append(append(data, encoded...), 0x00, 0x01, 0x00, 0x01)
+}
+
+// exchange taps the messages exchanged to answer the raw query of client, forwarded to the upstream.
+func exchange(m *Manager, client netip.AddrPort, raw []byte) {
+	now := time.Now()
+	m.Tap(dns.TapMessage{Kind: dns.ClientQuery, Client: client, Server: local, QueryTime: now, Query: raw})
+	m.Tap(dns.TapMessage{Kind: dns.ForwarderQuery, Client: client, Server: upstream, QueryTime: now, Query: raw})
+	m.Tap(dns.TapMessage{Kind: dns.ForwarderResponse, Client: client, Server: upstream, QueryTime: now, ResponseTime: now, Query: raw, Response: raw})
+	m.Tap(dns.TapMessage{Kind: dns.ClientResponse, Client: client, Server: local, QueryTime: now, ResponseTime: now, Query: raw, Response: raw})
+}
+
+// packets returns the IP packets of a pcap file.
+func packets(t *testing.T, data []byte) [][]byte {
+	require.GreaterOrEqual(t, len(data), 24)
+	assert.Equal(t, fileHeader(), data[:24])
+
+	var packets [][]byte
+	for data = data[24:]; len(data) > 0; {
+		require.GreaterOrEqual(t, len(data), 16)
+		length := binary.BigEndian.Uint32(data[8:])
+		packets, data = append(packets, data[16:16+length]), data[16+length:]
+	}
+
+	return packets
+}
+
+func TestDatagram(t *testing.T) {
+	payload := []byte("payload")
+
+	ipv4 := datagram(netip.MustParseAddrPort("192.168.1.20:5353"), netip.MustParseAddrPort("192.168.1.2:53"), payload)
+	require.Len(t, ipv4, ipv4HeaderSize+udpHeaderSize+len(payload))
+	assert.Equal(t, byte(0x45), ipv4[0])
+	assert.Equal(t, uint16(len(ipv4)), binary.BigEndian.Uint16(ipv4[2:]))
+	assert.Equal(t, []byte{192, 168, 1, 20, 192, 168, 1, 2}, ipv4[12:20])
+	assert.Equal(t, uint16(0xffff), sum(ipv4[:ipv4HeaderSize]), "invalid IPv4 header checksum")
+
+	udp := ipv4[ipv4HeaderSize:]
+	assert.Equal(t, uint16(5353), binary.BigEndian.Uint16(udp))
+	assert.Equal(t, uint16(53), binary.BigEndian.Uint16(udp[2:]))
+	assert.Equal(t, payload, udp[udpHeaderSize:])
+
+	pseudo := append(append([]byte{}, ipv4[12:20]...), 0, protocolUDP, 0, byte(len(udp)))
+	assert.Equal(t, uint16(0xffff), sum(append(pseudo, udp...)), "invalid UDP checksum")
+
+	ipv6 := datagram(netip.MustParseAddrPort("[2001:db8::20]:5353"), netip.MustParseAddrPort("[2001:db8::2]:53"), payload)
+	require.Len(t, ipv6, ipv6HeaderSize+udpHeaderSize+len(payload))
+	assert.Equal(t, byte(0x60), ipv6[0])
+	assert.Equal(t, uint16(udpHeaderSize+len(payload)), binary.BigEndian.Uint16(ipv6[4:]))
+	assert.Equal(t, byte(protocolUDP), ipv6[6])
+
+	udp = ipv6[ipv6HeaderSize:]
+	pseudo = append(append([]byte{}, ipv6[8:40]...), 0, 0, 0, byte(len(udp)), 0, 0, 0, protocolUDP)
+	assert.Equal(t, uint16(0xffff), sum(append(pseudo, udp...)), "invalid UDP checksum")
+}
+
+func TestManager_CapturesMatchingMessages(t *testing.T) {
+	m, err := NewManager(t.TempDir(), time.Now, slog.Default())
+	require.NoError(t, err)
+
+	// nothing to capture yet
+	exchange(m, client, query(t, "www.example.com"))
+
+	c, err := m.Start(Filter{Client: client.Addr(), Domain: "Example.com."}, time.Minute, DefaultMaxBytes)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", c.Domain)
+	assert.True(t, c.Running)
+
+	_, err = m.Start(Filter{}, time.Minute, DefaultMaxBytes)
+	assert.ErrorIs(t, err, ErrRunning)
+
+	exchange(m, client, query(t, "www.example.com"))
+	exchange(m, client, query(t, "example.org"))
+	exchange(m, netip.MustParseAddrPort("192.168.1.30:5353"), query(t, "example.com"))
+
+	c, err = m.Stop(c.ID)
+	require.NoError(t, err)
+	assert.False(t, c.Running)
+	assert.Equal(t, StoppedByRequest, c.StoppedBy)
+	assert.Equal(t, 4, c.Packets)
+
+	file, c, err := m.Open(c.ID)
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, c.Bytes, int64(len(data)))
+
+	captured := packets(t, data)
+	require.Len(t, captured, 4)
+
+	// client query, from the client to the server
+	assert.Equal(t, byte(0x45), captured[0][0])
+	assert.Equal(t, []byte{192, 168, 1, 20, 192, 168, 1, 2}, captured[0][12:20])
+	// forwarder query and response, between an unknown address and the upstream
+	assert.Equal(t, byte(0x60), captured[1][0])
+	assert.Equal(t, upstream.Addr().AsSlice(), captured[1][24:40])
+	assert.Equal(t, upstream.Addr().AsSlice(), captured[2][8:24])
+	// client response, from the server to the client
+	assert.Equal(t, []byte{192, 168, 1, 2, 192, 168, 1, 20}, captured[3][12:20])
+}
+
+func TestManager_StopsCaptures(t *testing.T) {
+	m, err := NewManager(t.TempDir(), time.Now, slog.Default())
+	require.NoError(t, err)
+
+	c, err := m.Start(Filter{}, time.Minute, 200)
+	require.NoError(t, err)
+
+	exchange(m, client, query(t, "example.com"))
+	c, err = m.Get(c.ID)
+	require.NoError(t, err)
+	assert.False(t, c.Running)
+	assert.Equal(t, StoppedBySize, c.StoppedBy)
+	assert.LessOrEqual(t, c.Bytes, int64(200))
+	assert.Positive(t, c.Packets)
+
+	c, err = m.Start(Filter{}, 10*time.Millisecond, DefaultMaxBytes)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		c, err = m.Get(c.ID)
+		return err == nil && !c.Running
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, StoppedByDuration, c.StoppedBy)
+
+	_, err = m.Start(Filter{}, 2*MaxDuration, DefaultMaxBytes)
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = m.Start(Filter{}, time.Minute, 0)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestManager_RemovesCaptures(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "leftover.pcap"), nil, 0o600))
+
+	m, err := NewManager(dir, time.Now, slog.Default())
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "leftover.pcap"))
+
+	var first Capture
+	for i := range maxKept + 1 {
+		c, err := m.Start(Filter{}, time.Minute, DefaultMaxBytes)
+		require.NoError(t, err)
+		_, err = m.Stop(c.ID)
+		require.NoError(t, err)
+
+		if i == 0 {
+			first = c
+		}
+	}
+
+	// the oldest capture is deleted once too many are kept
+	assert.Len(t, m.All(), maxKept)
+	_, err = m.Get(first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoFileExists(t, filepath.Join(dir, first.ID+".pcap"))
+
+	last := m.All()[maxKept-1]
+	require.NoError(t, m.Remove(last.ID))
+	assert.NoFileExists(t, filepath.Join(dir, last.ID+".pcap"))
+	assert.ErrorIs(t, m.Remove(last.ID), ErrNotFound)
+}
@@ -0,0 +1,111 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// pcap file format, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcap-04.html
+const (
+	pcapMagic        = 0xa1b23c4d // timestamps in nanoseconds
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapSnapLen      = 65535
+	linkTypeRaw      = 101 // packets start with an IPv4 or IPv6 header
+
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	udpHeaderSize  = 8
+	protocolUDP    = 17
+	defaultTTL     = 64
+)
+
+var byteOrder = binary.BigEndian
+
+// fileHeader returns the header of a pcap file.
+func fileHeader() []byte {
+	var b []byte
+	b = byteOrder.AppendUint32(b, pcapMagic)
+	b = byteOrder.AppendUint16(b, pcapVersionMajor)
+	b = byteOrder.AppendUint16(b, pcapVersionMinor)
+	b = byteOrder.AppendUint32(b, 0) // reserved
+	b = byteOrder.AppendUint32(b, 0) // reserved
+	b = byteOrder.AppendUint32(b, pcapSnapLen)
+	return byteOrder.AppendUint32(b, linkTypeRaw)
+}
+
+// record returns the pcap record of a UDP datagram carrying payload from src to dst, captured at t. The DNS server only sees payloads:
+// IP and UDP headers are made up, so that tools such as Wireshark can dissect them.
+func record(t time.Time, src, dst netip.AddrPort, payload []byte) []byte {
+	packet := datagram(src, dst, payload)
+
+	var b []byte
+	b = byteOrder.AppendUint32(b, uint32(t.Unix()))
+	b = byteOrder.AppendUint32(b, uint32(t.Nanosecond()))
+	b = byteOrder.AppendUint32(b, uint32(len(packet))) // captured length
+	b = byteOrder.AppendUint32(b, uint32(len(packet))) // original length
+	return append(b, packet...)
+}
+
+// datagram returns an IP packet carrying a UDP datagram with payload from src to dst, which must be of the same family.
+func datagram(src, dst netip.AddrPort, payload []byte) []byte {
+	udpLength := udpHeaderSize + len(payload)
+
+	var udp []byte
+	udp = byteOrder.AppendUint16(udp, src.Port())
+	udp = byteOrder.AppendUint16(udp, dst.Port())
+	udp = byteOrder.AppendUint16(udp, uint16(udpLength))
+	udp = byteOrder.AppendUint16(udp, 0) // checksum, computed below
+	udp = append(udp, payload...)
+
+	// the pseudo-header covered by the UDP checksum
+	var pseudo []byte
+	pseudo = append(pseudo, src.Addr().AsSlice()...)
+	pseudo = append(pseudo, dst.Addr().AsSlice()...)
+	pseudo = byteOrder.AppendUint32(pseudo, protocolUDP)
+	pseudo = byteOrder.AppendUint32(pseudo, uint32(udpLength))
+
+	udpChecksum := ^sum(append(pseudo, udp...))
+	if udpChecksum == 0 {
+		udpChecksum = 0xffff // 0 means that there is no checksum
+	}
+	byteOrder.PutUint16(udp[6:], udpChecksum)
+
+	var ip []byte
+	if src.Addr().Is4() {
+		ip = append(ip, 0x45, 0) // version 4, header of 5 words, no DSCP nor ECN
+		ip = byteOrder.AppendUint16(ip, uint16(ipv4HeaderSize+udpLength))
+		ip = byteOrder.AppendUint32(ip, 0) // identification, flags and fragment offset
+		ip = append(ip, defaultTTL, protocolUDP)
+		ip = byteOrder.AppendUint16(ip, 0) // checksum, computed below
+		ip = append(ip, src.Addr().AsSlice()...)
+		ip = append(ip, dst.Addr().AsSlice()...)
+		byteOrder.PutUint16(ip[10:], ^sum(ip))
+	} else {
+		ip = byteOrder.AppendUint32(ip, 6<<28) // version 6, no traffic class nor flow label
+		ip = byteOrder.AppendUint16(ip, uint16(udpLength))
+		ip = append(ip, protocolUDP, defaultTTL)
+		ip = append(ip, src.Addr().AsSlice()...)
+		ip = append(ip, dst.Addr().AsSlice()...)
+	}
+
+	return append(ip, udp...)
+}
+
+// sum returns the ones' complement sum of b, as 16-bit words.
+func sum(b []byte) uint16 {
+	var s uint32
+	for ; len(b) >= 2; b = b[2:] {
+		s += uint32(byteOrder.Uint16(b))
+	}
+	if len(b) == 1 {
+		s += uint32(b[0]) << 8
+	}
+
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+
+	return uint16(s)
+}
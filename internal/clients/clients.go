@@ -0,0 +1,165 @@
+package clients
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultARPTablePath is where Linux exposes the ARP table of the host.
+const DefaultARPTablePath = "/proc/net/arp"
+
+// Neighbours resolves the addresses of clients to their MAC addresses.
+type Neighbours interface {
+	MAC(addr netip.Addr) (net.HardwareAddr, bool)
+}
+
+type prefixGroup struct {
+	prefix netip.Prefix
+	group  string
+}
+
+// Matcher maps clients to the groups they belong to, identifying them by IP address, CIDR prefix or MAC address.
+type Matcher struct {
+	addrs      map[netip.Addr]string
+	macs       map[string]string
+	prefixes   []prefixGroup // sorted by decreasing length, so that the most specific prefix matches first
+	neighbours Neighbours
+}
+
+// NewMatcher returns an empty Matcher, using neighbours to resolve the MAC addresses of clients.
+func NewMatcher(neighbours Neighbours) *Matcher {
+	return &Matcher{
+		addrs:      make(map[netip.Addr]string),
+		macs:       make(map[string]string),
+		neighbours: neighbours,
+	}
+}
+
+// Add assigns the client (an IP address, a CIDR prefix or a MAC address) to group.
+func (m *Matcher) Add(client, group string) error {
+	if addr, err := netip.ParseAddr(client); err == nil {
+		m.addrs[addr.Unmap()] = group
+		return nil
+	}
+
+	if prefix, err := netip.ParsePrefix(client); err == nil {
+		m.prefixes = append(m.prefixes, prefixGroup{prefix: prefix.Masked(), group: group})
+		slices.SortStableFunc(m.prefixes, func(a, b prefixGroup) int {
+			return b.prefix.Bits() - a.prefix.Bits()
+		})
+		return nil
+	}
+
+	if mac, err := net.ParseMAC(client); err == nil {
+		m.macs[mac.String()] = group
+		return nil
+	}
+
+	return fmt.Errorf("invalid client %q: expected an IP address, a CIDR prefix or a MAC address", client)
+}
+
+// Match returns the group of the client with the provided address, if any. IP addresses take precedence over MAC addresses,
+// which in turn take precedence over CIDR prefixes.
+func (m *Matcher) Match(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+
+	if group, ok := m.addrs[addr]; ok {
+		return group, true
+	}
+
+	if len(m.macs) > 0 && m.neighbours != nil {
+		if mac, ok := m.neighbours.MAC(addr); ok {
+			if group, ok := m.macs[mac.String()]; ok {
+				return group, true
+			}
+		}
+	}
+
+	for _, p := range m.prefixes {
+		if p.prefix.Contains(addr) {
+			return p.group, true
+		}
+	}
+
+	return "", false
+}
+
+// ARPTable resolves the MAC addresses of IPv4 clients from the ARP table of the host, reading it again at most once per refresh interval.
+type ARPTable struct {
+	path    string
+	refresh time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[netip.Addr]net.HardwareAddr
+	readAt  time.Time
+}
+
+func NewARPTable(path string, refresh time.Duration) *ARPTable {
+	return &ARPTable{path: path, refresh: refresh, now: time.Now}
+}
+
+// MAC returns the MAC address of addr, if it is a neighbour of the host.
+func (t *ARPTable) MAC(addr netip.Addr) (net.HardwareAddr, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now := t.now(); t.entries == nil || now.Sub(t.readAt) >= t.refresh {
+		// on failure, keep using the previous entries (if any) until the next refresh
+		if entries, err := readARPTable(t.path); err == nil {
+			t.entries = entries
+		}
+		t.readAt = now
+	}
+
+	mac, ok := t.entries[addr.Unmap()]
+	return mac, ok
+}
+
+func readARPTable(path string) (map[netip.Addr]net.HardwareAddr, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseARPTable(file)
+}
+
+// ParseARPTable parses the entries of an ARP table in the format of /proc/net/arp, skipping incomplete ones.
+func ParseARPTable(r io.Reader) (map[netip.Addr]net.HardwareAddr, error) {
+	entries := make(map[netip.Addr]net.HardwareAddr)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // skip header
+
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil || fields[2] == "0x0" {
+			continue
+		}
+
+		entries[addr] = mac
+	}
+
+	return entries, scanner.Err()
+}
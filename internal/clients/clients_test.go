@@ -0,0 +1,89 @@
+package clients
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type neighbours map[netip.Addr]string
+
+func (n neighbours) MAC(addr netip.Addr) (net.HardwareAddr, bool) {
+	mac, ok := n[addr]
+	if !ok {
+		return nil, false
+	}
+
+	hw, _ := net.ParseMAC(mac)
+	return hw, true
+}
+
+func TestMatcher_Match(t *testing.T) {
+	sut := NewMatcher(neighbours{
+		netip.MustParseAddr("192.168.1.20"): "AA:BB:CC:DD:EE:FF",
+		netip.MustParseAddr("192.168.1.30"): "aa:bb:cc:dd:ee:ff",
+	})
+
+	assert.NoError(t, sut.Add("192.168.0.0/16", "lan"))
+	assert.NoError(t, sut.Add("192.168.1.0/24", "kids"))
+	assert.NoError(t, sut.Add("aa:bb:cc:dd:ee:ff", "tablet"))
+	assert.NoError(t, sut.Add("192.168.1.30", "laptop"))
+	assert.NoError(t, sut.Add("fd00::/64", "lan"))
+	assert.Error(t, sut.Add("tablet.home", "kids"))
+
+	cases := map[string]string{
+		"192.168.1.20":        "tablet",
+		"192.168.1.30":        "laptop",
+		"::ffff:192.168.1.30": "laptop",
+		"192.168.1.40":        "kids",
+		"192.168.2.40":        "lan",
+		"fd00::1":             "lan",
+	}
+	for addr, expected := range cases {
+		group, ok := sut.Match(netip.MustParseAddr(addr))
+		assert.True(t, ok, addr)
+		assert.Equal(t, expected, group, addr)
+	}
+
+	_, ok := sut.Match(netip.MustParseAddr("10.0.0.1"))
+	assert.False(t, ok)
+}
+
+const arpTable = `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.20     0x1         0x2         aa:bb:cc:dd:ee:ff     *        eth0
+192.168.1.30     0x1         0x0         00:00:00:00:00:00     *        eth0
+`
+
+func TestParseARPTable(t *testing.T) {
+	entries, err := ParseARPTable(strings.NewReader(arpTable))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", entries[netip.MustParseAddr("192.168.1.20")].String())
+}
+
+func TestARPTable_RefreshesEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arp")
+	assert.NoError(t, os.WriteFile(path, []byte(arpTable), 0644))
+
+	now := time.Now()
+	sut := NewARPTable(path, time.Minute)
+	sut.now = func() time.Time { return now }
+
+	_, ok := sut.MAC(netip.MustParseAddr("192.168.1.20"))
+	assert.True(t, ok)
+
+	assert.NoError(t, os.WriteFile(path, []byte(strings.ReplaceAll(arpTable, "192.168.1.20", "192.168.1.21")), 0644))
+
+	_, ok = sut.MAC(netip.MustParseAddr("192.168.1.21"))
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = sut.MAC(netip.MustParseAddr("192.168.1.21"))
+	assert.True(t, ok)
+}
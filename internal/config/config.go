@@ -1,18 +1,142 @@
 package config
 
-import "time"
+import (
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
 
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/records"
+)
+
+// Config is read from the file at CONFIG_PATH, if set (see Load), and from environment variables, which take precedence: keys of the file
+// are the names of the variables, in lower case.
 type Config struct {
-	LocalServerAddr    string `envconfig:"LOCAL_SERVER_ADDR" default:"0.0.0.0:1153"`
-	UpstreamServerAddr string `envconfig:"UPSTREAM_SERVER_ADDR" default:"1.1.1.1:53"`
-	HostsPath          string `envconfig:"HOSTS_PATH" default:"./hosts"`
-
-	// HTTP server config: it will only be started if either DebugEndpointEnabled or MetricsEnabled is true
-	HttpServerAddr       string        `envconfig:"HTTP_SERVER_ADDR" default:"0.0.0.0:8000"`
-	HttpShutdownTimeout  time.Duration `envconfig:"HTTP_SHUTDOWN_TIMEOUT" default:"5s"`
-	DebugEndpointEnabled bool          `envconfig:"DEBUG_ENDPOINT_ENABLED" default:"false"`
-	MetricsEnabled       bool          `envconfig:"METRICS_ENABLED" default:"false"`
-
-	// Audit log config
-	AuditLogEnabled bool `envconfig:"AUDIT_LOG_ENABLED" default:"false"`
+	LogLevel slog.Level `envconfig:"LOG_LEVEL" default:"debug" json:"log_level" yaml:"log_level"` // one of: debug, info, warn, error
+
+	LocalServerAddr    string `envconfig:"LOCAL_SERVER_ADDR" default:"0.0.0.0:1153" json:"local_server_addr" yaml:"local_server_addr"`
+	UpstreamServerAddr string `envconfig:"UPSTREAM_SERVER_ADDR" default:"1.1.1.1:53" json:"upstream_server_addr" yaml:"upstream_server_addr"`
+
+	// Upstreams tried in turn, replacing UpstreamServerAddr if set (comma-separated, as an environment variable)
+	Upstreams []string `envconfig:"UPSTREAMS" json:"upstreams,omitempty" yaml:"upstreams"`
+
+	// Conditional forwarding rules, sending the queries for specific zones to other upstreams than UpstreamServerAddr
+	ForwardingRulesPath string           `envconfig:"FORWARDING_RULES_PATH" default:"" json:"forwarding_rules_path" yaml:"forwarding_rules_path"`
+	Forwarding          []ForwardingRule `ignored:"true" json:"forwarding,omitempty" yaml:"forwarding"` // on top of those of the file, only set by the configuration file
+
+	HostsPath   string `envconfig:"HOSTS_PATH" default:"./hosts" json:"hosts_path" yaml:"hosts_path"`
+	HostsFormat string `envconfig:"HOSTS_FORMAT" default:"hosts" json:"hosts_format" yaml:"hosts_format"` // one of: hosts, dnsmasq, rpz
+
+	// Policy defining multiple lists (replacing the hosts file) and the groups of clients they apply to: changes made through the API are saved to the same file
+	PolicyPath string         `envconfig:"POLICY_PATH" default:"./policy.json" json:"policy_path" yaml:"policy_path"`
+	Policy     *policy.Policy `ignored:"true" json:"-" yaml:"policy"` // replaces the policy file if set (only by the configuration file): changes made through the API cannot be saved
+
+	// Custom entries, blocking or allowing domains for all groups on top of the lists: changes made through the API are saved to the same file
+	CustomPath string `envconfig:"CUSTOM_PATH" default:"./custom" json:"custom_path" yaml:"custom_path"`
+
+	// Registry config: "map" is faster, "compact" needs a fraction of the memory (optionally sparing most lookups of missing domains via a Bloom filter)
+	Registry           string `envconfig:"REGISTRY" default:"map" json:"registry" yaml:"registry"`
+	BloomFilterEnabled bool   `envconfig:"BLOOM_FILTER_ENABLED" default:"false" json:"bloom_filter_enabled" yaml:"bloom_filter_enabled"`
+
+	// Snapshot produced by the `compile` command: it is loaded at startup (always as "compact" registry) if newer than the hosts file
+	SnapshotPath string `envconfig:"SNAPSHOT_PATH" default:"./hosts.snapshot" json:"snapshot_path" yaml:"snapshot_path"`
+
+	// Local records, answered before consulting the sinkhole or the upstream: changes made through the API are saved to the same file
+	RecordsPath string           `envconfig:"RECORDS_PATH" default:"./records" json:"records_path" yaml:"records_path"`
+	Records     []records.Record `ignored:"true" json:"-" yaml:"records"` // replace the records file if set (only by the configuration file): changes made through the API cannot be saved
+
+	// HTTP server config: it will only be started if any of DebugEndpointEnabled, MetricsEnabled, ApiEnabled or HealthEnabled is true
+	HttpServerAddr       string        `envconfig:"HTTP_SERVER_ADDR" default:"0.0.0.0:8000" json:"http_server_addr" yaml:"http_server_addr"`
+	HttpShutdownTimeout  time.Duration `envconfig:"HTTP_SHUTDOWN_TIMEOUT" default:"5s" json:"http_shutdown_timeout" yaml:"http_shutdown_timeout"`
+	DebugEndpointEnabled bool          `envconfig:"DEBUG_ENDPOINT_ENABLED" default:"false" json:"debug_endpoint_enabled" yaml:"debug_endpoint_enabled"`
+	MetricsEnabled       bool          `envconfig:"METRICS_ENABLED" default:"false" json:"metrics_enabled" yaml:"metrics_enabled"`
+	ApiEnabled           bool          `envconfig:"API_ENABLED" default:"false" json:"api_enabled" yaml:"api_enabled"`
+	DashboardEnabled     bool          `envconfig:"DASHBOARD_ENABLED" default:"false" json:"dashboard_enabled" yaml:"dashboard_enabled"` // requires ApiEnabled
+
+	// Health endpoints (/healthz and /readyz), never requiring credentials: readiness requires an upstream to answer a recent probe
+	HealthEnabled       bool          `envconfig:"HEALTH_ENABLED" default:"false" json:"health_enabled" yaml:"health_enabled"`
+	HealthProbeInterval time.Duration `envconfig:"HEALTH_PROBE_INTERVAL" default:"10s" json:"health_probe_interval" yaml:"health_probe_interval"`
+
+	// HTTP security: credentials (API tokens and users) required by all endpoints if a path is set, HTTPS if both a certificate and a key are set
+	HttpAuthPath    string `envconfig:"HTTP_AUTH_PATH" default:"" json:"http_auth_path" yaml:"http_auth_path"`
+	HttpTLSCertPath string `envconfig:"HTTP_TLS_CERT_PATH" default:"" json:"http_tls_cert_path" yaml:"http_tls_cert_path"`
+	HttpTLSKeyPath  string `envconfig:"HTTP_TLS_KEY_PATH" default:"" json:"http_tls_key_path" yaml:"http_tls_key_path"`
+
+	// Privileges dropped once the sockets are bound (Linux only, with a binary built with CGO_ENABLED=0): the process switches to RunAsUser
+	// and RunAsGroup (the primary group of RunAsUser if empty), clearing all capabilities, and changes its root to ChrootDir if set
+	RunAsUser  string `envconfig:"RUN_AS_USER" default:"" json:"run_as_user" yaml:"run_as_user"`
+	RunAsGroup string `envconfig:"RUN_AS_GROUP" default:"" json:"run_as_group" yaml:"run_as_group"`
+	ChrootDir  string `envconfig:"CHROOT_DIR" default:"" json:"chroot_dir" yaml:"chroot_dir"` // must be the working directory, all paths being relative to it
+
+	// Query log config: queries (blocked ones included) are stored on disk, and searchable through the API, if a path is set
+	QueryLogPath      string        `envconfig:"QUERY_LOG_PATH" default:"" json:"query_log_path" yaml:"query_log_path"`
+	QueryLogRetention time.Duration `envconfig:"QUERY_LOG_RETENTION" default:"168h" json:"query_log_retention" yaml:"query_log_retention"`
+
+	// dnstap config: messages are sent to the collector at DnstapAddr (unix:<path> or tcp:<host>:<port>), if set
+	DnstapAddr     string `envconfig:"DNSTAP_ADDR" default:"" json:"dnstap_addr" yaml:"dnstap_addr"`
+	DnstapIdentity string `envconfig:"DNSTAP_IDENTITY" default:"" json:"dnstap_identity" yaml:"dnstap_identity"` // the host name, if empty
+
+	// Tracing config: spans of the queries are exported to the OTLP/HTTP collector at TracingEndpoint (e.g. http://localhost:4318), if set
+	TracingEndpoint    string  `envconfig:"TRACING_ENDPOINT" default:"" json:"tracing_endpoint" yaml:"tracing_endpoint"`
+	TracingSampleRatio float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1" json:"tracing_sample_ratio" yaml:"tracing_sample_ratio"` // between 0 and 1
+
+	// Packet captures, started through the API (requires ApiEnabled), are written to this directory
+	CaptureDir string `envconfig:"CAPTURE_DIR" default:"./captures" json:"capture_dir" yaml:"capture_dir"`
+
+	// Audit log config: the file is rotated once it exceeds the size or age limit (unless 0), keeping at most AuditLogMaxFiles rotated files
+	AuditLogEnabled   bool          `envconfig:"AUDIT_LOG_ENABLED" default:"false" json:"audit_log_enabled" yaml:"audit_log_enabled"`
+	AuditLogPath      string        `envconfig:"AUDIT_LOG_PATH" default:"./audit.log" json:"audit_log_path" yaml:"audit_log_path"`
+	AuditLogMaxSizeMB int64         `envconfig:"AUDIT_LOG_MAX_SIZE_MB" default:"100" json:"audit_log_max_size_mb" yaml:"audit_log_max_size_mb"`
+	AuditLogMaxAge    time.Duration `envconfig:"AUDIT_LOG_MAX_AGE" default:"24h" json:"audit_log_max_age" yaml:"audit_log_max_age"`
+	AuditLogMaxFiles  int           `envconfig:"AUDIT_LOG_MAX_FILES" default:"7" json:"audit_log_max_files" yaml:"audit_log_max_files"`
+	AuditLogCompress  bool          `envconfig:"AUDIT_LOG_COMPRESS" default:"true" json:"audit_log_compress" yaml:"audit_log_compress"`
+
+	// Audit log privacy: full, anonymised (client addresses truncated, or replaced by their HMAC if a key is set) or names-only (blocked queries only)
+	AuditLogPrivacy     string `envconfig:"AUDIT_LOG_PRIVACY" default:"full" json:"audit_log_privacy" yaml:"audit_log_privacy"`
+	AuditLogHMACKeyPath string `envconfig:"AUDIT_LOG_HMAC_KEY_PATH" default:"" json:"audit_log_hmac_key_path" yaml:"audit_log_hmac_key_path"`
+}
+
+// Changed returns the keys of the settings whose values differ in other, in the order of the fields.
+func (c Config) Changed(other Config) []string {
+	var keys []string
+
+	v, o := reflect.ValueOf(c), reflect.ValueOf(other)
+	for i := range v.NumField() {
+		if !reflect.DeepEqual(v.Field(i).Interface(), o.Field(i).Interface()) {
+			keys = append(keys, v.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+
+	return keys
+}
+
+// With returns a copy of the configuration taking the settings of the provided keys from other.
+func (c Config) With(other Config, keys []string) Config {
+	v, o := reflect.ValueOf(&c).Elem(), reflect.ValueOf(other)
+	for i := range v.NumField() {
+		if slices.Contains(keys, v.Type().Field(i).Tag.Get("yaml")) {
+			v.Field(i).Set(o.Field(i))
+		}
+	}
+
+	return c
+}
+
+// ForwardingRule forwards the queries for a set of zones (and all their subdomains) to a set of upstreams, like the rules of the file at
+// ForwardingRulesPath.
+type ForwardingRule struct {
+	Zones     []string      `json:"zones" yaml:"zones"` // domain names or IP prefixes, whose reverse lookups are forwarded
+	Upstreams []string      `json:"upstreams" yaml:"upstreams"`
+	Transport string        `json:"transport,omitempty" yaml:"transport"` // one of: udp (default), tcp
+	Timeout   time.Duration `json:"timeout,omitempty" yaml:"timeout"`     // 1s if omitted
+}
+
+// FallbackUpstreams returns the upstreams of the queries that no forwarding rule applies to.
+func (c Config) FallbackUpstreams() []string {
+	if len(c.Upstreams) > 0 {
+		return c.Upstreams
+	}
+
+	return []string{c.UpstreamServerAddr}
 }
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_EnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, `
+local_server_addr: 127.0.0.1:1053
+upstreams: [9.9.9.9:53, 149.112.112.112:53]
+registry: compact
+http_shutdown_timeout: 10s
+`)
+	t.Setenv("REGISTRY", "map")
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:1053", cfg.LocalServerAddr)
+	assert.Equal(t, []string{"9.9.9.9:53", "149.112.112.112:53"}, cfg.FallbackUpstreams())
+	assert.Equal(t, "map", cfg.Registry)
+	assert.Equal(t, 10*time.Second, cfg.HttpShutdownTimeout)
+	assert.Equal(t, "./hosts", cfg.HostsPath)
+}
+
+func TestLoad_RejectsUnknownKeys(t *testing.T) {
+	_, err := Load(writeConfig(t, "upstream: 9.9.9.9:53\n"))
+	assert.ErrorContains(t, err, "field upstream not found")
+}
+
+func TestLoad_InlinePolicyAndRecordsReplaceFiles(t *testing.T) {
+	path := writeConfig(t, `
+policy:
+  lists:
+    - name: ads
+      path: ./ads
+  groups:
+    - name: kids
+      clients: [192.168.1.10]
+      lists: [ads]
+records:
+  - name: nas.home
+    type: A
+    value: 192.168.1.2
+`)
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.Policy)
+	assert.Equal(t, "ads", cfg.Policy.Lists[0].Name)
+	assert.Empty(t, cfg.PolicyPath)
+	assert.Len(t, cfg.Records, 1)
+	assert.Empty(t, cfg.RecordsPath)
+}
+
+func TestLoad_ReportsAllErrors(t *testing.T) {
+	path := writeConfig(t, `
+local_server_addr: localhost
+hosts_format: csv
+dashboard_enabled: true
+forwarding:
+  - zones: [corp.example]
+    upstreams: [10.8.0.1:53]
+    transport: quic
+policy:
+  groups:
+    - name: kids
+      clients: [192.168.1.10]
+    - name: guests
+      clients: [192.168.1.10/32]
+`)
+
+	_, err := Load(path)
+	require.Error(t, err)
+	for _, msg := range []string{
+		"local_server_addr:",
+		"hosts_format:",
+		"dashboard_enabled:",
+		"forwarding[0]: unknown transport",
+		`duplicate client "192.168.1.10/32", already in group "kids"`,
+	} {
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "sinkhole.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestLoad_LogLevel(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "log_level: warn\n"))
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, cfg.LogLevel)
+
+	_, err = Load(writeConfig(t, "log_level: verbose\n"))
+	assert.Error(t, err)
+}
+
+func TestConfig_Changed(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Changed(cfg))
+
+	other := cfg
+	other.LocalServerAddr = "127.0.0.1:53"
+	other.Upstreams = []string{"9.9.9.9:53"}
+	assert.Equal(t, []string{"local_server_addr", "upstreams"}, cfg.Changed(other))
+}
+
+func TestConfig_With(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+
+	other := cfg
+	other.LocalServerAddr = "127.0.0.1:53"
+	other.Upstreams = []string{"9.9.9.9:53"}
+
+	merged := cfg.With(other, []string{"upstreams", "registry"})
+	assert.Equal(t, cfg.LocalServerAddr, merged.LocalServerAddr)
+	assert.Equal(t, []string{"9.9.9.9:53"}, merged.Upstreams)
+	assert.Equal(t, []string{"local_server_addr"}, merged.Changed(other))
+}
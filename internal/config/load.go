@@ -0,0 +1,88 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// PathEnv is the environment variable holding the path of the configuration file, if any.
+const PathEnv = "CONFIG_PATH"
+
+// Load reads the configuration from the YAML file at path (if not empty) and from environment variables, which take precedence over the
+// file, which in turn takes precedence over default values. It then validates the configuration.
+func Load(path string) (Config, error) {
+	var cfg Config
+	if err := envconfig.Process("", &cfg); err != nil {
+		return cfg, err
+	}
+
+	if path == "" {
+		return cfg, cfg.Validate()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	env := cfg
+	keys, err := decode(data, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// variables that are set override the keys of the file
+	v, e := reflect.ValueOf(&cfg).Elem(), reflect.ValueOf(env)
+	for i := range v.NumField() {
+		if name := v.Type().Field(i).Tag.Get("envconfig"); name != "" {
+			if _, ok := os.LookupEnv(name); ok {
+				v.Field(i).Set(e.Field(i))
+			}
+		}
+	}
+
+	// the policy and the records of the file replace the files holding them, unless their paths are set explicitly
+	if cfg.Policy != nil && !explicit(keys, "policy_path") {
+		cfg.PolicyPath = ""
+	}
+	if cfg.Records != nil && !explicit(keys, "records_path") {
+		cfg.RecordsPath = ""
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// decode decodes a YAML document into cfg, rejecting unknown keys, and returns the keys it sets.
+func decode(data []byte, cfg *Config) (map[string]bool, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	var keys map[string]any
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool, len(keys))
+	for key := range keys {
+		set[key] = true
+	}
+
+	return set, nil
+}
+
+// explicit reports whether a key is set by the file or by its environment variable.
+func explicit(keys map[string]bool, key string) bool {
+	_, ok := os.LookupEnv(strings.ToUpper(key))
+	return ok || keys[key]
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/fedragon/sinkhole/audit"
+	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/records"
+	"github.com/fedragon/sinkhole/internal/upstream"
+)
+
+// Validate checks the configuration, reporting all the errors found, each prefixed by the key it concerns. It normalises the policy and
+// the records, if set.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(key string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	check("local_server_addr", validateAddr(c.LocalServerAddr, false))
+	check("http_server_addr", validateAddr(c.HttpServerAddr, false))
+
+	if len(c.Upstreams) == 0 {
+		check("upstream_server_addr", validateAddr(c.UpstreamServerAddr, true))
+	}
+	for i, addr := range c.Upstreams {
+		check(fmt.Sprintf("upstreams[%d]", i), validateAddr(addr, true))
+	}
+
+	for i, rule := range c.Forwarding {
+		key := fmt.Sprintf("forwarding[%d]", i)
+		if len(rule.Zones) == 0 {
+			check(key, errors.New("missing zones"))
+		}
+		for _, zone := range rule.Zones {
+			_, err := upstream.Zones(zone)
+			check(key, err)
+		}
+
+		if len(rule.Upstreams) == 0 {
+			check(key, errors.New("missing upstreams"))
+		}
+		for _, addr := range rule.Upstreams {
+			check(key, validateAddr(addr, true))
+		}
+
+		switch rule.Transport {
+		case "", upstream.TransportUDP, upstream.TransportTCP:
+		default:
+			check(key, fmt.Errorf("unknown transport %q: expected udp or tcp", rule.Transport))
+		}
+
+		if rule.Timeout < 0 {
+			check(key, fmt.Errorf("negative timeout: %v", rule.Timeout))
+		}
+	}
+
+	if !hosts.Format(c.HostsFormat).Valid() {
+		check("hosts_format", fmt.Errorf("unknown list format %q: expected hosts, dnsmasq or rpz", c.HostsFormat))
+	}
+
+	switch c.Registry {
+	case "map", "compact":
+	default:
+		check("registry", fmt.Errorf("unknown registry %q: expected map or compact", c.Registry))
+	}
+
+	if c.Policy != nil {
+		check("policy", c.Policy.Validate())
+		if c.PolicyPath != "" {
+			check("policy", errors.New("cannot be set along with policy_path"))
+		}
+	}
+
+	for i, record := range c.Records {
+		normalised, err := records.Validate(record)
+		check(fmt.Sprintf("records[%d]", i), err)
+		c.Records[i] = normalised
+	}
+	if c.Records != nil && c.RecordsPath != "" {
+		check("records", errors.New("cannot be set along with records_path"))
+	}
+
+	if c.DashboardEnabled && !c.ApiEnabled {
+		check("dashboard_enabled", errors.New("requires the management API: set api_enabled"))
+	}
+
+	if (c.HttpTLSCertPath == "") != (c.HttpTLSKeyPath == "") {
+		check("http_tls_cert_path", errors.New("TLS requires both a certificate and a key (http_tls_key_path)"))
+	}
+
+	if c.HttpShutdownTimeout <= 0 {
+		check("http_shutdown_timeout", fmt.Errorf("must be positive: %v", c.HttpShutdownTimeout))
+	}
+
+	if c.HealthProbeInterval <= 0 {
+		check("health_probe_interval", fmt.Errorf("must be positive: %v", c.HealthProbeInterval))
+	}
+
+	if c.QueryLogPath != "" && c.QueryLogRetention <= 0 {
+		check("query_log_retention", fmt.Errorf("must be positive: %v", c.QueryLogRetention))
+	}
+
+	if c.TracingEndpoint != "" {
+		if u, err := url.Parse(c.TracingEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			check("tracing_endpoint", fmt.Errorf("invalid URL %q: expected e.g. http://localhost:4318", c.TracingEndpoint))
+		}
+	}
+
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		check("tracing_sample_ratio", fmt.Errorf("must be between 0 and 1: %v", c.TracingSampleRatio))
+	}
+
+	switch c.AuditLogPrivacy {
+	case audit.PrivacyFull, audit.PrivacyAnonymised, audit.PrivacyNamesOnly:
+	default:
+		check("audit_log_privacy", fmt.Errorf("unknown mode %q: expected full, anonymised or names-only", c.AuditLogPrivacy))
+	}
+
+	if c.AuditLogMaxSizeMB < 0 || c.AuditLogMaxAge < 0 || c.AuditLogMaxFiles < 0 {
+		check("audit_log", errors.New("rotation limits cannot be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateAddr checks that addr is a host and port, the host being required for the addresses of upstreams (but not for those the
+// sinkhole listens on, where it defaults to all interfaces).
+func validateAddr(addr string, upstream bool) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || (upstream && n == 0) {
+		return fmt.Errorf("invalid address %q: invalid port %q", addr, port)
+	}
+
+	if upstream && host == "" {
+		return fmt.Errorf("invalid address %q: missing host", addr)
+	}
+
+	return nil
+}
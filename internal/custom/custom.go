@@ -0,0 +1,280 @@
+package custom
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/registry"
+)
+
+// Actions of an entry.
+const (
+	ActionBlock = "block"
+	ActionAllow = "allow"
+)
+
+var (
+	ErrInvalidAction = errors.New("invalid action: expected one of block, allow")
+	ErrNotFound      = errors.New("entry not found")
+	ErrSave          = errors.New("unable to save entries")
+)
+
+// Entry is a domain blocked or allowed on top of the lists, regardless of the group of the client.
+type Entry struct {
+	Domain string `json:"domain"` // "*.domain" matches all subdomains of domain
+	Action string `json:"action"` // one of: block, allow
+}
+
+// Store holds the custom entries, exposing them as a blocklist and an allowlist.
+type Store struct {
+	mu        sync.RWMutex
+	path      string
+	entries   []Entry
+	blocklist *registry.Map
+	allowlist *registry.Map
+}
+
+// Load reads the entries from the file at path (if it exists), which is also where any change will be saved to.
+//
+// Each line of the file contains an entry, in the format `<action> <domain>`. Blank lines and lines starting with `#` are ignored.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.index()
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	s.entries = entries
+	s.index()
+
+	return s, nil
+}
+
+// Parse reads entries from r.
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected <action> <domain>", n)
+		}
+
+		entry, err := Validate(Entry{Action: fields[0], Domain: fields[1]})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Validate checks that the entry is well-formed, returning its normalised version.
+func Validate(entry Entry) (Entry, error) {
+	entry.Action = strings.ToLower(entry.Action)
+	if entry.Action != ActionBlock && entry.Action != ActionAllow {
+		return entry, ErrInvalidAction
+	}
+
+	domain, err := hosts.Normalize(entry.Domain)
+	if err != nil {
+		return entry, fmt.Errorf("invalid domain %q: %v", entry.Domain, err)
+	}
+	entry.Domain = domain
+
+	return entry, nil
+}
+
+// All returns all entries.
+func (s *Store) All() []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.entries)
+}
+
+// Add validates the entry and adds it to the store, replacing any entry of the same domain with a different action, and saves the change.
+func (s *Store) Add(entry Entry) (Entry, error) {
+	entry, err := Validate(entry)
+	if err != nil {
+		return entry, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.Contains(s.entries, entry) {
+		return entry, nil
+	}
+
+	entries := slices.DeleteFunc(slices.Clone(s.entries), func(e Entry) bool { return e.Domain == entry.Domain })
+	return entry, s.update(append(entries, entry))
+}
+
+// Remove removes the entry with the provided action and domain from the store, saving the change.
+func (s *Store) Remove(action, domain string) error {
+	entry, err := Validate(Entry{Action: action, Domain: domain})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := slices.DeleteFunc(slices.Clone(s.entries), func(e Entry) bool { return e == entry })
+	if len(entries) == len(s.entries) {
+		return ErrNotFound
+	}
+
+	return s.update(entries)
+}
+
+// Blocklist returns a view of the blocked domains, reflecting any later change.
+func (s *Store) Blocklist() *View {
+	return &View{store: s, action: ActionBlock}
+}
+
+// Allowlist returns a view of the allowed domains, reflecting any later change.
+func (s *Store) Allowlist() *View {
+	return &View{store: s, action: ActionAllow}
+}
+
+// update saves the entries and, if successful, replaces the current ones with them.
+func (s *Store) update(entries []Entry) error {
+	if err := save(s.path, entries); err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+
+	s.entries = entries
+	s.index()
+
+	return nil
+}
+
+// index rebuilds the blocklist and allowlist.
+func (s *Store) index() {
+	s.blocklist = registry.NewMap()
+	s.allowlist = registry.NewMap()
+	for _, e := range s.entries {
+		if e.Action == ActionBlock {
+			s.blocklist.Add(e.Domain, hosts.Rule{Action: hosts.Block})
+		} else {
+			s.allowlist.Add(e.Domain, hosts.Rule{Action: hosts.Passthru})
+		}
+	}
+}
+
+// View is a registry of the entries with the same action, safe for concurrent use with changes to the store.
+type View struct {
+	store  *Store
+	action string
+}
+
+func (v *View) registry() *registry.Map {
+	if v.action == ActionBlock {
+		return v.store.blocklist
+	}
+
+	return v.store.allowlist
+}
+
+// Add registers a rule for domain in memory only, reporting whether the domain was not registered yet: use Store.Add to persist entries.
+func (v *View) Add(domain string, rule hosts.Rule) bool {
+	v.store.mu.Lock()
+	defer v.store.mu.Unlock()
+
+	return v.registry().Add(domain, rule)
+}
+
+// Get returns the rule registered for domain, if any.
+func (v *View) Get(domain string) (hosts.Rule, bool) {
+	v.store.mu.RLock()
+	defer v.store.mu.RUnlock()
+
+	return v.registry().Get(domain)
+}
+
+// Len returns the number of registered domains.
+func (v *View) Len() int {
+	v.store.mu.RLock()
+	defer v.store.mu.RUnlock()
+
+	return v.registry().Len()
+}
+
+// Wildcards returns the number of registered wildcard domains (i.e. starting with "*.").
+func (v *View) Wildcards() int {
+	v.store.mu.RLock()
+	defer v.store.mu.RUnlock()
+
+	return v.registry().Wildcards()
+}
+
+// Range calls fn for each registered domain and its rule, in no particular order, until fn returns false.
+func (v *View) Range(fn func(domain string, rule hosts.Rule) bool) {
+	v.store.mu.RLock()
+	defer v.store.mu.RUnlock()
+
+	v.registry().Range(fn)
+}
+
+// save atomically writes the entries to the file at path.
+func save(path string, entries []Entry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "%s %s\n", e.Action, e.Domain); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
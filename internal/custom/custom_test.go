@@ -0,0 +1,63 @@
+package custom
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+func TestParse(t *testing.T) {
+	input := `
+# custom entries
+block Ads.Example.com
+ALLOW *.cdn.example.com
+`
+	entries, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Domain: "ads.example.com", Action: ActionBlock},
+		{Domain: "*.cdn.example.com", Action: ActionAllow},
+	}, entries)
+
+	for _, input := range []string{"block", "deny ads.example.com", "block -ads.example.com", "block a.com b.com"} {
+		_, err := Parse(strings.NewReader(input))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestStore_PersistsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom")
+	store, err := Load(path)
+	require.NoError(t, err)
+
+	blocklist, allowlist := store.Blocklist(), store.Allowlist()
+
+	_, err = store.Add(Entry{Domain: "ads.example.com", Action: ActionBlock})
+	require.NoError(t, err)
+	_, err = store.Add(Entry{Domain: "cdn.example.com", Action: ActionBlock})
+	require.NoError(t, err)
+
+	rule, ok := blocklist.Get("ads.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, hosts.Block, rule.Action)
+	assert.Equal(t, 2, blocklist.Len())
+
+	// the same domain cannot be both blocked and allowed
+	_, err = store.Add(Entry{Domain: "cdn.example.com", Action: ActionAllow})
+	require.NoError(t, err)
+	assert.Equal(t, 1, blocklist.Len())
+	_, ok = allowlist.Get("cdn.example.com")
+	assert.True(t, ok)
+
+	assert.ErrorIs(t, store.Remove(ActionAllow, "ads.example.com"), ErrNotFound)
+	require.NoError(t, store.Remove(ActionBlock, "ads.example.com"))
+
+	reloaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Domain: "cdn.example.com", Action: ActionAllow}}, reloaded.All())
+}
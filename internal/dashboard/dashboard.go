@@ -0,0 +1,23 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// static assets of the dashboard, which only relies on the management API
+//
+//go:embed static
+var static embed.FS
+
+// Register registers the dashboard's routes with mux: it is served under /dashboard/, to which / redirects.
+func Register(mux *http.ServeMux) {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // the directory is embedded at build time
+	}
+
+	mux.Handle("GET /dashboard/", http.StripPrefix("/dashboard/", http.FileServerFS(assets)))
+	mux.Handle("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
+}
@@ -0,0 +1,210 @@
+// Dashboard of the sinkhole: it only relies on the management and stats APIs.
+'use strict';
+
+const api = '/api/v1';
+const summaryInterval = 10000; // ms
+const logInterval = 2000;      // ms
+const logSize = 100;           // number of queries shown in the log
+
+let lastQuery = 0;
+
+async function request(method, path, body) {
+  const options = {method, headers: {}};
+  if (body !== undefined) {
+    options.headers['Content-Type'] = 'application/json';
+    options.body = JSON.stringify(body);
+  }
+
+  const response = await fetch(api + path, options);
+  if (!response.ok) {
+    let message = response.statusText;
+    try {
+      message = (await response.json()).error || message;
+    } catch (e) {
+      // not a JSON error
+    }
+    throw new Error(`${method} ${path}: ${response.status} ${message}`);
+  }
+
+  return response.status === 204 ? null : response.json();
+}
+
+function showError(err) {
+  const el = document.getElementById('error');
+  el.textContent = err ? err.message : '';
+  el.hidden = !err;
+}
+
+// run calls fn, showing any error it throws, and clearing any previous one otherwise.
+async function run(fn) {
+  try {
+    await fn();
+    showError(null);
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function renderTop(id, counts) {
+  const table = document.getElementById(id);
+  table.replaceChildren();
+  for (const c of counts) {
+    const row = table.insertRow();
+    cell(row, c.key, 'name');
+    // counts are estimated: show their margin of error, if any
+    const count = cell(row, c.error ? `${c.count} ±${c.error}` : c.count, 'count');
+    if (c.error) {
+      count.title = 'Estimated: the most frequent domains and clients are tracked in bounded memory';
+    }
+  }
+}
+
+function renderChart(buckets) {
+  const svg = document.getElementById('chart');
+  const ns = 'http://www.w3.org/2000/svg';
+  const width = 720 / buckets.length;
+  const height = 160;
+  const highest = Math.max(1, ...buckets.map(b => b.total));
+
+  svg.replaceChildren();
+  buckets.forEach((b, i) => {
+    for (const [className, value] of [['allowed', b.total], ['blocked', b.blocked]]) {
+      const rect = document.createElementNS(ns, 'rect');
+      const h = value / highest * height;
+      rect.setAttribute('class', className);
+      rect.setAttribute('x', i * width);
+      rect.setAttribute('y', height - h);
+      rect.setAttribute('width', Math.max(width - 1, 1));
+      rect.setAttribute('height', h);
+
+      const title = document.createElementNS(ns, 'title');
+      title.textContent = `${new Date(b.start).toLocaleTimeString()}: ${b.total} queries, ${b.blocked} blocked`;
+      rect.appendChild(title);
+      svg.appendChild(rect);
+    }
+  });
+}
+
+async function refreshSummary() {
+  const window = document.getElementById('window').value;
+  const summary = await request('GET', `/stats?window=${encodeURIComponent(window)}`);
+  document.getElementById('total').textContent = summary.total;
+  document.getElementById('blocked').textContent = summary.blocked;
+  document.getElementById('ratio').textContent =
+    summary.total ? (summary.blocked / summary.total * 100).toFixed(1) : '0.0';
+
+  renderChart(summary.over_time);
+  renderTop('top-queried', summary.top_queried);
+  renderTop('top-blocked', summary.top_blocked);
+  renderTop('top-allowed', summary.top_allowed);
+  renderTop('top-clients', summary.top_clients);
+}
+
+async function refreshLog() {
+  const queries = await request('GET', `/queries?after=${lastQuery}&limit=${logSize}`);
+  const body = document.querySelector('#queries tbody');
+
+  for (const q of queries) {
+    const row = body.insertRow(0);
+    row.className = q.decision;
+    cell(row, new Date(q.time).toLocaleTimeString());
+    cell(row, q.client);
+    cell(row, q.name, 'name');
+    cell(row, q.type);
+    cell(row, q.decision);
+    cell(row, q.list || '');
+    cell(row, q.duration_ms.toFixed(1), 'count');
+    lastQuery = q.id;
+  }
+
+  while (body.rows.length > logSize) {
+    body.deleteRow(-1);
+  }
+}
+
+function pauseDuration() {
+  return document.getElementById('pause-duration').value;
+}
+
+async function refreshToggles() {
+  const [lists, groups, pauses] = await Promise.all([
+    request('GET', '/lists'),
+    request('GET', '/groups'),
+    request('GET', '/pauses'),
+  ]);
+
+  document.getElementById('domains').textContent =
+    lists.filter(l => !l.disabled).reduce((sum, l) => sum + l.domains, 0);
+
+  const listTable = document.getElementById('lists');
+  listTable.replaceChildren();
+  for (const l of lists) {
+    const row = listTable.insertRow();
+    cell(row, l.name, 'name');
+    cell(row, l.domains, 'count');
+    const button = document.createElement('button');
+    button.textContent = l.disabled ? 'Enable' : 'Disable';
+    button.onclick = () => run(async () => {
+      await request('PATCH', `/lists/${encodeURIComponent(l.name)}`, {disabled: !l.disabled});
+      await refreshToggles();
+    });
+    row.insertCell().appendChild(button);
+  }
+
+  const paused = scope => name => pauses.find(p => p.scope === scope && (p.name || '') === name);
+
+  const groupTable = document.getElementById('groups');
+  groupTable.replaceChildren();
+  for (const g of groups) {
+    const row = groupTable.insertRow();
+    const pause = paused('group')(g.name);
+    cell(row, g.name, 'name');
+    cell(row, pause ? `paused until ${new Date(pause.until).toLocaleTimeString()}` : 'blocking');
+    const button = document.createElement('button');
+    button.textContent = pause ? 'Resume' : 'Pause';
+    button.onclick = () => run(async () => {
+      if (pause) {
+        await request('DELETE', `/pauses/group/${encodeURIComponent(g.name)}`);
+      } else {
+        await request('POST', '/pauses', {scope: 'group', name: g.name, duration: pauseDuration()});
+      }
+      await refreshToggles();
+    });
+    row.insertCell().appendChild(button);
+  }
+
+  const global = paused('global')('');
+  const button = document.getElementById('pause-global');
+  button.textContent = global
+    ? `Resume blocking (paused until ${new Date(global.until).toLocaleTimeString()})`
+    : 'Pause blocking';
+  button.onclick = () => run(async () => {
+    if (global) {
+      await request('DELETE', '/pauses/global');
+    } else {
+      await request('POST', '/pauses', {scope: 'global', duration: pauseDuration()});
+    }
+    await refreshToggles();
+  });
+}
+
+function every(interval, fn) {
+  run(fn);
+  setInterval(() => run(fn), interval);
+}
+
+every(summaryInterval, async () => {
+  await refreshSummary();
+  await refreshToggles();
+});
+every(logInterval, refreshLog);
+document.getElementById('window').onchange = () => run(refreshSummary);
@@ -0,0 +1,114 @@
+package dns
+
+import (
+	"log/slog"
+	"net/netip"
+	"strings"
+
+	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/records"
+)
+
+// maximum number of CNAME records followed while answering a query
+const maxCNAMEChain = 8
+
+var recordTypes = map[string]message.Type{
+	"A":     message.TypeA,
+	"AAAA":  message.TypeAAAA,
+	"CNAME": message.TypeCNAME,
+	"PTR":   message.TypePTR,
+	"TXT":   message.TypeTXT,
+}
+
+// LocalRecords answers queries about the records of the local network, authoritatively.
+type LocalRecords struct {
+	store  *records.Store
+	logger *slog.Logger
+}
+
+func NewLocalRecords(store *records.Store, logger *slog.Logger) *LocalRecords {
+	return &LocalRecords{
+		store:  store,
+		logger: logger.With("source", "local_records"),
+	}
+}
+
+// Resolve answers a query with the local records of its domain, following CNAME records as long as their targets are local too.
+// It returns false if there are no local records for the domain, in which case the query must be handled elsewhere.
+func (l *LocalRecords) Resolve(query *message.Query) (*message.Response, bool) {
+	question := query.Question
+	if query.OpCode != 0 || question.Class != message.ClassInternetAddress {
+		return nil, false
+	}
+
+	found := l.store.Lookup(strings.ToLower(question.Name))
+	if len(found) == 0 {
+		return nil, false
+	}
+
+	var answers []message.Record
+	owner := question.Name
+	for i := 0; i < maxCNAMEChain && len(found) > 0; i++ {
+		var cname *records.Record
+		for _, r := range found {
+			switch recordTypes[r.Type] {
+			case question.Type:
+				if answer, ok := l.marshal(owner, r); ok {
+					answers = append(answers, answer)
+				}
+			case message.TypeCNAME:
+				cname = &r
+			}
+		}
+
+		if len(answers) > 0 || cname == nil {
+			break
+		}
+
+		answer, ok := l.marshal(owner, *cname)
+		if !ok {
+			break
+		}
+
+		answers = append(answers, answer)
+		owner = cname.Value
+		found = l.store.Lookup(owner)
+	}
+
+	// a name with local records of other types only gets an empty (NODATA) answer
+	response := message.NewResponse(query, answers...)
+	response.SetAuthoritative(true)
+
+	return response, true
+}
+
+// marshal converts a local record into an answer for owner.
+func (l *LocalRecords) marshal(owner string, r records.Record) (message.Record, bool) {
+	answer := message.Record{
+		DomainName: owner,
+		Type:       recordTypes[r.Type],
+		Class:      message.ClassInternetAddress,
+		TTL:        r.TTL,
+	}
+
+	switch answer.Type {
+	case message.TypeA:
+		ip := netip.MustParseAddr(r.Value).As4()
+		answer.Data = ip[:]
+	case message.TypeAAAA:
+		ip := netip.MustParseAddr(r.Value).As16()
+		answer.Data = ip[:]
+	case message.TypeCNAME, message.TypePTR:
+		target, err := message.MarshalName(r.Value)
+		if err != nil {
+			l.logger.Error("Unable to marshal record", "name", r.Name, "type", r.Type, "error", err)
+			return answer, false
+		}
+		answer.Data = target
+	case message.TypeTXT:
+		answer.Data = message.MarshalText(r.Value)
+	}
+	answer.Length = uint16(len(answer.Data))
+
+	return answer, true
+}
@@ -0,0 +1,87 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	pointerMask = 0b1100_0000 // the two high bits of a label length mark a pointer to a name elsewhere in the message
+	maxPointers = 64          // to protect against pointer loops
+)
+
+// MarshalName encodes a domain name as a sequence of length-prefixed labels, terminated by the root label.
+func MarshalName(name string) ([]byte, error) {
+	var data []byte
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, part := range strings.Split(name, ".") {
+			length := len(part)
+			if length > math.MaxUint8 {
+				return nil, fmt.Errorf("substring length cannot be cast to uint8: %v", length)
+			}
+			data = append(data, uint8(length))
+			data = append(data, []byte(part)...)
+		}
+	}
+
+	return append(data, uint8(0)), nil
+}
+
+// MarshalText encodes text as the data of a TXT record, i.e. as a sequence of length-prefixed strings of at most 255 bytes.
+func MarshalText(text string) []byte {
+	var data []byte
+	for {
+		chunk := text[:min(len(text), math.MaxUint8)]
+		data = append(data, uint8(len(chunk)))
+		data = append(data, chunk...)
+
+		text = text[len(chunk):]
+		if text == "" {
+			return data
+		}
+	}
+}
+
+// unmarshalName decodes the name at offset in a message, following compression pointers (RFC 1035, 4.1.4). It also returns the offset
+// following the name.
+func unmarshalName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1 // offset following the name, once a pointer has been followed
+
+	for pointers := 0; ; {
+		if offset >= len(data) {
+			return "", 0, ErrTooShort
+		}
+
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&pointerMask == pointerMask:
+			if offset+1 >= len(data) {
+				return "", 0, ErrTooShort
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, errors.New("too many compression pointers")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(byteOrder.Uint16(data[offset:])) & 0x3fff
+		case length&pointerMask != 0:
+			return "", 0, fmt.Errorf("unsupported label type: %#x", length)
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, ErrTooShort
+			}
+			labels = append(labels, string(data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
@@ -6,28 +6,64 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"strconv"
 )
 
 type Class uint16
 type Type uint16
+type RCode uint8
 
 const (
 	ClassInternetAddress Class = 1
 
-	TypeA    Type = 1
-	TypeAAAA Type = 28
+	TypeA     Type = 1
+	TypeNS    Type = 2
+	TypeCNAME Type = 5
+	TypePTR   Type = 12
+	TypeTXT   Type = 16
+	TypeAAAA  Type = 28
+
+	RCodeNoError       RCode = 0
+	RCodeServerFailure RCode = 2 // SERVFAIL
+	RCodeNameError     RCode = 3 // NXDOMAIN
+	RCodeRefused       RCode = 5
 
 	queryMask              = 0b1000_0000_0000_0000
 	opCodeMask             = 0b0111_1000_0000_0000
+	authoritativeMask      = 0b0000_0100_0000_0000
 	recursionDesiredMask   = 0b0000_0001_0000_0000
 	recursionAvailableMask = 0b0000_0000_1000_0000
+	rCodeMask              = 0b0000_0000_0000_1111
 )
 
 var (
 	ErrTooShort = errors.New("message too short")
 	byteOrder   = binary.BigEndian
+
+	// names of the most common types, including those the sinkhole does not handle itself
+	typeNames = map[Type]string{1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT", 28: "AAAA", 33: "SRV", 64: "SVCB", 65: "HTTPS", 255: "ANY"}
+
+	rCodeNames = [...]string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
 )
 
+// String returns the mnemonic of the type (e.g. "AAAA"), or its number in the generic format of RFC 3597 (e.g. "TYPE99") if unknown.
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// String returns the mnemonic of the response code (e.g. "NXDOMAIN"), or "RCODE<n>" if uncommon.
+func (c RCode) String() string {
+	if int(c) < len(rCodeNames) {
+		return rCodeNames[c]
+	}
+
+	return "RCODE" + strconv.Itoa(int(c))
+}
+
 type Query struct {
 	ID               uint16
 	OpCode           uint8
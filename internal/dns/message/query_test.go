@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"net/netip"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestQuery_Identification(t *testing.T) {
@@ -157,3 +159,54 @@ func TestRecord_AAAA_MarshalRoundtrip(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, r1, q2)
 }
+
+func TestRCodeOf(t *testing.T) {
+	query := &Query{ID: 0x1234, RecursionDesired: true, Question: Question{Name: "example.com", Type: TypeA, Class: ClassInternetAddress}}
+	data, err := MarshalResponse(NewErrorResponse(query, RCodeNameError))
+	assert.NoError(t, err)
+
+	rcode, err := RCodeOf(data)
+	assert.NoError(t, err)
+	assert.Equal(t, RCodeNameError, rcode)
+	assert.Equal(t, "NXDOMAIN", rcode.String())
+	assert.Equal(t, "RCODE9", RCode(9).String())
+
+	_, err = RCodeOf(data[:4])
+	assert.ErrorIs(t, err, ErrTooShort)
+}
+
+func TestUnmarshalResponse(t *testing.T) {
+	data := []byte{
+		0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00,
+		// question: www.example.com A IN
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0x00, 0x01, 0x00, 0x01,
+		// answer: www.example.com (pointer to the question) CNAME cdn.example.com (label, then pointer to example.com)
+		0xc0, 0x0c, 0x00, 0x05, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x06, 3, 'c', 'd', 'n', 0xc0, 0x10,
+		// answer: cdn.example.com (pointer to the CNAME target) A 192.0.2.1
+		0xc0, 0x2d, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04, 192, 0, 2, 1,
+		// answer: cdn.example.com TXT "hello"
+		0xc0, 0x2d, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x06, 5, 'h', 'e', 'l', 'l', 'o',
+	}
+
+	r, err := UnmarshalResponse(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0x1234), r.ID())
+	assert.Equal(t, RCodeNoError, r.RCode())
+	require.Len(t, r.Answers, 3)
+
+	assert.Equal(t, "www.example.com", r.Answers[0].DomainName)
+	assert.Equal(t, TypeCNAME, r.Answers[0].Type)
+	assert.Equal(t, uint32(3600), r.Answers[0].TTL)
+	assert.Equal(t, "cdn.example.com", r.Answers[0].Value())
+
+	assert.Equal(t, "cdn.example.com", r.Answers[1].DomainName)
+	assert.Equal(t, "192.0.2.1", r.Answers[1].Value())
+	assert.Equal(t, "hello", r.Answers[2].Value())
+
+	_, err = UnmarshalResponse(data[:len(data)-1])
+	assert.ErrorIs(t, err, ErrTooShort)
+
+	// a pointer to itself
+	_, err = UnmarshalResponse(append(slices.Clone(data[:12]), 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01))
+	assert.EqualError(t, err, "too many compression pointers")
+}
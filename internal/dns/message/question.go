@@ -2,9 +2,7 @@ package message
 
 import (
 	"bufio"
-	"fmt"
 	"io"
-	"math"
 	"strings"
 )
 
@@ -54,17 +52,10 @@ func unmarshalQuestion(r *bufio.Reader) (Question, error) {
 }
 
 func marshalQuestion(q Question) ([]byte, error) {
-	var data []byte
-	parts := strings.Split(q.Name, ".")
-	for _, part := range parts {
-		length := len(part)
-		if length > math.MaxUint8 {
-			return nil, fmt.Errorf("substring length cannot be cast to uint8: %v", length)
-		}
-		data = append(data, uint8(length))
-		data = append(data, []byte(part)...)
+	data, err := MarshalName(q.Name)
+	if err != nil {
+		return nil, err
 	}
-	data = append(data, uint8(0))
 
 	data = byteOrder.AppendUint16(data, uint16(q.Type))
 	data = byteOrder.AppendUint16(data, uint16(q.Class))
@@ -2,9 +2,9 @@ package message
 
 import (
 	"bufio"
-	"fmt"
+	"encoding/hex"
 	"io"
-	"math"
+	"net/netip"
 	"strings"
 )
 
@@ -75,17 +75,10 @@ func unmarshalRecord(r *bufio.Reader) (Record, error) {
 }
 
 func marshalRecord(r Record) ([]byte, error) {
-	var data []byte
-	parts := strings.Split(r.DomainName, ".")
-	for _, part := range parts {
-		length := len(part)
-		if length > math.MaxUint8 {
-			return nil, fmt.Errorf("substring length cannot be cast to uint8: %v", length)
-		}
-		data = append(data, uint8(length))
-		data = append(data, []byte(part)...)
+	data, err := MarshalName(r.DomainName)
+	if err != nil {
+		return nil, err
 	}
-	data = append(data, uint8(0))
 
 	data = byteOrder.AppendUint16(data, uint16(r.Type))
 	data = byteOrder.AppendUint16(data, uint16(r.Class))
@@ -94,3 +87,26 @@ func marshalRecord(r Record) ([]byte, error) {
 
 	return append(data, r.Data...), nil
 }
+
+// Value returns the data of the record in a readable form: an address for A and AAAA records, a name for NS, CNAME and PTR records, the
+// text of TXT records, and the data in hexadecimal otherwise.
+func (r Record) Value() string {
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		if addr, ok := netip.AddrFromSlice(r.Data); ok {
+			return addr.String()
+		}
+	case TypeNS, TypeCNAME, TypePTR:
+		if name, _, err := unmarshalName(r.Data, 0); err == nil {
+			return name
+		}
+	case TypeTXT:
+		var text strings.Builder
+		for data := r.Data; len(data) > 0 && len(data) > int(data[0]); data = data[1+int(data[0]):] {
+			text.Write(data[1 : 1+int(data[0])])
+		}
+		return text.String()
+	}
+
+	return hex.EncodeToString(r.Data)
+}
@@ -2,6 +2,7 @@ package message
 
 import (
 	"fmt"
+	"slices"
 )
 
 type Response struct {
@@ -11,19 +12,30 @@ type Response struct {
 	Answers   []Record
 }
 
-func NewResponse(query *Query, answer Record) *Response {
+// NewResponse builds a successful response to query, containing the provided answers (if any).
+func NewResponse(query *Query, answers ...Record) *Response {
+	return newResponse(query, RCodeNoError, answers)
+}
+
+// NewErrorResponse builds a response to query carrying the provided response code and no answers.
+func NewErrorResponse(query *Query, rcode RCode) *Response {
+	return newResponse(query, rcode, nil)
+}
+
+func newResponse(query *Query, rcode RCode, answers []Record) *Response {
 	var flags uint16
 	flags |= 1 << 15 // QueryResponse: 1 for Response
 	if query.RecursionDesired {
 		flags |= 1 << 8 // RecursionDesired: 1
 	}
 	flags |= 1 << 7 // RecursionAvailable: 1
+	flags |= uint16(rcode) & rCodeMask
 
 	res := &Response{
 		id:        query.ID,
 		flags:     flags,
 		questions: []Question{query.Question},
-		Answers:   []Record{answer},
+		Answers:   answers,
 	}
 
 	return res
@@ -33,6 +45,19 @@ func (r *Response) ID() uint16 {
 	return r.id
 }
 
+func (r *Response) IsAuthoritative() bool {
+	return (r.flags&authoritativeMask)>>10 == 1
+}
+
+// SetAuthoritative marks the response as coming from a server that is an authority for the domain in question.
+func (r *Response) SetAuthoritative(authoritative bool) {
+	if authoritative {
+		r.flags |= authoritativeMask
+	} else {
+		r.flags &^= authoritativeMask
+	}
+}
+
 func (r *Response) IsRecursionDesired() bool {
 	return (r.flags&recursionDesiredMask)>>8 == 1
 }
@@ -41,6 +66,91 @@ func (r *Response) IsRecursionAvailable() bool {
 	return (r.flags&recursionAvailableMask)>>7 == 1
 }
 
+func (r *Response) RCode() RCode {
+	return RCode(r.flags & rCodeMask)
+}
+
+// RCodeOf returns the response code of a raw response, e.g. one received from the upstream.
+func RCodeOf(data []byte) (RCode, error) {
+	if len(data) < 12 {
+		return 0, ErrTooShort
+	}
+
+	return RCode(byteOrder.Uint16(data[2:4]) & rCodeMask), nil
+}
+
+// UnmarshalResponse decodes the header, questions and answers of a raw response, e.g. one received from the upstream, expanding any
+// compressed names. Authority and additional records are ignored.
+func UnmarshalResponse(data []byte) (*Response, error) {
+	if len(data) < 12 {
+		return nil, ErrTooShort
+	}
+
+	r := &Response{id: byteOrder.Uint16(data[0:2]), flags: byteOrder.Uint16(data[2:4])}
+	questions, answers := int(byteOrder.Uint16(data[4:6])), int(byteOrder.Uint16(data[6:8]))
+
+	offset := 12
+	for range questions {
+		name, next, err := unmarshalName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, ErrTooShort
+		}
+
+		r.questions = append(r.questions, Question{
+			Name:  name,
+			Type:  Type(byteOrder.Uint16(data[next:])),
+			Class: Class(byteOrder.Uint16(data[next+2:])),
+		})
+		offset = next + 4
+	}
+
+	for range answers {
+		name, next, err := unmarshalName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(data) {
+			return nil, ErrTooShort
+		}
+
+		record := Record{
+			DomainName: name,
+			Type:       Type(byteOrder.Uint16(data[next:])),
+			Class:      Class(byteOrder.Uint16(data[next+2:])),
+			TTL:        byteOrder.Uint32(data[next+4:]),
+			Length:     byteOrder.Uint16(data[next+8:]),
+		}
+
+		start, end := next+10, next+10+int(record.Length)
+		if end > len(data) {
+			return nil, ErrTooShort
+		}
+
+		switch record.Type {
+		case TypeNS, TypeCNAME, TypePTR:
+			// the name may point elsewhere in the message: expand it, so that the record can be read on its own
+			target, _, err := unmarshalName(data, start)
+			if err != nil {
+				return nil, err
+			}
+			if record.Data, err = MarshalName(target); err != nil {
+				return nil, err
+			}
+			record.Length = uint16(len(record.Data))
+		default:
+			record.Data = slices.Clone(data[start:end])
+		}
+
+		r.Answers = append(r.Answers, record)
+		offset = end
+	}
+
+	return r, nil
+}
+
 func MarshalResponse(r *Response) ([]byte, error) {
 	var data []byte
 	data = byteOrder.AppendUint16(data, r.id)
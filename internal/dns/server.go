@@ -4,13 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	p "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/fedragon/sinkhole/audit"
 	"github.com/fedragon/sinkhole/internal/dns/message"
@@ -19,17 +24,81 @@ import (
 
 const (
 	maxPacketSize = 512
+	transportUDP  = "udp" // the only transport served, so far
 )
 
+// tracer records how queries are handled, if tracing is enabled: the spans of each query have a root span named "dns.query".
+var tracer = otel.Tracer("github.com/fedragon/sinkhole/internal/dns")
+
+// Upstream forwards the queries that are neither answered locally nor blocked.
+type Upstream interface {
+	// Exchange forwards a query for the domain name, returning the raw response along with the address of the upstream that sent it.
+	Exchange(ctx context.Context, name string, query []byte) ([]byte, string, error)
+}
+
+// Event describes how a query has been handled.
+type Event struct {
+	Time     time.Time
+	Client   netip.Addr
+	Name     string
+	Type     message.Type
+	Decision Decision
+	Group    string // group of the client, unless the query has been answered with local records
+	List     string // list registering the domain, if any
+	Upstream string // address of the upstream that answered the query, if it has been forwarded
+	RCode    message.RCode
+	Duration time.Duration
+}
+
+// Recorder records the queries handled by the server, e.g. to compute statistics: it must not block.
+type Recorder interface {
+	Record(event Event)
+}
+
+// TapKind is the kind of a message exchanged by the server.
+type TapKind uint8
+
+const (
+	ClientQuery       TapKind = iota + 1 // query received from a client
+	ClientResponse                       // response sent to a client
+	ForwarderQuery                       // query forwarded to the upstream
+	ForwarderResponse                    // response received from the upstream
+)
+
+// TapMessage is a copy of a message exchanged by the server.
+type TapMessage struct {
+	Kind         TapKind
+	Client       netip.AddrPort // client that sent the query, even for forwarder messages
+	Server       netip.AddrPort // address the server listens on for client messages, address of the upstream for forwarder ones (if known)
+	QueryTime    time.Time
+	ResponseTime time.Time // only set for responses
+	Query        []byte
+	Response     []byte // only set for responses
+}
+
+// Tap receives copies of the messages exchanged by the server, e.g. to export them over dnstap: it must not block, nor modify them.
+type Tap interface {
+	Tap(m TapMessage)
+}
+
 type Server struct {
-	sinkhole *Sinkhole
-	upstream io.ReadWriteCloser
-	logger   *slog.Logger
-	audit    *audit.Logger
+	local     *LocalRecords
+	sinkhole  *Sinkhole
+	logger    *slog.Logger
+	audit     *audit.Logger
+	recorders []Recorder
+	taps      []Tap
+
+	mu       sync.RWMutex // the upstream can be replaced while queries are forwarded
+	upstream Upstream
+
+	listening atomic.Pointer[net.UDPAddr] // nil unless serving
+	heartbeat atomic.Int64                // last iteration of the serve loop, in nanoseconds since the epoch
 }
 
-func NewServer(sinkhole *Sinkhole, upstream io.ReadWriteCloser, logger *slog.Logger, audit *audit.Logger) *Server {
+func NewServer(local *LocalRecords, sinkhole *Sinkhole, upstream Upstream, logger *slog.Logger, audit *audit.Logger) *Server {
 	return &Server{
+		local:    local,
 		sinkhole: sinkhole,
 		upstream: upstream,
 		logger:   logger.With("source", "dns_server"),
@@ -37,25 +106,61 @@ func NewServer(sinkhole *Sinkhole, upstream io.ReadWriteCloser, logger *slog.Log
 	}
 }
 
-func (s *Server) Serve(ctx context.Context, address string) error {
+// SetUpstream replaces the upstream the queries are forwarded to: queries being forwarded keep using the previous one.
+func (s *Server) SetUpstream(upstream Upstream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upstream = upstream
+}
+
+// AddRecorder adds a recorder of the queries handled by the server: it must be called before Serve.
+func (s *Server) AddRecorder(recorder Recorder) {
+	s.recorders = append(s.recorders, recorder)
+}
+
+// AddTap adds a tap receiving copies of the messages exchanged by the server: it must be called before Serve.
+func (s *Server) AddTap(tap Tap) {
+	s.taps = append(s.taps, tap)
+}
+
+// Listen binds the UDP socket the server listens on.
+func Listen(address string) (*net.UDPConn, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp4", address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	conn, err := net.ListenUDP("udp4", udpAddr)
+	return net.ListenUDP("udp4", udpAddr)
+}
+
+// Serve listens on address and serves queries until ctx is done.
+func (s *Server) Serve(ctx context.Context, address string) error {
+	conn, err := Listen(address)
 	if err != nil {
 		return err
 	}
+
+	return s.ServeConn(ctx, conn)
+}
+
+// ServeConn serves the queries received on conn (e.g. bound by Listen, or passed by systemd) until ctx is done, then closes it.
+func (s *Server) ServeConn(ctx context.Context, conn *net.UDPConn) error {
 	defer conn.Close()
 
-	s.logger.Debug("Starting UDP server", "address", address)
+	address := conn.LocalAddr().(*net.UDPAddr)
+	s.listening.Store(address)
+	defer s.listening.Store(nil)
+
+	s.logger.Debug("Starting UDP server", "address", address.String())
 
 	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
 		return err
 	}
 
 	for {
+		s.heartbeat.Store(time.Now().UnixNano())
+
 		select {
 		case <-ctx.Done():
 			s.logger.Debug("Shutting down UDP server")
@@ -66,7 +171,7 @@ func (s *Server) Serve(ctx context.Context, address string) error {
 			}
 
 			rawQuery := make([]byte, maxPacketSize)
-			_, addr, err := conn.ReadFromUDP(rawQuery)
+			n, addr, err := conn.ReadFromUDP(rawQuery)
 			if err != nil {
 				if !errors.Is(err, os.ErrDeadlineExceeded) {
 					return err
@@ -74,7 +179,7 @@ func (s *Server) Serve(ctx context.Context, address string) error {
 				continue
 			}
 
-			if err := s.process(rawQuery, conn, addr); err != nil {
+			if err := s.process(rawQuery[:n], conn, addr); err != nil {
 				s.logger.Error("Error processing query", "error", err)
 				continue
 			}
@@ -82,60 +187,200 @@ func (s *Server) Serve(ctx context.Context, address string) error {
 	}
 }
 
-func (s *Server) process(rawQuery []byte, conn *net.UDPConn, addr *net.UDPAddr) error {
-	totalTimer := p.NewTimer(metrics.ResponseTimesTotal)
-	defer totalTimer.ObserveDuration()
+// Listening returns the address the server listens on, or nil if it is not serving.
+func (s *Server) Listening() *net.UDPAddr {
+	return s.listening.Load()
+}
+
+// LastServed returns when the serve loop last iterated, i.e. last handled a query or waited for one: it iterates at least every second
+// while healthy. It returns the zero time if the server has never served.
+func (s *Server) LastServed() time.Time {
+	nanos := s.heartbeat.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
+}
 
+func (s *Server) process(rawQuery []byte, conn *net.UDPConn, addr *net.UDPAddr) (err error) {
+	ctx, span := tracer.Start(context.Background(), "dns.query", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("network.transport", transportUDP)))
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	_, parseSpan := tracer.Start(ctx, "dns.parse")
 	query, err := message.UnmarshalQuery(rawQuery)
+	parseSpan.End()
 	if err != nil {
 		metrics.QueryParsingErrors.Inc()
 		return fmt.Errorf("unable to unmarshal query: %w, query: %v", err, rawQuery)
 	}
+	span.SetAttributes(attribute.String("dns.question.name", query.Question.Name), attribute.String("dns.question.type", query.Question.Type.String()))
 
-	response, handled := s.sinkhole.Resolve(query)
-	var rawResponse []byte
+	event := Event{
+		Time:   time.Now(),
+		Client: addr.AddrPort().Addr().Unmap(),
+		Name:   query.Question.Name,
+		Type:   query.Question.Type,
+	}
+	answered := false
+	defer func() {
+		rcode := metrics.RCodeNone
+		if answered {
+			rcode = event.RCode.String()
+		}
+		metrics.QueryDuration.WithLabelValues(transportUDP, event.Decision.String(), rcode).Observe(time.Since(event.Time).Seconds())
+		span.SetAttributes(attribute.String("sinkhole.decision", event.Decision.String()), attribute.String("dns.response.code", rcode))
+	}()
+
+	var client, server netip.AddrPort
+	if len(s.taps) > 0 {
+		client = netip.AddrPortFrom(event.Client, addr.AddrPort().Port())
+		if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			server = local.AddrPort()
+		}
+		s.tap(TapMessage{Kind: ClientQuery, Client: client, Server: server, QueryTime: event.Time, Query: rawQuery})
+	}
+
+	// local records take precedence over both the sinkhole and the upstream
+	_, localSpan := tracer.Start(ctx, "local.resolve")
+	response, handled := s.local.Resolve(query)
+	localSpan.End()
 	if handled {
-		metrics.BlockedQueries.Inc()
+		metrics.LocalQueries.Inc()
+		event.Decision = Local
+	} else {
+		_, sinkholeSpan := tracer.Start(ctx, "sinkhole.resolve")
+		result := s.sinkhole.Evaluate(query, event.Client)
+		sinkholeSpan.SetAttributes(attribute.String("sinkhole.decision", result.Decision.String()), attribute.String("sinkhole.group", result.Group),
+			attribute.String("sinkhole.list", result.List))
+		sinkholeSpan.End()
+		event.Decision, event.Group, event.List = result.Decision, result.Group, result.List
+		switch result.Decision {
+		case Blocked:
+			metrics.BlockedQueries.Inc()
+			response, handled = result.Response, true
+		case Dropped:
+			metrics.BlockedQueries.Inc()
+			s.logger.Debug("Dropping query", "domain", query.Question.Name)
+			s.logAudit(query.ID, event, nil, nil)
+			s.record(event)
+			return nil
+		case Paused:
+			metrics.PausedQueries.Inc()
+			s.logger.Debug("Forwarding query while blocking is paused", "domain", query.Question.Name, "group", result.Group, "list", result.List)
+		}
+	}
 
+	var rawResponse []byte
+	if handled {
 		rawResponse, err = message.MarshalResponse(response)
 		if err != nil {
 			metrics.ResponseMarshallingErrors.Inc()
 			return fmt.Errorf("unable to marshal response: %w, response: %v", err, rawResponse)
 		}
+		event.RCode = response.RCode()
 	} else {
 		metrics.UpstreamQueries.Inc()
 
-		rawResponse, err = s.queryUpstreamServer(rawQuery)
+		s.mu.RLock()
+		upstream := s.upstream
+		s.mu.RUnlock()
+
+		forwarded := time.Now()
+		rawResponse, event.Upstream, err = upstream.Exchange(ctx, query.Question.Name, rawQuery)
+		if len(s.taps) > 0 {
+			s.tapForwarded(client, event.Upstream, forwarded, rawQuery, rawResponse)
+		}
 		if err != nil {
 			metrics.UpstreamErrors.Inc()
 			return fmt.Errorf("unable to query upstream DNS: %w", err)
 		}
-
-		s.audit.Log(query.ID, uint16(query.Question.Type), rawQuery, rawResponse)
+		// the response is relayed as is, even if malformed
+		event.RCode, _ = message.RCodeOf(rawResponse)
 	}
 
-	writeTimer := p.NewTimer(metrics.ResponseTimesWriteResponse)
-	defer writeTimer.ObserveDuration()
-	if _, err := conn.WriteToUDP(rawResponse, addr); err != nil {
+	written := time.Now()
+	_, writeSpan := tracer.Start(ctx, "dns.write")
+	_, err = conn.WriteToUDP(rawResponse, addr)
+	writeSpan.End()
+	metrics.WriteResponseDuration.WithLabelValues(transportUDP).Observe(time.Since(written).Seconds())
+	if err != nil {
 		metrics.WriteResponseErrors.Inc()
 		return fmt.Errorf("unable to write response: %w", err)
 	}
+	answered = true
 
+	if len(s.taps) > 0 {
+		s.tap(TapMessage{Kind: ClientResponse, Client: client, Server: server, QueryTime: event.Time, ResponseTime: time.Now(), Query: rawQuery, Response: rawResponse})
+	}
+
+	s.logAudit(query.ID, event, response, rawResponse)
+	s.record(event)
 	return nil
 }
 
-func (s *Server) queryUpstreamServer(buffer []byte) ([]byte, error) {
-	timer := p.NewTimer(metrics.ResponseTimesUpstreamResolve)
-	defer timer.ObserveDuration()
+// tap passes a copy of a message to the taps.
+func (s *Server) tap(m TapMessage) {
+	for _, tap := range s.taps {
+		tap.Tap(m)
+	}
+}
 
-	if _, err := s.upstream.Write(buffer); err != nil {
-		return nil, err
+// tapForwarded passes the query of client forwarded to the upstream to the taps, along with the response, if any.
+func (s *Server) tapForwarded(client netip.AddrPort, upstream string, forwarded time.Time, rawQuery, rawResponse []byte) {
+	// the address is unknown if no upstream answered
+	server, _ := netip.ParseAddrPort(upstream)
+	s.tap(TapMessage{Kind: ForwarderQuery, Client: client, Server: server, QueryTime: forwarded, Query: rawQuery})
+	if rawResponse != nil {
+		s.tap(TapMessage{Kind: ForwarderResponse, Client: client, Server: server, QueryTime: forwarded, ResponseTime: time.Now(), Query: rawQuery,
+			Response: rawResponse})
 	}
+}
 
-	response := make([]byte, maxPacketSize)
-	if _, err := s.upstream.Read(response); err != nil {
-		return nil, err
+// logAudit logs the query to the audit log, along with its response: raw responses received from the upstream are decoded first.
+func (s *Server) logAudit(id uint16, event Event, response *message.Response, rawResponse []byte) {
+	if !s.audit.Enabled() {
+		return
+	}
+
+	record := audit.Record{
+		ID:       id,
+		Client:   event.Client,
+		Name:     event.Name,
+		Type:     event.Type.String(),
+		Decision: event.Decision.String(),
+		List:     event.List,
+	}
+	if event.Decision != Dropped {
+		record.RCode = event.RCode.String()
 	}
 
-	return response, nil
+	if response == nil && rawResponse != nil {
+		var err error
+		if response, err = message.UnmarshalResponse(rawResponse); err != nil {
+			s.logger.Debug("Unable to decode response for the audit log", "domain", event.Name, "error", err)
+		}
+	}
+
+	if response != nil {
+		for _, answer := range response.Answers {
+			record.Answers = append(record.Answers, answer.Type.String()+" "+answer.Value())
+		}
+	}
+
+	s.audit.Log(record)
+}
+
+// record passes the event to the recorders, along with the time it took to handle the query.
+func (s *Server) record(event Event) {
+	event.Duration = time.Since(event.Time)
+	for _, recorder := range s.recorders {
+		recorder.Record(event)
+	}
 }
@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/fedragon/sinkhole/audit"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/records"
+)
+
+// echo answers each query with itself, as if it were a response.
+type echo struct{}
+
+func (echo) Exchange(_ context.Context, _ string, query []byte) ([]byte, string, error) {
+	return query, "9.9.9.9:53", nil
+}
+
+func rawQuery(t *testing.T, name string) []byte {
+	data := []byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	encoded, err := message.MarshalName(name)
+	require.NoError(t, err)
+
+	return append(append(data, encoded...), 0x00, 0x01, 0x00, 0x01)
+}
+
+func TestServer_TracesQueries(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	store, err := records.Load(filepath.Join(t.TempDir(), "records"))
+	require.NoError(t, err)
+	sinkhole := NewSinkhole(slog.Default())
+	sinkhole.Register("ads.yyy")
+	auditLogger, err := audit.New(false, audit.Options{}, audit.Privacy{}, slog.Default())
+	require.NoError(t, err)
+	sut := NewServer(NewLocalRecords(store, slog.Default()), sinkhole, echo{}, slog.Default(), auditLogger)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	client := conn.LocalAddr().(*net.UDPAddr) // responses are written to, and ignored by, the server's own socket
+
+	require.NoError(t, sut.process(rawQuery(t, "example.com"), conn, client))
+
+	spans := recorder.Ended()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	assert.Equal(t, []string{"dns.parse", "local.resolve", "sinkhole.resolve", "dns.write", "dns.query"}, names)
+
+	root := spans[len(spans)-1]
+	for _, span := range spans[:len(spans)-1] {
+		assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID(), span.Name())
+	}
+	assert.Subset(t, root.Attributes(), []attribute.KeyValue{
+		attribute.String("dns.question.name", "example.com"),
+		attribute.String("dns.question.type", "A"),
+		attribute.String("sinkhole.decision", "forwarded"),
+		attribute.String("dns.response.code", "NOERROR"),
+	})
+
+	require.NoError(t, sut.process(rawQuery(t, "ads.yyy"), conn, client))
+	spans = recorder.Ended()
+	assert.Contains(t, spans[len(spans)-1].Attributes(), attribute.String("sinkhole.decision", "blocked"))
+}
+
+func TestServer_ReportsListening(t *testing.T) {
+	store, err := records.Load(filepath.Join(t.TempDir(), "records"))
+	require.NoError(t, err)
+	auditLogger, err := audit.New(false, audit.Options{}, audit.Privacy{}, slog.Default())
+	require.NoError(t, err)
+	sut := NewServer(NewLocalRecords(store, slog.Default()), NewSinkhole(slog.Default()), echo{}, slog.Default(), auditLogger)
+
+	assert.Nil(t, sut.Listening())
+	assert.True(t, sut.LastServed().IsZero())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sut.Serve(ctx, "127.0.0.1:0") }()
+
+	assert.Eventually(t, func() bool { return sut.Listening() != nil }, time.Second, 5*time.Millisecond)
+	assert.NotZero(t, sut.Listening().Port)
+	assert.WithinDuration(t, time.Now(), sut.LastServed(), 2*time.Second)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Nil(t, sut.Listening())
+}
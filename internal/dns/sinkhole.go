@@ -3,91 +3,511 @@ package dns
 import (
 	"log/slog"
 	"net/netip"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
 	p "github.com/prometheus/client_golang/prometheus"
 
 	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/hosts"
 	"github.com/fedragon/sinkhole/internal/metrics"
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/registry"
 )
 
+// DefaultList is the name of the list of a Sinkhole created with a single registry.
+const DefaultList = "default"
+
 var (
 	nonRoutableAddress     = netip.MustParseAddr("0.0.0.42")
 	NonRoutableAddressIPv4 = nonRoutableAddress.As4()
 	NonRoutableAddressIPv6 = nonRoutableAddress.As16()
 )
 
-// Sinkhole is a DNS server that receives queries and, if they are related to domains belonging to its internal registry, resolves them to non-routable addresses.
+// Registry stores the rules of the domains registered with the sinkhole.
+type Registry interface {
+	// Add registers a rule for domain, reporting whether the domain was not registered yet.
+	Add(domain string, rule hosts.Rule) bool
+	// Get returns the rule registered for domain, if any.
+	Get(domain string) (hosts.Rule, bool)
+	// Len returns the number of registered domains.
+	Len() int
+	// Wildcards returns the number of registered wildcard domains (i.e. starting with "*.").
+	Wildcards() int
+	// Range calls fn for each registered domain and its rule until fn returns false.
+	Range(fn func(domain string, rule hosts.Rule) bool)
+}
+
+// List is a named list of domains, along with the rules to apply to their queries.
+type List struct {
+	Name     string
+	Registry Registry
+	Disabled bool // disabled lists never match
+	Always   bool // whether the list applies to all groups, regardless of the lists enabled for them and of schedules
+}
+
+// Match is a rule registered for a domain by a list.
+type Match struct {
+	List string
+	Rule hosts.Rule
+}
+
+// Group is a group of clients, whose queries are evaluated against its own lists, allowlist and block mode.
+type Group struct {
+	Name      string
+	Lists     []string // names of the enabled lists: all of them if nil
+	Allowlist Registry // domains that are never blocked (optional)
+	BlockMode string   // how domains blocked by a list are answered: one of the policy.BlockMode* constants
+}
+
+// the group applying to clients that do not belong to any group, unless a group named policy.DefaultGroup is defined
+var defaultGroup = &Group{Name: policy.DefaultGroup, BlockMode: policy.BlockModeAddress}
+
+// Classifier maps clients to the names of the groups they belong to.
+type Classifier interface {
+	Match(client netip.Addr) (string, bool)
+}
+
+// Scheduler switches lists on and off for groups of clients.
+type Scheduler interface {
+	// Enabled reports whether the list is currently enabled for the group.
+	Enabled(group, list string) bool
+}
+
+// Pauser suspends blocking temporarily.
+type Pauser interface {
+	// Paused reports whether blocking is paused for the queries from the clients of group for the domains of list.
+	Paused(group, list string) bool
+}
+
+// Sinkhole is a DNS server that receives queries and, if they are related to domains belonging to its lists, resolves them to non-routable addresses.
 type Sinkhole struct {
-	registry map[string]struct{}
-	logger   *slog.Logger
+	mu         sync.RWMutex // lists and groups can be replaced while queries are evaluated
+	lists      []List
+	allowlist  Registry
+	groups     map[string]*Group
+	classifier Classifier
+	scheduler  Scheduler
+	pauser     Pauser
+	logger     *slog.Logger
 }
 
 func NewSinkhole(logger *slog.Logger) *Sinkhole {
+	return NewSinkholeWithRegistry(registry.NewMap(), logger)
+}
+
+// NewSinkholeWithRegistry returns a Sinkhole storing its domains in the provided registry.
+func NewSinkholeWithRegistry(registry Registry, logger *slog.Logger) *Sinkhole {
+	return NewSinkholeWithLists([]List{{Name: DefaultList, Registry: registry}}, logger)
+}
+
+// NewSinkholeWithLists returns a Sinkhole evaluating queries against the provided lists, in order: the first list registering a domain
+// determines the rule applied to its queries.
+func NewSinkholeWithLists(lists []List, logger *slog.Logger) *Sinkhole {
 	return &Sinkhole{
-		registry: make(map[string]struct{}),
-		logger:   logger.With("source", "sinkhole"),
+		lists:  lists,
+		groups: make(map[string]*Group),
+		logger: logger.With("source", "sinkhole"),
+	}
+}
+
+// SetLists replaces the lists: once it returns, the previous lists are no longer in use.
+func (s *Sinkhole) SetLists(lists []List) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lists = lists
+}
+
+// SetAllowlist sets the domains that are never blocked, for any group.
+func (s *Sinkhole) SetAllowlist(allowlist Registry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allowlist = allowlist
+}
+
+// SetGroups replaces the groups of clients, using classifier to find out which group each client belongs to. Clients that do not belong
+// to any group are assigned to the group named policy.DefaultGroup (if any), or have all lists enabled otherwise.
+func (s *Sinkhole) SetGroups(classifier Classifier, groups ...*Group) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.classifier = classifier
+	s.groups = make(map[string]*Group, len(groups))
+	for _, group := range groups {
+		s.groups[group.Name] = group
 	}
 }
 
-// Register registers a domain with the sinkhole.
+// SetScheduler sets the scheduler switching lists on and off, on top of the lists enabled for each group.
+func (s *Sinkhole) SetScheduler(scheduler Scheduler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scheduler = scheduler
+}
+
+// SetPauser sets the pauser suspending blocking temporarily.
+func (s *Sinkhole) SetPauser(pauser Pauser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pauser = pauser
+}
+
+// Lists returns the names of the lists, in order.
+func (s *Sinkhole) Lists() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.lists))
+	for _, list := range s.lists {
+		names = append(names, list.Name)
+	}
+
+	return names
+}
+
+// Groups returns the names of the groups of clients, including policy.DefaultGroup.
+func (s *Sinkhole) Groups() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := []string{policy.DefaultGroup}
+	for name := range s.groups {
+		if name != policy.DefaultGroup {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names[1:])
+
+	return names
+}
+
+// Register registers a domain with the sinkhole, blocking it.
 func (s *Sinkhole) Register(domain string) {
-	s.registry[domain] = struct{}{}
+	s.RegisterRule(domain, hosts.Rule{Action: hosts.Block})
+}
+
+// RegisterRule registers a domain with the first list of the sinkhole, along with the rule to apply to its queries, and reports whether
+// the domain was not registered yet. Domains starting with "*." match all subdomains of the rest of the name, but not the name itself.
+func (s *Sinkhole) RegisterRule(domain string, rule hosts.Rule) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lists[0].Registry.Add(domain, rule)
+}
+
+// Size returns the number of domains registered by the list, if it exists.
+func (s *Sinkhole) Size(list string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, l := range s.lists {
+		if l.Name == list {
+			return l.Registry.Len(), true
+		}
+	}
+
+	return 0, false
+}
+
+// Range calls fn for each domain registered by each list (in order), along with its rule, until fn returns false. Lists cannot be replaced
+// until it returns.
+func (s *Sinkhole) Range(fn func(list, domain string, rule hosts.Rule) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, list := range s.lists {
+		stopped := false
+		list.Registry.Range(func(domain string, rule hosts.Rule) bool {
+			stopped = !fn(list.Name, domain, rule)
+			return !stopped
+		})
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// Allowed reports whether the domain is allowed for all groups.
+func (s *Sinkhole) Allowed(domain string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.allowlist == nil {
+		return false
+	}
+
+	_, ok := find(s.allowlist, canonical(domain))
+	return ok
+}
+
+// Find returns the rules registered for the domain (or, via wildcards, for its parent domains) by each list, regardless of whether they are enabled.
+func (s *Sinkhole) Find(domain string) []Match {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domain = canonical(domain)
+
+	var matches []Match
+	for _, list := range s.lists {
+		if rule, ok := find(list.Registry, domain); ok {
+			matches = append(matches, Match{List: list.Name, Rule: rule})
+		}
+	}
+
+	return matches
+}
+
+// Decision is the outcome of evaluating a query against the sinkhole.
+type Decision uint8
+
+const (
+	Forwarded Decision = iota // the query must be forwarded to the upstream
+	Blocked                   // the query must be answered with the response of the sinkhole
+	Dropped                   // the query must not be answered at all
+	Paused                    // the query would be blocked, but blocking is paused: it must be forwarded to the upstream
+	Local                     // the query has been answered with local records (never returned by the sinkhole)
+)
+
+var decisions = [...]string{"forwarded", "blocked", "dropped", "paused", "local"}
+
+func (d Decision) String() string {
+	return decisions[d]
+}
+
+// Result is the outcome of evaluating a query against the sinkhole.
+type Result struct {
+	Decision Decision
+	Response *message.Response // only set if the query is Blocked
+	Group    string            // group of the client
+	List     string            // list registering the domain, if any
 }
 
-// Resolve resolves a query to a non-routable address, if the domain belongs to its registry.
-func (s *Sinkhole) Resolve(query *message.Query) (*message.Response, bool) {
+// Resolve resolves a query from client according to the rule registered for its domain by the lists enabled for the client's group, if any.
+// It returns false if the query must be forwarded to the upstream, or true and a nil response if it must be dropped.
+func (s *Sinkhole) Resolve(query *message.Query, client netip.Addr) (*message.Response, bool) {
+	result := s.Evaluate(query, client)
+	return result.Response, result.Decision == Blocked || result.Decision == Dropped
+}
+
+// Evaluate evaluates a query from client according to the rule registered for its domain by the lists enabled for the client's group, if any.
+func (s *Sinkhole) Evaluate(query *message.Query, client netip.Addr) Result {
 	if query.OpCode != 0 {
 		metrics.UnsupportedOpCodeQueries.With(p.Labels{"opcode": strconv.Itoa(int(query.OpCode))}).Inc()
-		return nil, false
+		return Result{}
 	}
 
 	if !query.RecursionDesired {
 		metrics.NonRecursiveQueries.Inc()
-		return nil, false
+		return Result{}
 	}
 
 	question := query.Question
 	if question.Class != message.ClassInternetAddress {
 		metrics.UnsupportedClassQueries.With(p.Labels{"class": strconv.Itoa(int(question.Class))}).Inc()
-		return nil, false
+		return Result{}
 	}
 
-	if question.Type != message.TypeA && question.Type != message.TypeAAAA {
-		metrics.UnsupportedTypeQueries.With(p.Labels{"type": strconv.Itoa(int(question.Type))}).Inc()
-		return nil, false
+	metrics.SupportedQueries.With(p.Labels{"type": strconv.Itoa(int(question.Type))}).Inc()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	group := s.group(client)
+	result := Result{Group: group.Name}
+
+	m, ok := s.lookup(group, canonical(question.Name))
+	if !ok {
+		return result
 	}
+	result.List = m.list
 
-	metrics.SupportedQueries.With(p.Labels{"type": strconv.Itoa(int(question.Type))}).Inc()
+	switch {
+	case m.paused:
+		result.Decision = Paused
+	case m.rule.Action == hosts.Passthru:
+	case m.rule.Action == hosts.Drop:
+		result.Decision = Dropped
+	default:
+		if result.Response = s.respond(query, group, m.rule); result.Response != nil {
+			result.Decision = Blocked
+		}
+	}
 
-	if s.Contains(question.Name) {
-		answer := message.Record{
-			DomainName: question.Name,
-			Class:      message.ClassInternetAddress,
-			TTL:        3600,
+	return result
+}
+
+// respond returns the response to a query for a domain registered with the rule, or nil if the query must be forwarded after all.
+func (s *Sinkhole) respond(query *message.Query, group *Group, rule hosts.Rule) *message.Response {
+	question := query.Question
+	answer := message.Record{
+		DomainName: question.Name,
+		Class:      message.ClassInternetAddress,
+		TTL:        3600,
+	}
+
+	switch rule.Action {
+	case hosts.NXDomain:
+		return message.NewErrorResponse(query, message.RCodeNameError)
+	case hosts.NoData:
+		return message.NewResponse(query)
+	case hosts.Rewrite:
+		target, err := message.MarshalName(rule.Target)
+		if err != nil {
+			s.logger.Error("Unable to marshal rewrite target", "domain", question.Name, "target", rule.Target, "error", err)
+			return nil
 		}
 
-		if question.Type == message.TypeA {
+		answer.Type = message.TypeCNAME
+		answer.Data = target
+		answer.Length = uint16(len(target))
+	default:
+		switch group.BlockMode {
+		case policy.BlockModeNXDomain:
+			return message.NewErrorResponse(query, message.RCodeNameError)
+		case policy.BlockModeNoData:
+			return message.NewResponse(query)
+		case policy.BlockModeRefused:
+			return message.NewErrorResponse(query, message.RCodeRefused)
+		}
+
+		switch question.Type {
+		case message.TypeA:
 			answer.Type = message.TypeA
 			answer.Data = NonRoutableAddressIPv4[:]
 			answer.Length = 4
-		} else {
+		case message.TypeAAAA:
 			answer.Type = message.TypeAAAA
 			answer.Data = NonRoutableAddressIPv6[:]
 			answer.Length = 16
+		default:
+			// e.g. HTTPS or TXT: there is no address to answer with
+			return message.NewResponse(query)
 		}
-
-		return message.NewResponse(query, answer), true
 	}
 
-	return nil, false
+	return message.NewResponse(query, answer)
 }
 
-// Contains returns true if the domain belongs to the sinkhole's registry
+// Contains returns true if the domain belongs to any list of the sinkhole.
 func (s *Sinkhole) Contains(domain string) bool {
-	timer := p.NewTimer(metrics.ResponseTimesInternalResolve)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domain = canonical(domain)
+	for _, list := range s.lists {
+		if _, ok := find(list.Registry, domain); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// group returns the group of client.
+func (s *Sinkhole) group(client netip.Addr) *Group {
+	if s.classifier != nil {
+		if name, ok := s.classifier.Match(client); ok {
+			if group, ok := s.groups[name]; ok {
+				return group
+			}
+		}
+	}
+
+	if group, ok := s.groups[policy.DefaultGroup]; ok {
+		return group
+	}
+
+	return defaultGroup
+}
+
+// match is a rule registered for a domain, along with the list registering it.
+type match struct {
+	rule   hosts.Rule
+	list   string
+	paused bool // whether blocking is paused for the list
+}
+
+// lookup returns the rule registered for the domain by the first of the group's enabled (and currently scheduled) lists to register it,
+// unless the domain is allowlisted. Lists whose blocking is paused are skipped, unless no other list registers the domain.
+func (s *Sinkhole) lookup(group *Group, domain string) (match, bool) {
+	timer := p.NewTimer(metrics.LookupDuration)
 	defer timer.ObserveDuration()
 
-	_, ok := s.registry[domain]
-	return ok
+	for _, allowlist := range []Registry{s.allowlist, group.Allowlist} {
+		if allowlist == nil {
+			continue
+		}
+
+		if _, ok := find(allowlist, domain); ok {
+			return match{}, false
+		}
+	}
+
+	var paused *match
+	for _, list := range s.lists {
+		if list.Disabled {
+			continue
+		}
+
+		if !list.Always && group.Lists != nil && !slices.Contains(group.Lists, list.Name) {
+			continue
+		}
+
+		if !list.Always && s.scheduler != nil && !s.scheduler.Enabled(group.Name, list.Name) {
+			continue
+		}
+
+		rule, ok := find(list.Registry, domain)
+		if !ok {
+			continue
+		}
+
+		if rule.Action != hosts.Passthru && s.pauser != nil && s.pauser.Paused(group.Name, list.Name) {
+			if paused == nil {
+				paused = &match{rule: rule, list: list.Name, paused: true}
+			}
+			continue
+		}
+
+		return match{rule: rule, list: list.Name}, true
+	}
+
+	if paused != nil {
+		return *paused, true
+	}
+
+	return match{}, false
+}
+
+// canonical returns the domain as registered by the lists: in lower case (names are case-insensitive, and some resolvers randomise the
+// case of their queries) and without trailing dot.
+func canonical(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// find returns the rule registered for the domain, falling back to wildcard rules registered for its parent domains.
+func find(registry Registry, domain string) (hosts.Rule, bool) {
+	if rule, ok := registry.Get(domain); ok {
+		return rule, true
+	}
+
+	if registry.Wildcards() == 0 {
+		return hosts.Rule{}, false
+	}
+
+	for i := strings.IndexByte(domain, '.'); i >= 0; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if rule, ok := registry.Get("*." + domain); ok {
+			return rule, true
+		}
+	}
+
+	return hosts.Rule{}, false
 }
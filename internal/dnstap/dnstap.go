@@ -0,0 +1,232 @@
+package dnstap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/metrics"
+)
+
+const (
+	bufferSize     = 10_000          // messages waiting to be sent: further ones are dropped
+	dialTimeout    = 5 * time.Second // also applies to the handshake
+	reconnectDelay = 5 * time.Second // delay between attempts to connect to the collector
+)
+
+// Field numbers and values of the dnstap schema, see https://github.com/dnstap/dnstap.pb/blob/master/dnstap.proto
+const (
+	dnstapIdentity = 1
+	dnstapVersion  = 2
+	dnstapMessage  = 14
+	dnstapType     = 15
+
+	messageType             = 1
+	messageSocketFamily     = 2
+	messageSocketProtocol   = 3
+	messageQueryAddress     = 4
+	messageResponseAddress  = 5
+	messageQueryPort        = 6
+	messageResponsePort     = 7
+	messageQueryTimeSec     = 8
+	messageQueryTimeNsec    = 9
+	messageQueryMessage     = 10
+	messageResponseTimeSec  = 12
+	messageResponseTimeNsec = 13
+	messageResponseMessage  = 14
+
+	typeMessage = 1 // the only type of Dnstap messages
+
+	typeClientQuery       = 5
+	typeClientResponse    = 6
+	typeForwarderQuery    = 7
+	typeForwarderResponse = 8
+
+	socketFamilyINET  = 1
+	socketFamilyINET6 = 2
+	socketProtocolUDP = 1
+)
+
+var messageTypes = map[dns.TapKind]uint64{
+	dns.ClientQuery:       typeClientQuery,
+	dns.ClientResponse:    typeClientResponse,
+	dns.ForwarderQuery:    typeForwarderQuery,
+	dns.ForwarderResponse: typeForwarderResponse,
+}
+
+// Output sends the messages exchanged by the DNS server to a dnstap collector. Messages are buffered, and dropped if the collector
+// cannot keep up with them (or is unreachable).
+type Output struct {
+	network  string
+	address  string
+	identity string
+	version  string
+	frames   chan []byte
+	logger   *slog.Logger
+}
+
+// NewOutput returns an output sending messages to the collector at addr, either `unix:<path>` or `tcp:<host>:<port>`: Run must be called
+// to connect to it. Identity and version describe the server to the collector.
+func NewOutput(addr, identity, version string, logger *slog.Logger) (*Output, error) {
+	network, address, ok := strings.Cut(addr, ":")
+	if !ok || address == "" || (network != "unix" && network != "tcp") {
+		return nil, fmt.Errorf("invalid dnstap address %q: expected unix:<path> or tcp:<host>:<port>", addr)
+	}
+
+	return &Output{
+		network:  network,
+		address:  address,
+		identity: identity,
+		version:  version,
+		frames:   make(chan []byte, bufferSize),
+		logger:   logger.With("source", "dnstap"),
+	}, nil
+}
+
+// Tap encodes the message and queues it: if too many messages are already queued, it is dropped.
+func (o *Output) Tap(m dns.TapMessage) {
+	select {
+	case o.frames <- o.encode(m):
+	default:
+		metrics.DnstapDropped.Inc()
+	}
+}
+
+// encode encodes the message as a Dnstap protobuf message.
+func (o *Output) encode(m dns.TapMessage) []byte {
+	var msg []byte
+	msg = protowire.AppendTag(msg, messageType, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, messageTypes[m.Kind])
+
+	// client messages are exchanged between the client (querying) and the server (responding), forwarder ones between the server, whose
+	// address is left out, and the upstream
+	query, response := m.Client, m.Server
+	if m.Kind == dns.ForwarderQuery || m.Kind == dns.ForwarderResponse {
+		query = netip.AddrPort{}
+	}
+
+	if family := socketFamily(query, response); family != 0 {
+		msg = protowire.AppendTag(msg, messageSocketFamily, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, family)
+		msg = protowire.AppendTag(msg, messageSocketProtocol, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, socketProtocolUDP)
+	}
+
+	msg = appendAddr(msg, messageQueryAddress, messageQueryPort, query)
+	msg = appendAddr(msg, messageResponseAddress, messageResponsePort, response)
+	msg = appendTime(msg, messageQueryTimeSec, messageQueryTimeNsec, m.QueryTime)
+	msg = protowire.AppendTag(msg, messageQueryMessage, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, m.Query)
+
+	if m.Response != nil {
+		msg = appendTime(msg, messageResponseTimeSec, messageResponseTimeNsec, m.ResponseTime)
+		msg = protowire.AppendTag(msg, messageResponseMessage, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, m.Response)
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, dnstapIdentity, protowire.BytesType)
+	b = protowire.AppendString(b, o.identity)
+	b = protowire.AppendTag(b, dnstapVersion, protowire.BytesType)
+	b = protowire.AppendString(b, o.version)
+	b = protowire.AppendTag(b, dnstapMessage, protowire.BytesType)
+	b = protowire.AppendBytes(b, msg)
+	b = protowire.AppendTag(b, dnstapType, protowire.VarintType)
+	return protowire.AppendVarint(b, typeMessage)
+}
+
+// socketFamily returns the family of the valid addresses, or 0 if none is valid.
+func socketFamily(addrs ...netip.AddrPort) uint64 {
+	for _, addr := range addrs {
+		if addr.IsValid() {
+			if addr.Addr().Unmap().Is4() {
+				return socketFamilyINET
+			}
+			return socketFamilyINET6
+		}
+	}
+
+	return 0
+}
+
+func appendAddr(b []byte, addrField, portField protowire.Number, addr netip.AddrPort) []byte {
+	if !addr.IsValid() {
+		return b
+	}
+
+	b = protowire.AppendTag(b, addrField, protowire.BytesType)
+	b = protowire.AppendBytes(b, addr.Addr().Unmap().AsSlice())
+	b = protowire.AppendTag(b, portField, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(addr.Port()))
+}
+
+func appendTime(b []byte, secField, nsecField protowire.Number, t time.Time) []byte {
+	b = protowire.AppendTag(b, secField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(t.Unix()))
+	b = protowire.AppendTag(b, nsecField, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, uint32(t.Nanosecond()))
+}
+
+// Run connects to the collector and sends it the queued messages until ctx is done, reconnecting whenever the connection fails.
+func (o *Output) Run(ctx context.Context) error {
+	for {
+		err := o.send(ctx)
+		if err == nil {
+			return nil
+		}
+		o.logger.Warn("Unable to send messages to the dnstap collector", "address", o.address, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// send connects to the collector and sends it the queued messages, returning nil once ctx is done or an error if the connection fails.
+func (o *Output) send(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, o.network, o.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	enc, err := newEncoder(conn, dialTimeout)
+	if err != nil {
+		return err
+	}
+	o.logger.Debug("Connected to the dnstap collector", "address", o.address)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return enc.close()
+		case frame := <-o.frames:
+			if err := enc.writeFrame(frame); err != nil {
+				metrics.DnstapDropped.Inc()
+				return err
+			}
+
+			// write as many frames as available at once, before flushing them
+			for pending := len(o.frames); pending > 0; pending-- {
+				if err := enc.writeFrame(<-o.frames); err != nil {
+					metrics.DnstapDropped.Inc()
+					return err
+				}
+			}
+
+			if err := enc.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package dnstap
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+)
+
+// fields decodes a protobuf message, keeping the last value of each field.
+func fields(t *testing.T, b []byte) map[protowire.Number]any {
+	values := make(map[protowire.Number]any)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			require.GreaterOrEqual(t, n, 0)
+			values[num], b = v, b[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			require.GreaterOrEqual(t, n, 0)
+			values[num], b = v, b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			require.GreaterOrEqual(t, n, 0)
+			values[num], b = v, b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+
+	return values
+}
+
+func TestOutput_Encode(t *testing.T) {
+	o, err := NewOutput("unix:/tmp/dnstap.sock", "pi", "v1", slog.Default())
+	require.NoError(t, err)
+
+	queried := time.Unix(1725271200, 123)
+	b := o.encode(dns.TapMessage{
+		Kind:         dns.ClientResponse,
+		Client:       netip.MustParseAddrPort("192.168.1.20:5353"),
+		Server:       netip.MustParseAddrPort("[::ffff:192.168.1.2]:53"),
+		QueryTime:    queried,
+		ResponseTime: queried.Add(time.Millisecond),
+		Query:        []byte("query"),
+		Response:     []byte("response"),
+	})
+
+	dnstap := fields(t, b)
+	assert.Equal(t, []byte("pi"), dnstap[dnstapIdentity])
+	assert.Equal(t, []byte("v1"), dnstap[dnstapVersion])
+	assert.Equal(t, uint64(typeMessage), dnstap[dnstapType])
+
+	msg := fields(t, dnstap[dnstapMessage].([]byte))
+	assert.Equal(t, map[protowire.Number]any{
+		messageType:             uint64(typeClientResponse),
+		messageSocketFamily:     uint64(socketFamilyINET),
+		messageSocketProtocol:   uint64(socketProtocolUDP),
+		messageQueryAddress:     []byte{192, 168, 1, 20},
+		messageQueryPort:        uint64(5353),
+		messageResponseAddress:  []byte{192, 168, 1, 2},
+		messageResponsePort:     uint64(53),
+		messageQueryTimeSec:     uint64(1725271200),
+		messageQueryTimeNsec:    uint32(123),
+		messageQueryMessage:     []byte("query"),
+		messageResponseTimeSec:  uint64(1725271200),
+		messageResponseTimeNsec: uint32(1_000_123),
+		messageResponseMessage:  []byte("response"),
+	}, msg)
+
+	// the upstream is unknown, if none answered
+	msg = fields(t, fields(t, o.encode(dns.TapMessage{Kind: dns.ForwarderQuery, QueryTime: queried, Query: []byte("query")}))[dnstapMessage].([]byte))
+	assert.Equal(t, uint64(typeForwarderQuery), msg[messageType])
+	assert.NotContains(t, msg, messageSocketFamily)
+	assert.NotContains(t, msg, messageResponseAddress)
+	assert.NotContains(t, msg, messageResponseMessage)
+}
+
+func TestNewOutput_RejectsInvalidAddresses(t *testing.T) {
+	for _, addr := range []string{"", "/tmp/dnstap.sock", "udp:127.0.0.1:6000", "tcp:"} {
+		_, err := NewOutput(addr, "", "", slog.Default())
+		assert.Error(t, err, addr)
+	}
+}
+
+func TestOutput_TapDropsMessagesWhenFull(t *testing.T) {
+	o, err := NewOutput("tcp:127.0.0.1:6000", "", "", slog.Default())
+	require.NoError(t, err)
+
+	for range bufferSize + 1 {
+		o.Tap(dns.TapMessage{Kind: dns.ClientQuery, QueryTime: time.Now(), Query: []byte("query")})
+	}
+
+	assert.Len(t, o.frames, bufferSize)
+}
+
+func TestOutput_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	o, err := NewOutput("unix:"+path, "pi", "v1", slog.Default())
+	require.NoError(t, err)
+	o.Tap(dns.TapMessage{Kind: dns.ClientQuery, QueryTime: time.Now(), Query: []byte("first")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- o.Run(ctx) }()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// READY, listing the content type
+	control, content := readControl(t, conn)
+	assert.Equal(t, uint32(controlReady), control)
+	assert.Equal(t, contentType, content)
+	writeControl(t, conn, controlAccept, contentType)
+
+	control, content = readControl(t, conn)
+	assert.Equal(t, uint32(controlStart), control)
+	assert.Equal(t, contentType, content)
+
+	assert.Equal(t, []byte("first"), queryMessage(t, readFrame(t, conn)))
+
+	o.Tap(dns.TapMessage{Kind: dns.ClientQuery, QueryTime: time.Now(), Query: []byte("second")})
+	assert.Equal(t, []byte("second"), queryMessage(t, readFrame(t, conn)))
+
+	cancel()
+	control, _ = readControl(t, conn)
+	assert.Equal(t, uint32(controlStop), control)
+	writeControl(t, conn, controlFinish, nil)
+
+	assert.NoError(t, <-done)
+}
+
+func readFrame(t *testing.T, r io.Reader) []byte {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	require.NoError(t, err)
+	require.NotZero(t, binary.BigEndian.Uint32(header), "unexpected control frame")
+
+	frame := make([]byte, binary.BigEndian.Uint32(header))
+	_, err = io.ReadFull(r, frame)
+	require.NoError(t, err)
+
+	return frame
+}
+
+// readControl reads a control frame, returning its type and content type (if any).
+func readControl(t *testing.T, r io.Reader) (uint32, []byte) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r, header)
+	require.NoError(t, err)
+	require.Zero(t, binary.BigEndian.Uint32(header), "unexpected data frame")
+
+	frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	_, err = io.ReadFull(r, frame)
+	require.NoError(t, err)
+
+	if len(frame) > 12 {
+		return binary.BigEndian.Uint32(frame), frame[12:]
+	}
+	return binary.BigEndian.Uint32(frame), nil
+}
+
+func writeControl(t *testing.T, w io.Writer, control uint32, content []byte) {
+	frame := binary.BigEndian.AppendUint32(nil, control)
+	if content != nil {
+		frame = binary.BigEndian.AppendUint32(frame, fieldContentType)
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(content)))
+		frame = append(frame, content...)
+	}
+
+	header := binary.BigEndian.AppendUint32(nil, 0)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(frame)))
+	_, err := w.Write(append(header, frame...))
+	require.NoError(t, err)
+}
+
+func queryMessage(t *testing.T, frame []byte) []byte {
+	msg := fields(t, fields(t, frame)[dnstapMessage].([]byte))
+	return msg[messageQueryMessage].([]byte)
+}
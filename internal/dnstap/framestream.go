@@ -0,0 +1,166 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Frame Streams control frames, see https://farsightsec.github.io/fstrm/
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+
+	fieldContentType = 0x01
+
+	maxControlSize = 512 // control frames are tiny: anything larger is a protocol error
+)
+
+// contentType identifies the payload of data frames as dnstap messages.
+var contentType = []byte("protobuf:dnstap.Dnstap")
+
+var byteOrder = binary.BigEndian
+
+// encoder writes a bidirectional Frame Stream: the reader must accept the content type before data frames are written.
+type encoder struct {
+	rw      io.ReadWriter
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// deadliner is implemented by connections, so that the handshake cannot hang forever.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// newEncoder performs the handshake over rw (READY, ACCEPT, START), returning an encoder ready to write data frames.
+func newEncoder(rw io.ReadWriter, timeout time.Duration) (*encoder, error) {
+	e := &encoder{rw: rw, w: bufio.NewWriter(rw), timeout: timeout}
+
+	if err := e.withDeadline(func() error {
+		if err := e.writeControl(controlReady, contentType); err != nil {
+			return err
+		}
+
+		accepted, err := e.readControl(controlAccept)
+		if err != nil {
+			return err
+		}
+		if !accepted {
+			return fmt.Errorf("content type %q not accepted", contentType)
+		}
+
+		return e.writeControl(controlStart, contentType)
+	}); err != nil {
+		return nil, fmt.Errorf("frame stream handshake: %w", err)
+	}
+
+	return e, nil
+}
+
+// withDeadline runs fn with a deadline, if rw supports them.
+func (e *encoder) withDeadline(fn func() error) error {
+	d, ok := e.rw.(deadliner)
+	if !ok {
+		return fn()
+	}
+
+	if err := d.SetDeadline(time.Now().Add(e.timeout)); err != nil {
+		return err
+	}
+
+	return errors.Join(fn(), d.SetDeadline(time.Time{}))
+}
+
+// writeFrame buffers a data frame: it is only sent on Flush, or once the buffer is full.
+func (e *encoder) writeFrame(payload []byte) error {
+	if _, err := e.w.Write(byteOrder.AppendUint32(nil, uint32(len(payload)))); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write(payload)
+	return err
+}
+
+func (e *encoder) flush() error {
+	return e.w.Flush()
+}
+
+// close ends the stream (STOP), waiting for the reader to acknowledge it (FINISH).
+func (e *encoder) close() error {
+	return e.withDeadline(func() error {
+		if err := e.writeControl(controlStop, nil); err != nil {
+			return err
+		}
+
+		_, err := e.readControl(controlFinish)
+		return err
+	})
+}
+
+// writeControl writes a control frame, with the content type field if set, and flushes it.
+func (e *encoder) writeControl(control uint32, contentType []byte) error {
+	frame := byteOrder.AppendUint32(nil, control)
+	if contentType != nil {
+		frame = byteOrder.AppendUint32(frame, fieldContentType)
+		frame = byteOrder.AppendUint32(frame, uint32(len(contentType)))
+		frame = append(frame, contentType...)
+	}
+
+	var header []byte
+	header = byteOrder.AppendUint32(header, 0) // escape: a control frame follows
+	header = byteOrder.AppendUint32(header, uint32(len(frame)))
+
+	if _, err := e.w.Write(append(header, frame...)); err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
+
+// readControl reads a control frame of the expected type, reporting whether it lists the content type (as ACCEPT frames should).
+func (e *encoder) readControl(expected uint32) (bool, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(e.rw, header); err != nil {
+		return false, err
+	}
+
+	if escape := byteOrder.Uint32(header); escape != 0 {
+		return false, errors.New("expected a control frame")
+	}
+
+	size := byteOrder.Uint32(header[4:])
+	if size < 4 || size > maxControlSize {
+		return false, fmt.Errorf("invalid control frame size: %d", size)
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(e.rw, frame); err != nil {
+		return false, err
+	}
+
+	if control := byteOrder.Uint32(frame); control != expected {
+		return false, fmt.Errorf("unexpected control frame: %#x, expected %#x", control, expected)
+	}
+
+	for fields := frame[4:]; len(fields) >= 8; {
+		field, length := byteOrder.Uint32(fields), byteOrder.Uint32(fields[4:])
+		if uint32(len(fields)-8) < length {
+			return false, errors.New("truncated control frame")
+		}
+
+		value := fields[8 : 8+length]
+		if field == fieldContentType && string(value) == string(contentType) {
+			return true, nil
+		}
+		fields = fields[8+length:]
+	}
+
+	return false, nil
+}
@@ -0,0 +1,118 @@
+package health
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check checks a component, returning a description of its state, or an error if it is unhealthy.
+type Check func() (string, error)
+
+// Component is the state of a component.
+type Component struct {
+	Status string `json:"status"` // one of: ok, fail
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the state of all the components checked: its status is only ok if theirs all are.
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker checks the liveness (i.e. whether the process must be restarted) and the readiness (i.e. whether it is able to answer
+// queries) of the sinkhole.
+type Checker struct {
+	mu        sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+	logger    *slog.Logger
+}
+
+func New(logger *slog.Logger) *Checker {
+	return &Checker{logger: logger.With("source", "health")}
+}
+
+// AddLiveness adds a check of the liveness of a component.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.liveness = append(c.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadiness adds a check of the readiness of a component.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readiness = append(c.readiness, namedCheck{name: name, check: check})
+}
+
+// Live checks the liveness of all components.
+func (c *Checker) Live() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return run(c.liveness)
+}
+
+// Ready checks the readiness of all components.
+func (c *Checker) Ready() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return run(c.readiness)
+}
+
+func run(checks []namedCheck) Report {
+	report := Report{Status: StatusOK, Components: make(map[string]Component, len(checks))}
+	for _, nc := range checks {
+		detail, err := nc.check()
+		if err != nil {
+			report.Status = StatusFail
+			report.Components[nc.name] = Component{Status: StatusFail, Detail: err.Error()}
+			continue
+		}
+		report.Components[nc.name] = Component{Status: StatusOK, Detail: detail}
+	}
+
+	return report
+}
+
+// Register registers the /healthz (liveness) and /readyz (readiness) endpoints with mux: they answer 200 if all components are ok,
+// 503 otherwise, along with the report.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		c.write(w, c.Live())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		c.write(w, c.Ready())
+	})
+}
+
+func (c *Checker) write(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		c.logger.Error("Unable to write response", "error", err)
+	}
+}
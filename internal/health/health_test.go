@@ -0,0 +1,55 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_Endpoints(t *testing.T) {
+	ready := false
+
+	c := New(slog.Default())
+	c.AddLiveness("process", func() (string, error) { return "up", nil })
+	c.AddReadiness("listener", func() (string, error) { return "listening", nil })
+	c.AddReadiness("upstreams", func() (string, error) {
+		if !ready {
+			return "", errors.New("no upstream answered")
+		}
+		return "1.1.1.1:53 answered", nil
+	})
+
+	mux := http.NewServeMux()
+	c.Register(mux)
+
+	get := func(path string) (int, Report) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var report Report
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		return w.Code, report
+	}
+
+	code, report := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Report{Status: StatusOK, Components: map[string]Component{"process": {Status: StatusOK, Detail: "up"}}}, report)
+
+	code, report = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, Component{Status: StatusOK, Detail: "listening"}, report.Components["listener"])
+	assert.Equal(t, Component{Status: StatusFail, Detail: "no upstream answered"}, report.Components["upstreams"])
+
+	ready = true
+	code, report = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+}
@@ -0,0 +1,60 @@
+package hosts
+
+import (
+	"bufio"
+	"strings"
+)
+
+// ParseDnsmasq starts parsing a list of dnsmasq directives and immediately returns a channel of Results, sending to it as parsing progresses.
+//
+// Only `address=/domain/.../[address]`, `local=/domain/.../` and `server=/domain/.../` (without address) are considered: since dnsmasq applies
+// them to the domain and all its subdomains, each domain yields both an exact and a wildcard ("*.domain") Result.
+// Directives without address (or with `server=`/`local=`) answer NXDOMAIN, any other address is replaced by the sinkhole's non-routable one.
+func ParseDnsmasq(scanner *bufio.Scanner) <-chan Result {
+	return scan(scanner, func(line string, e *emitter) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return
+		}
+
+		key = strings.TrimSpace(key)
+		if key != "address" && key != "local" && key != "server" {
+			return
+		}
+
+		parts := strings.Split(strings.TrimSpace(value), "/")
+		if len(parts) < 3 || parts[0] != "" {
+			e.warn("malformed %s directive", key)
+			return
+		}
+
+		domains, address := parts[1:len(parts)-1], parts[len(parts)-1]
+
+		var rule Rule
+		switch {
+		case address == "":
+			rule.Action = NXDomain
+		case key == "address":
+			rule.Action = Block
+		default:
+			// server=/domain/address forwards the domain to another resolver: nothing to sinkhole
+			return
+		}
+
+		for _, domain := range domains {
+			if domain == "#" {
+				e.warn("skipping catch-all domain")
+				continue
+			}
+
+			if e.emit(domain, rule) {
+				e.emit("*."+domain, rule)
+			}
+		}
+	})
+}
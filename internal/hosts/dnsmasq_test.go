@@ -0,0 +1,64 @@
+package hosts
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(ch <-chan Result) []Result {
+	var results []Result
+	for r := range ch {
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestParseDnsmasq_BlocksDomainAndSubdomains(t *testing.T) {
+	input := `
+# comment
+address=/ads.example.com/0.0.0.0
+`
+	results := collect(ParseDnsmasq(bufio.NewScanner(strings.NewReader(input))))
+	assert.Equal(t, []Result{
+		{Domain: "ads.example.com", Rule: Rule{Action: Block}, Line: 3},
+		{Domain: "*.ads.example.com", Rule: Rule{Action: Block}, Line: 3},
+	}, results)
+}
+
+func TestParseDnsmasq_AnswersNXDomain_WhenAddressIsMissing(t *testing.T) {
+	input := `
+address=/a.com/b.com/
+local=/c.com/
+`
+	results := collect(ParseDnsmasq(bufio.NewScanner(strings.NewReader(input))))
+	assert.Len(t, results, 6)
+	for _, r := range results {
+		assert.Equal(t, NXDomain, r.Action)
+	}
+	assert.Equal(t, "b.com", results[2].Domain)
+	assert.Equal(t, "*.c.com", results[5].Domain)
+}
+
+func TestParseDnsmasq_IgnoresForwardingAndOtherDirectives(t *testing.T) {
+	input := `
+server=/corp.example/10.0.0.1
+cache-size=1000
+`
+	results := collect(ParseDnsmasq(bufio.NewScanner(strings.NewReader(input))))
+	assert.Empty(t, results)
+}
+
+func TestParseDnsmasq_WarnsAboutUnsupportedDirectives(t *testing.T) {
+	input := `
+address=/#/0.0.0.0
+address=example.com
+`
+	results := collect(ParseDnsmasq(bufio.NewScanner(strings.NewReader(input))))
+	assert.Equal(t, []Result{
+		{Line: 2, Warning: "skipping catch-all domain"},
+		{Line: 3, Warning: "malformed address directive"},
+	}, results)
+}
@@ -0,0 +1,42 @@
+package hosts
+
+import (
+	"bufio"
+)
+
+// Summary reports the outcome of loading a list.
+type Summary struct {
+	Domains    int // number of domains registered
+	Duplicates int // number of domains that had already been registered
+	Skipped    int // number of lines (or hostnames within a line) skipped because of a warning
+}
+
+// Load parses the scanner's content according to format, passing each domain to register (which reports whether it was not registered yet)
+// and each warning to warn. It returns a summary of the outcome, or the first error encountered while reading.
+func Load(format Format, scanner *bufio.Scanner, register func(domain string, rule Rule) bool, warn func(Result)) (Summary, error) {
+	var summary Summary
+
+	results, err := ParseAs(format, scanner)
+	if err != nil {
+		return summary, err
+	}
+
+	for res := range results {
+		switch {
+		case res.Err != nil:
+			// drain the channel, so that the parsing goroutine can terminate
+			for range results {
+			}
+			return summary, res.Err
+		case res.Warning != "":
+			summary.Skipped++
+			warn(res)
+		case register(res.Domain, res.Rule):
+			summary.Domains++
+		default:
+			summary.Duplicates++
+		}
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,120 @@
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxNameLength  = 253
+	maxLabelLength = 63
+)
+
+var (
+	ErrEmptyName     = errors.New("empty name")
+	ErrNotAHostname  = errors.New("not a hostname")
+	ErrLocalHostname = errors.New("local hostname")
+
+	// IDNA profile used to convert internationalised names: unlike idna.Lookup, it tolerates underscores, which are not uncommon in lists
+	profile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+	// hostnames that many hosts files map to the local machine, and which must never be sinkholed
+	localHostnames = map[string]struct{}{
+		"localhost":             {},
+		"localhost.localdomain": {},
+		"local":                 {},
+		"broadcasthost":         {},
+		"ip6-localhost":         {},
+		"ip6-loopback":          {},
+		"ip6-localnet":          {},
+		"ip6-mcastprefix":       {},
+		"ip6-allnodes":          {},
+		"ip6-allrouters":        {},
+		"ip6-allhosts":          {},
+	}
+)
+
+// Normalize returns the canonical form of a domain name (lowercase, without trailing dot and with internationalised labels converted to punycode),
+// or an error if the name does not comply with RFC 1035 label rules. Underscores are tolerated, and a leading "*." wildcard label is preserved.
+func Normalize(name string) (string, error) {
+	wildcard := strings.HasPrefix(name, "*.")
+	if wildcard {
+		name = name[2:]
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return "", ErrEmptyName
+	}
+
+	if isASCII(name) {
+		name = strings.ToLower(name)
+	} else {
+		ascii, err := profile.ToASCII(name)
+		if err != nil {
+			return "", fmt.Errorf("invalid internationalised name: %w", err)
+		}
+		name = ascii
+	}
+
+	if _, err := netip.ParseAddr(name); err == nil {
+		return "", ErrNotAHostname
+	}
+
+	if _, ok := localHostnames[name]; ok {
+		return "", ErrLocalHostname
+	}
+
+	if err := validate(name); err != nil {
+		return "", err
+	}
+
+	if wildcard {
+		return "*." + name, nil
+	}
+
+	return name, nil
+}
+
+func validate(name string) error {
+	if len(name) > maxNameLength {
+		return fmt.Errorf("name longer than %d characters", maxNameLength)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return errors.New("empty label")
+		}
+
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("label longer than %d characters: %q", maxLabelLength, label)
+		}
+
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label starts or ends with a hyphen: %q", label)
+		}
+
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+				return fmt.Errorf("invalid character in label %q: %q", label, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -2,50 +2,170 @@ package hosts
 
 import (
 	"bufio"
+	"fmt"
+	"net/netip"
 	"strings"
 )
 
+// Action describes how the sinkhole answers queries for a domain.
+type Action uint8
+
+const (
+	// Block resolves the domain to a non-routable address.
+	Block Action = iota
+	// NXDomain answers that the domain does not exist.
+	NXDomain
+	// NoData answers that the domain exists, but has no records of the requested type.
+	NoData
+	// Passthru exempts the domain from blocking, letting its queries through to the upstream.
+	Passthru
+	// Drop silently discards the query, without answering.
+	Drop
+	// Rewrite answers with a CNAME record pointing to the rule's target.
+	Rewrite
+)
+
+var actionNames = [...]string{"block", "nxdomain", "nodata", "passthru", "drop", "rewrite"}
+
+func (a Action) String() string {
+	if int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+
+	return "unknown"
+}
+
+// Rule is the action to apply to a domain, along with its target (only used by Rewrite).
+type Rule struct {
+	Action Action
+	Target string
+}
+
 type Result struct {
 	Domain string
-	Err    error
+	Rule
+	Line    int    // number of the line the Result was parsed from
+	Warning string // reason why (part of) the line was skipped, if any: Domain is empty in that case
+	Err     error
+}
+
+// Format identifies the syntax of a list of domains.
+type Format string
+
+const (
+	FormatHosts   Format = "hosts"
+	FormatDnsmasq Format = "dnsmasq"
+	FormatRPZ     Format = "rpz"
+)
+
+// Valid reports whether the format is known.
+func (f Format) Valid() bool {
+	switch f {
+	case FormatHosts, FormatDnsmasq, FormatRPZ:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseAs starts parsing the scanner's content according to format, returning a channel of Results.
+func ParseAs(format Format, scanner *bufio.Scanner) (<-chan Result, error) {
+	switch format {
+	case FormatHosts:
+		return Parse(scanner), nil
+	case FormatDnsmasq:
+		return ParseDnsmasq(scanner), nil
+	case FormatRPZ:
+		return ParseRPZ(scanner), nil
+	default:
+		return nil, fmt.Errorf("unknown list format: %q", format)
+	}
 }
 
 const marker = "# start stevenblack"
 
 // Parse starts parsing a Steven Black hosts file and immediately returns a channel of Results, sending to it as parsing progresses.
 func Parse(scanner *bufio.Scanner) <-chan Result {
-	out := make(chan Result)
+	var started bool
 
-	go func(ch chan<- Result) {
-		defer close(out)
+	return scan(scanner, func(line string, e *emitter) {
+		line = strings.TrimSpace(line)
 
-		var started bool
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
+		if !started {
+			if strings.ToLower(line) == marker {
+				started = true
+				return
+			}
+		}
 
-			if !started {
-				if strings.ToLower(line) == marker {
-					started = true
-					continue
-				}
+		if strings.HasPrefix(line, "#") {
+			return
+		}
+
+		if started {
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				return
 			}
 
-			if strings.HasPrefix(line, "#") {
-				continue
+			if len(fields) < 2 {
+				e.warn("missing hostname")
+				return
 			}
 
-			if started {
-				fields := strings.Fields(line)
-				if len(fields) < 2 {
-					continue
-				}
+			if _, err := netip.ParseAddr(fields[0]); err != nil {
+				e.warn("invalid address %q", fields[0])
+				return
+			}
 
-				ch <- Result{Domain: fields[1]}
+			for _, hostname := range fields[1:] {
+				e.emit(hostname, Rule{Action: Block})
 			}
 		}
+	})
+}
+
+// emitter sends the Results parsed from a line to a channel, normalising their domains.
+type emitter struct {
+	ch   chan<- Result
+	line int
+}
+
+// emit sends a Result for domain, or a warning if domain is not a valid name. It returns false in the latter case.
+func (e *emitter) emit(domain string, rule Rule) bool {
+	normalized, err := Normalize(domain)
+	if err != nil {
+		e.warn("skipping %q: %v", domain, err)
+		return false
+	}
+
+	e.ch <- Result{Domain: normalized, Rule: rule, Line: e.line}
+	return true
+}
+
+func (e *emitter) warn(format string, args ...any) {
+	e.ch <- Result{Line: e.line, Warning: fmt.Sprintf(format, args...)}
+}
+
+// scan feeds each line of scanner to parseLine from a new goroutine, and returns the channel parseLine sends its Results to.
+func scan(scanner *bufio.Scanner, parseLine func(line string, e *emitter)) <-chan Result {
+	out := make(chan Result)
+
+	go func(ch chan<- Result) {
+		defer close(out)
+
+		e := &emitter{ch: ch}
+		for scanner.Scan() {
+			e.line++
+			parseLine(scanner.Text(), e)
+		}
 
 		if err := scanner.Err(); err != nil {
-			ch <- Result{Err: err}
+			ch <- Result{Line: e.line, Err: err}
 		}
 	}(out)
 
@@ -36,13 +36,18 @@ func TestParse_IgnoresCommentedLines(t *testing.T) {
 	assert.False(t, ok)
 }
 
-func TestParse_IgnoresMalformedLines(t *testing.T) {
+func TestParse_WarnsAboutMalformedLines(t *testing.T) {
 	input := fmt.Sprintf(`
 # start stevenblack
 1.2.3.4
 `)
 	ch := Parse(bufio.NewScanner(strings.NewReader(input)))
-	_, ok := <-ch
+	res, ok := <-ch
+	assert.True(t, ok)
+	assert.Empty(t, res.Domain)
+	assert.Equal(t, 3, res.Line)
+	assert.Equal(t, "missing hostname", res.Warning)
+	_, ok = <-ch
 	assert.False(t, ok)
 }
 
@@ -56,3 +61,68 @@ func TestParse_IsUnaffectedByTrailingComments(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, "www.federico.is", domain.Domain)
 }
+
+func TestParse_ReturnsEveryHostname_OnLine(t *testing.T) {
+	input := `# start stevenblack
+0.0.0.0 www.federico.is federico.is
+`
+	results := collect(Parse(bufio.NewScanner(strings.NewReader(input))))
+	assert.Equal(t, []Result{
+		{Domain: "www.federico.is", Line: 2},
+		{Domain: "federico.is", Line: 2},
+	}, results)
+}
+
+func TestParse_NormalisesHostnames(t *testing.T) {
+	input := `# start stevenblack
+0.0.0.0 WWW.Federico.IS.
+0.0.0.0 bücher.example
+`
+	results := collect(Parse(bufio.NewScanner(strings.NewReader(input))))
+	assert.Equal(t, []Result{
+		{Domain: "www.federico.is", Line: 2},
+		{Domain: "xn--bcher-kva.example", Line: 3},
+	}, results)
+}
+
+func TestParse_WarnsAboutInvalidHostnames(t *testing.T) {
+	input := `# start stevenblack
+0.0.0.0 localhost
+0.0.0.0 -invalid.com valid.com
+0.0.0.0 0.0.0.0
+not-an-ip invalid.com
+`
+	results := collect(Parse(bufio.NewScanner(strings.NewReader(input))))
+	assert.Equal(t, []Result{
+		{Line: 2, Warning: `skipping "localhost": local hostname`},
+		{Line: 3, Warning: `skipping "-invalid.com": label starts or ends with a hyphen: "-invalid"`},
+		{Domain: "valid.com", Line: 3},
+		{Line: 4, Warning: `skipping "0.0.0.0": not a hostname`},
+		{Line: 5, Warning: `invalid address "not-an-ip"`},
+	}, results)
+}
+
+func TestLoad_ReportsSummary(t *testing.T) {
+	input := `# start stevenblack
+0.0.0.0 a.com b.com
+0.0.0.0 a.com
+0.0.0.0
+`
+	registry := make(map[string]Rule)
+	var warnings []Result
+
+	summary, err := Load(FormatHosts, bufio.NewScanner(strings.NewReader(input)),
+		func(domain string, rule Rule) bool {
+			_, ok := registry[domain]
+			registry[domain] = rule
+			return !ok
+		},
+		func(res Result) {
+			warnings = append(warnings, res)
+		})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Summary{Domains: 2, Duplicates: 1, Skipped: 1}, summary)
+	assert.Len(t, warnings, 1)
+	assert.Equal(t, 4, warnings[0].Line)
+}
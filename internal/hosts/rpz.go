@@ -0,0 +1,157 @@
+package hosts
+
+import (
+	"bufio"
+	"strings"
+	"unicode"
+)
+
+// triggers that are not based on the query name, which the sinkhole cannot evaluate
+var unsupportedTriggers = []string{".rpz-ip", ".rpz-nsip", ".rpz-nsdname", ".rpz-client-ip"}
+
+// ParseRPZ starts parsing a Response Policy Zone file and immediately returns a channel of Results, sending to it as parsing progresses.
+//
+// Only QNAME triggers are supported, and their actions are mapped as follows:
+//   - `CNAME .` to NXDomain
+//   - `CNAME *.` to NoData
+//   - `CNAME rpz-passthru.` to Passthru
+//   - `CNAME rpz-drop.` to Drop
+//   - `CNAME <target>` (local data) to Rewrite
+//   - `A` and `AAAA` local data to Block
+//
+// Any other record (e.g. SOA, NS) is ignored.
+func ParseRPZ(scanner *bufio.Scanner) <-chan Result {
+	var origin, owner string
+	var depth int
+
+	return scan(scanner, func(line string, e *emitter) {
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+
+		// skip multi-line records (e.g. SOA), whose content is not relevant
+		inside := depth > 0
+		depth += strings.Count(line, "(") - strings.Count(line, ")")
+		if inside {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		if strings.HasPrefix(fields[0], "$") {
+			switch strings.ToUpper(fields[0]) {
+			case "$ORIGIN":
+				if len(fields) > 1 {
+					origin = strings.ToLower(fields[1])
+				}
+			case "$TTL":
+			default:
+				e.warn("unsupported directive %s", fields[0])
+			}
+			return
+		}
+
+		// lines starting with a blank refer to the previous owner
+		if !unicode.IsSpace(rune(line[0])) {
+			owner = strings.ToLower(fields[0])
+			fields = fields[1:]
+		}
+
+		for len(fields) > 0 && (isTTL(fields[0]) || isClass(fields[0])) {
+			fields = fields[1:]
+		}
+
+		if len(fields) < 2 || owner == "" {
+			e.warn("malformed record")
+			return
+		}
+
+		domain := relativeTo(owner, origin)
+		if domain == "" {
+			return
+		}
+
+		for _, trigger := range unsupportedTriggers {
+			if strings.HasSuffix(domain, trigger) {
+				e.warn("unsupported trigger %s", trigger[1:])
+				return
+			}
+		}
+
+		var rule Rule
+		switch strings.ToUpper(fields[0]) {
+		case "A", "AAAA":
+			rule.Action = Block
+		case "CNAME":
+			switch target := strings.ToLower(fields[1]); target {
+			case ".":
+				rule.Action = NXDomain
+			case "*.":
+				rule.Action = NoData
+			case "rpz-passthru.":
+				rule.Action = Passthru
+			case "rpz-drop.":
+				rule.Action = Drop
+			default:
+				if strings.HasPrefix(target, "*.") || strings.HasPrefix(target, "rpz-") {
+					e.warn("unsupported CNAME target %s", target)
+					return
+				}
+
+				normalized, err := Normalize(target)
+				if err != nil {
+					e.warn("skipping CNAME target %q: %v", target, err)
+					return
+				}
+				rule.Action = Rewrite
+				rule.Target = normalized
+			}
+		case "SOA", "NS":
+			return
+		default:
+			e.warn("unsupported record type %s", fields[0])
+			return
+		}
+
+		e.emit(domain, rule)
+	})
+}
+
+// relativeTo returns the name of owner relative to the zone's origin.
+func relativeTo(owner, origin string) string {
+	if owner == "@" {
+		return strings.TrimSuffix(origin, ".")
+	}
+
+	if !strings.HasSuffix(owner, ".") {
+		return owner
+	}
+
+	if origin != "" && strings.HasSuffix(owner, "."+origin) {
+		return strings.TrimSuffix(owner, "."+origin)
+	}
+
+	return strings.TrimSuffix(owner, ".")
+}
+
+func isTTL(field string) bool {
+	for _, r := range strings.ToLower(field) {
+		if !unicode.IsDigit(r) && !strings.ContainsRune("smhdw", r) {
+			return false
+		}
+	}
+
+	return unicode.IsDigit(rune(field[0]))
+}
+
+func isClass(field string) bool {
+	switch strings.ToUpper(field) {
+	case "IN", "CH", "HS", "CS":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,47 @@
+package hosts
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRPZ_MapsActions(t *testing.T) {
+	input := `
+$TTL 300
+$ORIGIN rpz.example.org.
+@ IN SOA localhost. root.localhost. (
+    1 ; serial
+    3600 900 2592000 7200 )
+  IN NS localhost.
+
+nx.com          CNAME .
+*.nx.com        CNAME .
+nodata.com  300 IN CNAME *.
+ok.com          CNAME rpz-passthru.
+drop.com        CNAME rpz-drop.
+safe.com        CNAME safe.example.net.
+bad.com.rpz.example.org. A 127.0.0.1
+                AAAA ::1 ; same owner
+32.1.0.0.10.rpz-ip CNAME .
+`
+	results := collect(ParseRPZ(bufio.NewScanner(strings.NewReader(input))))
+	assert.Equal(t, []Result{
+		{Domain: "nx.com", Rule: Rule{Action: NXDomain}, Line: 9},
+		{Domain: "*.nx.com", Rule: Rule{Action: NXDomain}, Line: 10},
+		{Domain: "nodata.com", Rule: Rule{Action: NoData}, Line: 11},
+		{Domain: "ok.com", Rule: Rule{Action: Passthru}, Line: 12},
+		{Domain: "drop.com", Rule: Rule{Action: Drop}, Line: 13},
+		{Domain: "safe.com", Rule: Rule{Action: Rewrite, Target: "safe.example.net"}, Line: 14},
+		{Domain: "bad.com", Rule: Rule{Action: Block}, Line: 15},
+		{Domain: "bad.com", Rule: Rule{Action: Block}, Line: 16},
+		{Line: 17, Warning: "unsupported trigger rpz-ip"},
+	}, results)
+}
+
+func TestParseAs_FailsOnUnknownFormat(t *testing.T) {
+	_, err := ParseAs("unknown", bufio.NewScanner(strings.NewReader("")))
+	assert.Error(t, err)
+}
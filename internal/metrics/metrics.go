@@ -1,10 +1,29 @@
 package metrics
 
 import (
+	"time"
+
 	p "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// RCodeNone is the rcode label of the durations of queries that got no response.
+const RCodeNone = "none"
+
+// Histograms are exposed both with classic buckets, for scrapers lacking support for native histograms, and as native histograms.
+const (
+	nativeBucketFactor     = 1.1 // each bucket is at most 10% wider than the previous one
+	nativeMaxBuckets       = 100
+	nativeMinResetDuration = time.Hour
+)
+
+var (
+	// from 100µs to 5s: answering queries, including forwarding them
+	queryBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+	// from 1µs to 10ms: steps that stay within the process, such as lookups
+	lookupBuckets = []float64{0.000001, 0.0000025, 0.000005, 0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01}
+)
+
 var (
 	NonRoutableDomains = promauto.NewGauge(
 		p.GaugeOpts{
@@ -23,37 +42,74 @@ var (
 	BlockedQueries  = queries.With(p.Labels{"blocked": "true"})
 	UpstreamQueries = queries.With(p.Labels{"blocked": "false"})
 
-	ResponseTimesTotal = promauto.NewSummary(
-		p.SummaryOpts{
-			Namespace:  "sinkhole",
-			Name:       "response_times_total_milliseconds",
-			Help:       "The distribution of response times, in milliseconds",
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	LocalQueries = promauto.NewCounter(
+		p.CounterOpts{
+			Namespace: "sinkhole",
+			Name:      "local_queries_total",
+			Help:      "The total number of queries answered with local records",
 		})
 
-	ResponseTimesInternalResolve = promauto.NewSummary(
-		p.SummaryOpts{
-			Namespace:  "sinkhole",
-			Name:       "response_times_resolve_milliseconds",
-			Help:       "The distribution of response times for resolving a domain in the sinkhole, in milliseconds",
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	PausedQueries = promauto.NewCounter(
+		p.CounterOpts{
+			Namespace: "sinkhole",
+			Name:      "paused_queries_total",
+			Help:      "The total number of queries that were forwarded, instead of blocked, because blocking was paused",
 		})
 
-	ResponseTimesUpstreamResolve = promauto.NewSummary(
-		p.SummaryOpts{
-			Namespace:  "sinkhole",
-			Name:       "response_times_upstream_resolve_milliseconds",
-			Help:       "The distribution of response times for resolving a domain in the upstream, in milliseconds",
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	ScheduleActive = promauto.NewGaugeVec(
+		p.GaugeOpts{
+			Namespace: "sinkhole",
+			Name:      "schedule_active",
+			Help:      "Whether a schedule is currently active (1) or not (0)",
+		},
+		[]string{"schedule"})
+
+	QueryDuration = promauto.NewHistogramVec(
+		p.HistogramOpts{
+			Namespace:                       "sinkhole",
+			Name:                            "query_duration_seconds",
+			Help:                            "The time taken to answer queries, from receiving them to writing their response, in seconds",
+			Buckets:                         queryBuckets,
+			NativeHistogramBucketFactor:     nativeBucketFactor,
+			NativeHistogramMaxBucketNumber:  nativeMaxBuckets,
+			NativeHistogramMinResetDuration: nativeMinResetDuration,
+		},
+		[]string{"transport", "decision", "rcode"})
+
+	UpstreamDuration = promauto.NewHistogramVec(
+		p.HistogramOpts{
+			Namespace:                       "sinkhole",
+			Name:                            "upstream_duration_seconds",
+			Help:                            "The time taken by upstream resolvers to answer forwarded queries, in seconds",
+			Buckets:                         queryBuckets,
+			NativeHistogramBucketFactor:     nativeBucketFactor,
+			NativeHistogramMaxBucketNumber:  nativeMaxBuckets,
+			NativeHistogramMinResetDuration: nativeMinResetDuration,
+		},
+		[]string{"upstream", "transport", "rcode"})
+
+	LookupDuration = promauto.NewHistogram(
+		p.HistogramOpts{
+			Namespace:                       "sinkhole",
+			Name:                            "lookup_duration_seconds",
+			Help:                            "The time taken to look domains up in the lists of the sinkhole, in seconds",
+			Buckets:                         lookupBuckets,
+			NativeHistogramBucketFactor:     nativeBucketFactor,
+			NativeHistogramMaxBucketNumber:  nativeMaxBuckets,
+			NativeHistogramMinResetDuration: nativeMinResetDuration,
 		})
 
-	ResponseTimesWriteResponse = promauto.NewSummary(
-		p.SummaryOpts{
-			Namespace:  "sinkhole",
-			Name:       "response_times_write_udp_response_milliseconds",
-			Help:       "The distribution of response times for writing a response to the UDP socket, in milliseconds",
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
-		})
+	WriteResponseDuration = promauto.NewHistogramVec(
+		p.HistogramOpts{
+			Namespace:                       "sinkhole",
+			Name:                            "write_response_duration_seconds",
+			Help:                            "The time taken to write responses to clients, in seconds",
+			Buckets:                         lookupBuckets,
+			NativeHistogramBucketFactor:     nativeBucketFactor,
+			NativeHistogramMaxBucketNumber:  nativeMaxBuckets,
+			NativeHistogramMinResetDuration: nativeMinResetDuration,
+		},
+		[]string{"transport"})
 
 	SupportedQueries = promauto.NewCounterVec(
 		p.CounterOpts{
@@ -86,14 +142,6 @@ var (
 		},
 		[]string{"class"})
 
-	UnsupportedTypeQueries = promauto.NewCounterVec(
-		p.CounterOpts{
-			Namespace: "sinkhole",
-			Name:      "unsupported_type_queries_total",
-			Help:      "The total number of queries with unsupported type",
-		},
-		[]string{"type"})
-
 	QueryParsingErrors = promauto.NewCounter(
 		p.CounterOpts{
 			Namespace: "sinkhole",
@@ -125,4 +173,28 @@ var (
 			Help:      "The total number of errors encountered when writing a response to the client",
 		},
 	)
+
+	QueryLogDropped = promauto.NewCounter(
+		p.CounterOpts{
+			Namespace: "sinkhole",
+			Name:      "query_log_dropped_total",
+			Help:      "The total number of queries left out of the query log because it could not keep up with them",
+		},
+	)
+
+	QueryLogErrors = promauto.NewCounter(
+		p.CounterOpts{
+			Namespace: "sinkhole",
+			Name:      "query_log_errors_total",
+			Help:      "The total number of errors encountered when writing to, or purging, the query log",
+		},
+	)
+
+	DnstapDropped = promauto.NewCounter(
+		p.CounterOpts{
+			Namespace: "sinkhole",
+			Name:      "dnstap_dropped_total",
+			Help:      "The total number of dnstap messages dropped because the collector could not keep up with them, or was unreachable",
+		},
+	)
 )
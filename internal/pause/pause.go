@@ -0,0 +1,157 @@
+package pause
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes of a pause.
+const (
+	ScopeGlobal = "global" // all queries
+	ScopeGroup  = "group"  // queries from the clients of a group
+	ScopeList   = "list"   // queries for the domains of a list
+)
+
+var (
+	ErrInvalidScope    = errors.New("invalid scope: expected one of global, group, list")
+	ErrInvalidDuration = errors.New("invalid duration: must be positive")
+	ErrNotPaused       = errors.New("not paused")
+)
+
+type key struct {
+	scope string
+	name  string
+}
+
+// Pause is a temporary suspension of blocking.
+type Pause struct {
+	Scope            string    `json:"scope"`
+	Name             string    `json:"name,omitempty"` // name of the group or list, empty for global pauses
+	Until            time.Time `json:"until"`
+	RemainingSeconds int64     `json:"remaining_seconds"`
+}
+
+// Pauses keeps track of the pauses of blocking, each of which automatically expires after its duration.
+type Pauses struct {
+	mu     sync.RWMutex
+	pauses map[key]time.Time
+	now    func() time.Time
+}
+
+// New returns an empty set of pauses, expiring according to the time returned by now.
+func New(now func() time.Time) *Pauses {
+	return &Pauses{pauses: make(map[key]time.Time), now: now}
+}
+
+// Pause suspends blocking in the scope (for the group or list with the provided name, if applicable) for duration, replacing
+// any previous pause of the same scope and name.
+func (p *Pauses) Pause(scope, name string, duration time.Duration) (Pause, error) {
+	if duration <= 0 {
+		return Pause{}, ErrInvalidDuration
+	}
+
+	k, err := newKey(scope, name)
+	if err != nil {
+		return Pause{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.pauses[k] = now.Add(duration)
+	p.expire(now)
+
+	return newPause(k, p.pauses[k], now), nil
+}
+
+// Resume re-enables blocking in the scope before the pause expires.
+func (p *Pauses) Resume(scope, name string) error {
+	k, err := newKey(scope, name)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until, ok := p.pauses[k]; !ok || !p.now().Before(until) {
+		return ErrNotPaused
+	}
+	delete(p.pauses, k)
+
+	return nil
+}
+
+// Paused reports whether blocking is paused for the queries from the clients of group for the domains of list.
+func (p *Pauses) Paused(group, list string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.pauses) == 0 {
+		return false
+	}
+
+	now := p.now()
+	for _, k := range []key{{scope: ScopeGlobal}, {scope: ScopeGroup, name: group}, {scope: ScopeList, name: list}} {
+		if until, ok := p.pauses[k]; ok && now.Before(until) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// All returns the pauses that have not expired yet, along with their remaining time.
+func (p *Pauses) All() []Pause {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.expire(now)
+
+	pauses := make([]Pause, 0, len(p.pauses))
+	for k, until := range p.pauses {
+		pauses = append(pauses, newPause(k, until, now))
+	}
+
+	slices.SortFunc(pauses, func(a, b Pause) int {
+		if c := strings.Compare(a.Scope, b.Scope); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return pauses
+}
+
+// expire removes the expired pauses.
+func (p *Pauses) expire(now time.Time) {
+	for k, until := range p.pauses {
+		if !now.Before(until) {
+			delete(p.pauses, k)
+		}
+	}
+}
+
+func newKey(scope, name string) (key, error) {
+	switch scope {
+	case ScopeGlobal:
+		return key{scope: scope}, nil
+	case ScopeGroup, ScopeList:
+		if name == "" {
+			return key{}, errors.New("missing name of the " + scope)
+		}
+		return key{scope: scope, name: name}, nil
+	default:
+		return key{}, ErrInvalidScope
+	}
+}
+
+func newPause(k key, until, now time.Time) Pause {
+	// round up, so that a pause is never reported as having 0 seconds left before it expires
+	return Pause{Scope: k.scope, Name: k.name, Until: until, RemainingSeconds: int64((until.Sub(now) + time.Second - 1) / time.Second)}
+}
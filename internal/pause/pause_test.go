@@ -0,0 +1,63 @@
+package pause
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPauses_ExpireAutomatically(t *testing.T) {
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+	sut := New(func() time.Time { return now })
+
+	assert.False(t, sut.Paused("kids", "social"))
+
+	_, err := sut.Pause(ScopeList, "social", 5*time.Minute)
+	assert.NoError(t, err)
+	_, err = sut.Pause(ScopeGroup, "work", 10*time.Minute)
+	assert.NoError(t, err)
+
+	assert.True(t, sut.Paused("kids", "social"))
+	assert.False(t, sut.Paused("kids", "malware"))
+	assert.True(t, sut.Paused("work", "malware"))
+
+	now = now.Add(4*time.Minute + 30*time.Second)
+	assert.Equal(t, []Pause{
+		{Scope: ScopeGroup, Name: "work", Until: time.Date(2024, 9, 2, 10, 10, 0, 0, time.UTC), RemainingSeconds: 330},
+		{Scope: ScopeList, Name: "social", Until: time.Date(2024, 9, 2, 10, 5, 0, 0, time.UTC), RemainingSeconds: 30},
+	}, sut.All())
+
+	now = now.Add(30 * time.Second)
+	assert.False(t, sut.Paused("kids", "social"))
+	assert.True(t, sut.Paused("work", "social"))
+	assert.Len(t, sut.All(), 1)
+	assert.ErrorIs(t, sut.Resume(ScopeList, "social"), ErrNotPaused)
+}
+
+func TestPauses_Global(t *testing.T) {
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+	sut := New(func() time.Time { return now })
+
+	p, err := sut.Pause(ScopeGlobal, "ignored", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, Pause{Scope: ScopeGlobal, Until: now.Add(time.Minute), RemainingSeconds: 60}, p)
+	assert.True(t, sut.Paused("kids", "social"))
+
+	assert.NoError(t, sut.Resume(ScopeGlobal, ""))
+	assert.False(t, sut.Paused("kids", "social"))
+	assert.Empty(t, sut.All())
+}
+
+func TestPauses_RejectsInvalidRequests(t *testing.T) {
+	sut := New(time.Now)
+
+	_, err := sut.Pause("everything", "", time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidScope)
+
+	_, err = sut.Pause(ScopeGroup, "", time.Minute)
+	assert.Error(t, err)
+
+	_, err = sut.Pause(ScopeGlobal, "", -time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidDuration)
+}
@@ -0,0 +1,318 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+// DefaultGroup is the name of the group applying to the clients that do not belong to any other group.
+const DefaultGroup = "default"
+
+// CustomList is the name reserved for the list of custom entries, blocked on top of the lists for all groups.
+const CustomList = "custom"
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+	ErrSave     = errors.New("unable to save policy")
+)
+
+// Block modes, determining how domains blocked by a list are answered.
+const (
+	BlockModeAddress  = "address" // non-routable address (default)
+	BlockModeNXDomain = "nxdomain"
+	BlockModeNoData   = "nodata"
+	BlockModeRefused  = "refused"
+)
+
+// Policy defines the lists of domains handled by the sinkhole, and the groups of clients they apply to.
+type Policy struct {
+	Lists     []List     `json:"lists" yaml:"lists"`
+	Groups    []Group    `json:"groups" yaml:"groups"`
+	Schedules []Schedule `json:"schedules" yaml:"schedules"`
+}
+
+// List is a named list of domains, read from a file.
+type List struct {
+	Name     string `json:"name" yaml:"name"`
+	Path     string `json:"path" yaml:"path"`
+	Format   string `json:"format" yaml:"format"`               // one of: hosts (default), dnsmasq, rpz
+	Disabled bool   `json:"disabled,omitempty" yaml:"disabled"` // disabled lists never block any domain
+}
+
+// Group is a group of clients, whose queries are evaluated against its own lists, allowlist and block mode.
+type Group struct {
+	Name      string   `json:"name" yaml:"name"`
+	Clients   []string `json:"clients" yaml:"clients"`       // IP addresses, CIDR prefixes or MAC addresses
+	Lists     []string `json:"lists" yaml:"lists"`           // names of the enabled lists: all of them if omitted
+	Allowlist []string `json:"allowlist" yaml:"allowlist"`   // domains that are never blocked: "*.domain" matches all subdomains of domain
+	BlockMode string   `json:"block_mode" yaml:"block_mode"` // one of: address (default), nxdomain, nodata, refused
+}
+
+// Schedule switches lists on (while any of its periods is ongoing) and off (otherwise) for groups of clients.
+type Schedule struct {
+	Name     string   `json:"name" yaml:"name"`
+	Groups   []string `json:"groups" yaml:"groups"`     // names of the groups it applies to: all of them if omitted
+	Lists    []string `json:"lists" yaml:"lists"`       // names of the lists it switches on and off
+	Timezone string   `json:"timezone" yaml:"timezone"` // IANA name of the timezone of its periods (e.g. Europe/Amsterdam): local time if omitted
+	Periods  []Period `json:"periods" yaml:"periods"`
+}
+
+// Period is a recurring time range, e.g. from 08:00 to 15:00 on weekdays.
+type Period struct {
+	Days  []string `json:"days" yaml:"days"`   // mon, tue, wed, thu, fri, sat, sun: every day if omitted
+	Start string   `json:"start" yaml:"start"` // HH:MM
+	End   string   `json:"end" yaml:"end"`     // HH:MM: if not after start, the period ends on the next day
+}
+
+// Load reads the policy at path (if it exists), which must then be validated.
+func Load(path string) (Policy, error) {
+	var p Policy
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("unable to parse policy: %w", err)
+	}
+
+	return p, nil
+}
+
+// Validate checks that names are unique and that groups only reference existing lists, filling in default values.
+func (p *Policy) Validate() error {
+	var errs []error
+
+	var lists []string
+	for i := range p.Lists {
+		list := &p.Lists[i]
+		if list.Format == "" {
+			list.Format = string(hosts.FormatHosts)
+		}
+
+		switch {
+		case list.Name == "":
+			errs = append(errs, fmt.Errorf("list %d: missing name", i))
+		case list.Name == CustomList:
+			errs = append(errs, fmt.Errorf("list %q: reserved name", list.Name))
+		case slices.Contains(lists, list.Name):
+			errs = append(errs, fmt.Errorf("list %q: duplicate name", list.Name))
+		case list.Path == "":
+			errs = append(errs, fmt.Errorf("list %q: missing path", list.Name))
+		case !hosts.Format(list.Format).Valid():
+			errs = append(errs, fmt.Errorf("list %q: unknown format %q: expected hosts, dnsmasq or rpz", list.Name, list.Format))
+		}
+		lists = append(lists, list.Name)
+	}
+
+	var groups []string
+	owners := make(map[string]string) // group of each client, to detect duplicates: nested prefixes are allowed, the most specific matching first
+	for i := range p.Groups {
+		group := &p.Groups[i]
+		if group.BlockMode == "" {
+			group.BlockMode = BlockModeAddress
+		}
+
+		switch {
+		case group.Name == "":
+			errs = append(errs, fmt.Errorf("group %d: missing name", i))
+		case slices.Contains(groups, group.Name):
+			errs = append(errs, fmt.Errorf("group %q: duplicate name", group.Name))
+		}
+		groups = append(groups, group.Name)
+
+		for _, name := range group.Lists {
+			if !slices.Contains(lists, name) {
+				errs = append(errs, fmt.Errorf("group %q: unknown list %q", group.Name, name))
+			}
+		}
+
+		for _, client := range group.Clients {
+			key, err := clientKey(client)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("group %q: %w", group.Name, err))
+				continue
+			}
+
+			if owner, ok := owners[key]; ok {
+				errs = append(errs, fmt.Errorf("group %q: duplicate client %q, already in group %q", group.Name, client, owner))
+				continue
+			}
+			owners[key] = group.Name
+		}
+
+		for j, domain := range group.Allowlist {
+			normalized, err := hosts.Normalize(domain)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("group %q: invalid allowlist domain %q: %w", group.Name, domain, err))
+				continue
+			}
+			group.Allowlist[j] = normalized
+		}
+
+		switch group.BlockMode {
+		case BlockModeAddress, BlockModeNXDomain, BlockModeNoData, BlockModeRefused:
+		default:
+			errs = append(errs, fmt.Errorf("group %q: unknown block mode %q", group.Name, group.BlockMode))
+		}
+	}
+
+	var schedules []string
+	for i, schedule := range p.Schedules {
+		switch {
+		case schedule.Name == "":
+			errs = append(errs, fmt.Errorf("schedule %d: missing name", i))
+		case slices.Contains(schedules, schedule.Name):
+			errs = append(errs, fmt.Errorf("schedule %q: duplicate name", schedule.Name))
+		case len(schedule.Lists) == 0:
+			errs = append(errs, fmt.Errorf("schedule %q: missing lists", schedule.Name))
+		}
+		schedules = append(schedules, schedule.Name)
+
+		for _, name := range schedule.Groups {
+			if name != DefaultGroup && !slices.Contains(groups, name) {
+				errs = append(errs, fmt.Errorf("schedule %q: unknown group %q", schedule.Name, name))
+			}
+		}
+
+		for _, name := range schedule.Lists {
+			if !slices.Contains(lists, name) {
+				errs = append(errs, fmt.Errorf("schedule %q: unknown list %q", schedule.Name, name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// clientKey returns the canonical form of a client (an IP address, a CIDR prefix or a MAC address): an address is the same client as the
+// prefix only containing it. Prefixes of different lengths are different clients, even if one contains the other.
+func clientKey(client string) (string, error) {
+	if addr, err := netip.ParseAddr(client); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+	}
+
+	if prefix, err := netip.ParsePrefix(client); err == nil {
+		return prefix.Masked().String(), nil
+	}
+
+	if mac, err := net.ParseMAC(client); err == nil {
+		return mac.String(), nil
+	}
+
+	return "", fmt.Errorf("invalid client %q: expected an IP address, a CIDR prefix or a MAC address", client)
+}
+
+// List returns the list with the provided name, if any.
+func (p Policy) List(name string) (List, bool) {
+	i := slices.IndexFunc(p.Lists, func(l List) bool { return l.Name == name })
+	if i < 0 {
+		return List{}, false
+	}
+
+	return p.Lists[i], true
+}
+
+// WithList returns a copy of the policy including the list, which must not exist yet.
+func (p Policy) WithList(list List) (Policy, error) {
+	if _, ok := p.List(list.Name); ok {
+		return p, fmt.Errorf("list %q: %w", list.Name, ErrExists)
+	}
+
+	p.Lists = append(slices.Clone(p.Lists), list)
+	return p, nil
+}
+
+// WithoutList returns a copy of the policy without the list, which must exist.
+func (p Policy) WithoutList(name string) (Policy, error) {
+	if _, ok := p.List(name); !ok {
+		return p, fmt.Errorf("list %q: %w", name, ErrNotFound)
+	}
+
+	p.Lists = slices.DeleteFunc(slices.Clone(p.Lists), func(l List) bool { return l.Name == name })
+	return p, nil
+}
+
+// WithListDisabled returns a copy of the policy in which the list, which must exist, is disabled (or enabled).
+func (p Policy) WithListDisabled(name string, disabled bool) (Policy, error) {
+	i := slices.IndexFunc(p.Lists, func(l List) bool { return l.Name == name })
+	if i < 0 {
+		return p, fmt.Errorf("list %q: %w", name, ErrNotFound)
+	}
+
+	p.Lists = slices.Clone(p.Lists)
+	p.Lists[i].Disabled = disabled
+	return p, nil
+}
+
+// WithGroup returns a copy of the policy including the group, replacing any group with the same name.
+func (p Policy) WithGroup(group Group) Policy {
+	groups := slices.Clone(p.Groups)
+	if i := slices.IndexFunc(groups, func(g Group) bool { return g.Name == group.Name }); i >= 0 {
+		groups[i] = group
+	} else {
+		groups = append(groups, group)
+	}
+
+	p.Groups = groups
+	return p
+}
+
+// WithoutGroup returns a copy of the policy without the group, which must exist.
+func (p Policy) WithoutGroup(name string) (Policy, error) {
+	groups := slices.DeleteFunc(slices.Clone(p.Groups), func(g Group) bool { return g.Name == name })
+	if len(groups) == len(p.Groups) {
+		return p, fmt.Errorf("group %q: %w", name, ErrNotFound)
+	}
+
+	p.Groups = groups
+	return p, nil
+}
+
+// Save atomically writes the policy to the file at path.
+func Save(path string, p Policy) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+
+	return nil
+}
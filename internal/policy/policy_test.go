@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+		"lists": [
+			{"name": "malware", "path": "malware.txt"},
+			{"name": "social", "path": "social.rpz", "format": "rpz"}
+		],
+		"groups": [
+			{"name": "kids", "clients": ["192.168.1.20"], "allowlist": ["School.Example"], "block_mode": "nxdomain"},
+			{"name": "work", "clients": ["192.168.2.0/24"], "lists": ["malware"]}
+		]
+	}`), 0644))
+
+	p, err := Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Validate())
+
+	assert.Equal(t, Policy{
+		Lists: []List{
+			{Name: "malware", Path: "malware.txt", Format: "hosts"},
+			{Name: "social", Path: "social.rpz", Format: "rpz"},
+		},
+		Groups: []Group{
+			{Name: "kids", Clients: []string{"192.168.1.20"}, Allowlist: []string{"school.example"}, BlockMode: BlockModeNXDomain},
+			{Name: "work", Clients: []string{"192.168.2.0/24"}, Lists: []string{"malware"}, BlockMode: BlockModeAddress},
+		},
+	}, p)
+}
+
+func TestValidate_ReportsAllErrors(t *testing.T) {
+	p := Policy{
+		Lists: []List{
+			{Name: "malware", Path: "malware.txt"},
+			{Name: "malware", Path: "other.txt"},
+			{Name: "social"},
+			{Name: "custom", Path: "custom.txt"},
+			{Name: "ads", Path: "ads.txt", Format: "adblock"},
+		},
+		Groups: []Group{
+			{Name: "kids", Lists: []string{"gaming"}, Allowlist: []string{"192.168.1.1"}, BlockMode: "silent", Clients: []string{"192.168.1.0/24", "tablet"}},
+			{Name: "parents", Clients: []string{"192.168.1.128/24", "192.168.1.10", "aa:bb:cc:dd:ee:ff"}},
+			{Name: "guests", Clients: []string{"192.168.1.10/32", "AA-BB-CC-DD-EE-FF"}},
+		},
+		Schedules: []Schedule{
+			{Name: "school", Groups: []string{"kids", "teens"}, Lists: []string{"social"}},
+			{Name: "bedtime"},
+		},
+	}
+
+	err := p.Validate()
+	assert.ErrorContains(t, err, `list "malware": duplicate name`)
+	assert.ErrorContains(t, err, `list "social": missing path`)
+	assert.ErrorContains(t, err, `list "custom": reserved name`)
+	assert.ErrorContains(t, err, `group "kids": unknown list "gaming"`)
+	assert.ErrorContains(t, err, `group "kids": invalid allowlist domain "192.168.1.1"`)
+	assert.ErrorContains(t, err, `group "kids": unknown block mode "silent"`)
+	assert.ErrorContains(t, err, `list "ads": unknown format "adblock"`)
+	assert.ErrorContains(t, err, `group "kids": invalid client "tablet"`)
+	assert.ErrorContains(t, err, `group "parents": duplicate client "192.168.1.128/24", already in group "kids"`)
+	assert.ErrorContains(t, err, `group "guests": duplicate client "192.168.1.10/32", already in group "parents"`)
+	assert.ErrorContains(t, err, `group "guests": duplicate client "AA-BB-CC-DD-EE-FF", already in group "parents"`)
+	assert.NotContains(t, err.Error(), `duplicate client "192.168.1.10"`, "nested prefixes match the most specific first")
+	assert.ErrorContains(t, err, `schedule "school": unknown group "teens"`)
+	assert.ErrorContains(t, err, `schedule "bedtime": missing lists`)
+}
+
+func TestValidate_AllowsNestedPrefixes(t *testing.T) {
+	p := Policy{
+		Groups: []Group{
+			{Name: "lan", Clients: []string{"10.0.0.0/8", "fd00::/8"}},
+			{Name: "kids", Clients: []string{"10.1.0.0/16", "fd00:1::/32"}},
+			{Name: "tablet", Clients: []string{"10.1.2.3"}},
+		},
+	}
+
+	assert.NoError(t, p.Validate())
+}
+
+func TestSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+
+	p, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, Policy{}, p)
+
+	p, err = p.WithList(List{Name: "malware", Path: "malware.txt", Format: "hosts"})
+	assert.NoError(t, err)
+	_, err = p.WithList(List{Name: "malware", Path: "other.txt"})
+	assert.ErrorIs(t, err, ErrExists)
+
+	p, err = p.WithListDisabled("malware", true)
+	assert.NoError(t, err)
+	p = p.WithGroup(Group{Name: "kids", Clients: []string{"192.168.1.20"}, BlockMode: BlockModeAddress})
+	assert.NoError(t, Save(path, p))
+
+	saved, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, p, saved)
+
+	_, err = saved.WithoutGroup("work")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = saved.WithoutList("social")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,138 @@
+//go:build linux
+
+package privileges
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// see capabilities(7) and prctl(2)
+const (
+	linuxCapabilityVersion3 = 0x20080522
+
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	capabilityWords      = 2 // 32-bit words per set, with version 3
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// Drop changes the root (if requested), switches to the user and group, and clears all capabilities, on all threads: neither the
+// privileges nor the capabilities can be regained afterwards, not even by executing another program. Paths opened afterwards must be
+// relative to the new root, if changed.
+//
+// It fails if any step fails, as well as if the process could still regain root afterwards. Clearing capabilities requires a binary
+// built with CGO_ENABLED=0.
+func Drop(o Options) error {
+	uid, gid, err := o.ids()
+	if err != nil {
+		return err
+	}
+
+	if o.Chroot != "" {
+		if err := syscall.Chroot(o.Chroot); err != nil {
+			return fmt.Errorf("unable to change root to %s: %w", o.Chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return fmt.Errorf("unable to change directory to the new root: %w", err)
+		}
+	}
+
+	// the group first, as switching user forfeits the right to do so
+	if gid >= 0 {
+		if os.Getuid() == 0 {
+			if err := syscall.Setgroups([]int{gid}); err != nil {
+				return fmt.Errorf("unable to set supplementary groups: %w", err)
+			}
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("unable to switch to group %d: %w", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("unable to switch to user %d: %w", uid, err)
+		}
+	}
+
+	if err := clearCapabilities(); err != nil {
+		return err
+	}
+
+	return verify(uid, gid)
+}
+
+// clearCapabilities clears the ambient, effective, permitted and inheritable capabilities of all threads, and prevents them from being
+// regained by executing a program.
+func clearCapabilities() error {
+	if err := allThreads(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0); err != nil {
+		return fmt.Errorf("unable to clear ambient capabilities: %w", err)
+	}
+
+	header := capHeader{version: linuxCapabilityVersion3}
+	var data [capabilityWords]capData
+	if err := allThreads(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); err != nil {
+		return fmt.Errorf("unable to clear capabilities: %w", err)
+	}
+
+	if err := allThreads(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); err != nil {
+		return fmt.Errorf("unable to prevent new privileges: %w", err)
+	}
+
+	return nil
+}
+
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno == syscall.ENOTSUP {
+		return errors.New("not supported by binaries built with cgo: build with CGO_ENABLED=0")
+	}
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// verify checks that the process runs as the user and group requested, without capabilities, and that it cannot regain root.
+func verify(uid, gid int) error {
+	if uid >= 0 && (os.Getuid() != uid || os.Geteuid() != uid) {
+		return fmt.Errorf("still running as user %d", os.Geteuid())
+	}
+	if gid >= 0 && (os.Getgid() != gid || os.Getegid() != gid) {
+		return fmt.Errorf("still running as group %d", os.Getegid())
+	}
+
+	header := capHeader{version: linuxCapabilityVersion3}
+	var data [capabilityWords]capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("unable to read capabilities: %w", errno)
+	}
+	for _, d := range data {
+		if d.effective != 0 || d.permitted != 0 {
+			return errors.New("capabilities are still set")
+		}
+	}
+
+	if os.Geteuid() != 0 {
+		if err := syscall.Setuid(0); err == nil {
+			return errors.New("root can be regained")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package privileges
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDrop drops privileges in a child process, as they cannot be regained.
+func TestDrop(t *testing.T) {
+	if dir := os.Getenv("SINKHOLE_TEST_DROP"); dir != "" {
+		if err := Drop(Options{User: "nobody", Chroot: dir}); err != nil {
+			_, _ = os.Stdout.WriteString("error: " + err.Error())
+			os.Exit(1)
+		}
+
+		// the marker is at the root of the new root
+		if _, err := os.Stat("/marker"); err != nil {
+			_, _ = os.Stdout.WriteString("error: " + err.Error())
+			os.Exit(1)
+		}
+
+		_, _ = os.Stdout.WriteString("dropped")
+		os.Exit(0)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(dir, 0o755))
+	require.NoError(t, os.WriteFile(dir+"/marker", nil, 0o644))
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDrop$")
+	cmd.Env = append(os.Environ(), "SINKHOLE_TEST_DROP="+dir)
+	output, _ := cmd.Output()
+
+	if strings.Contains(string(output), "CGO_ENABLED=0") {
+		t.Skip("requires a binary built with CGO_ENABLED=0")
+	}
+	assert.Equal(t, "dropped", string(output))
+}
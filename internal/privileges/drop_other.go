@@ -0,0 +1,10 @@
+//go:build !linux
+
+package privileges
+
+import "errors"
+
+// Drop is only supported on Linux.
+func Drop(o Options) error {
+	return errors.New("dropping privileges is only supported on Linux")
+}
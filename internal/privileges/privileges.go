@@ -0,0 +1,86 @@
+// Package privileges drops the privileges needed to bind the sockets of the sinkhole (root, or CAP_NET_BIND_SERVICE for port 53) once
+// they are bound.
+package privileges
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// Options describes the privileges to drop: nothing is dropped unless at least one field is set.
+type Options struct {
+	User   string // name or numeric ID of the user to switch to
+	Group  string // name or numeric ID of the group to switch to, the primary group of User if empty
+	Chroot string // directory to change the root to, if set
+}
+
+// Enabled reports whether any privilege should be dropped.
+func (o Options) Enabled() bool {
+	return o.User != "" || o.Group != "" || o.Chroot != ""
+}
+
+// ids resolves the user and group to switch to, returning -1 for those to keep. It must be called before changing the root, which
+// usually hides the user database.
+func (o Options) ids() (int, int, error) {
+	uid, gid := -1, -1
+
+	if o.User != "" {
+		u, err := lookupUser(o.User)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("invalid ID of user %s: %w", o.User, err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return 0, 0, fmt.Errorf("invalid primary group of user %s: %w", o.User, err)
+		}
+	}
+
+	if o.Group != "" {
+		g, err := lookupGroup(o.Group)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("invalid ID of group %s: %w", o.Group, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// lookupUser looks a user up by name, or else by ID.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		if u, idErr := user.LookupId(name); idErr == nil {
+			return u, nil
+		}
+	}
+
+	return nil, err
+}
+
+// lookupGroup looks a group up by name, or else by ID.
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if err == nil {
+		return g, nil
+	}
+
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		if g, idErr := user.LookupGroupId(name); idErr == nil {
+			return g, nil
+		}
+	}
+
+	return nil, err
+}
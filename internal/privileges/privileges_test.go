@@ -0,0 +1,36 @@
+package privileges
+
+import (
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions_ids(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+
+	u, g, err := Options{}.ids()
+	require.NoError(t, err)
+	assert.Equal(t, []int{-1, -1}, []int{u, g})
+
+	for _, name := range []string{current.Username, current.Uid} {
+		u, g, err = Options{User: name}.ids()
+		require.NoError(t, err, name)
+		assert.Equal(t, []int{uid, gid}, []int{u, g}, name)
+	}
+
+	u, g, err = Options{Group: current.Gid}.ids()
+	require.NoError(t, err)
+	assert.Equal(t, []int{-1, gid}, []int{u, g})
+
+	_, _, err = Options{User: "no-such-user-sinkhole"}.ids()
+	assert.Error(t, err)
+	_, _, err = Options{User: current.Username, Group: "no-such-group-sinkhole"}.ids()
+	assert.Error(t, err)
+}
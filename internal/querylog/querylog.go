@@ -0,0 +1,321 @@
+package querylog
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/metrics"
+)
+
+const (
+	pendingSize   = 10_000      // queries waiting to be written: further ones are dropped
+	batchSize     = 1_000       // maximum number of queries written per transaction
+	flushInterval = time.Second // how often pending queries are written
+	purgeInterval = time.Hour   // how often queries older than the retention are deleted
+	purgeSize     = 10_000      // maximum number of queries deleted per transaction
+)
+
+var bucket = []byte("queries")
+
+// Entry is a query handled by the DNS server.
+type Entry struct {
+	ID         uint64    `json:"id"` // increasing: queries with a higher ID have been handled later
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Decision   string    `json:"decision"` // one of: forwarded, blocked, dropped, paused, local
+	Group      string    `json:"group,omitempty"`
+	List       string    `json:"list,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	RCode      string    `json:"rcode,omitempty"` // empty for dropped queries
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// Filter selects the entries returned by a search: empty fields match all entries.
+type Filter struct {
+	Client   string
+	Name     string // substring of the name, case-insensitive
+	Type     string
+	Decision string
+	List     string
+	Upstream string
+	RCode    string
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	Before   uint64    // only return entries with a lower ID, to get the next page of a search
+	Limit    int
+}
+
+func (f Filter) matches(e Entry) bool {
+	return (f.Client == "" || e.Client == f.Client) &&
+		(f.Name == "" || strings.Contains(strings.ToLower(e.Name), strings.ToLower(f.Name))) &&
+		(f.Type == "" || strings.EqualFold(e.Type, f.Type)) &&
+		(f.Decision == "" || e.Decision == f.Decision) &&
+		(f.List == "" || e.List == f.List) &&
+		(f.Upstream == "" || e.Upstream == f.Upstream) &&
+		(f.RCode == "" || strings.EqualFold(e.RCode, f.RCode)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+// Page is a page of search results, newest first.
+type Page struct {
+	Entries []Entry `json:"entries"`
+	Next    uint64  `json:"next,omitempty"` // value of Before to get the next page, unless this is the last one
+}
+
+// Log stores the queries handled by the DNS server on disk, deleting them after the retention.
+type Log struct {
+	db        *bolt.DB
+	retention time.Duration
+	now       func() time.Time
+	pending   chan Entry
+	logger    *slog.Logger
+}
+
+// Open opens (or creates) the query log at path.
+func Open(path string, retention time.Duration, now func() time.Time, logger *slog.Logger) (*Log, error) {
+	if retention <= 0 {
+		return nil, fmt.Errorf("invalid retention: %v", retention)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &Log{
+		db:        db,
+		retention: retention,
+		now:       now,
+		pending:   make(chan Entry, pendingSize),
+		logger:    logger.With("source", "query_log"),
+	}, nil
+}
+
+// Record queues a query handled by the DNS server, to be written by Run: if too many queries are already queued, it is dropped.
+func (l *Log) Record(event dns.Event) {
+	entry := Entry{
+		Time:       event.Time,
+		Client:     event.Client.String(),
+		Name:       event.Name,
+		Type:       event.Type.String(),
+		Decision:   event.Decision.String(),
+		Group:      event.Group,
+		List:       event.List,
+		Upstream:   event.Upstream,
+		DurationMs: float64(event.Duration.Microseconds()) / 1000,
+	}
+	if event.Decision != dns.Dropped {
+		entry.RCode = event.RCode.String()
+	}
+
+	select {
+	case l.pending <- entry:
+	default:
+		metrics.QueryLogDropped.Inc()
+	}
+}
+
+// Run writes the queued queries every second, and deletes those older than the retention every hour, until ctx is done.
+func (l *Log) Run(ctx context.Context) error {
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	purge := time.NewTicker(purgeInterval)
+	defer purge.Stop()
+
+	l.purge()
+	for {
+		select {
+		case <-ctx.Done():
+			l.flush()
+			return nil
+		case <-flush.C:
+			l.flush()
+		case <-purge.C:
+			l.purge()
+		}
+	}
+}
+
+// flush writes the queued queries.
+func (l *Log) flush() {
+	for {
+		batch := make([]Entry, 0, batchSize)
+	collect:
+		for len(batch) < batchSize {
+			select {
+			case entry := <-l.pending:
+				batch = append(batch, entry)
+			default:
+				break collect
+			}
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := l.write(batch); err != nil {
+			metrics.QueryLogErrors.Inc()
+			l.logger.Error("Unable to write queries", "count", len(batch), "error", err)
+		}
+	}
+}
+
+func (l *Log) write(entries []Entry) error {
+	return l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for _, entry := range entries {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			entry.ID = id
+
+			value, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(key(id), value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (l *Log) purge() {
+	deleted, err := l.Purge()
+	if err != nil {
+		metrics.QueryLogErrors.Inc()
+		l.logger.Error("Unable to delete expired queries", "error", err)
+		return
+	}
+
+	if deleted > 0 {
+		l.logger.Debug("Deleted expired queries", "count", deleted)
+	}
+}
+
+// Purge deletes the queries older than the retention, returning how many have been deleted.
+func (l *Log) Purge() (int, error) {
+	cutoff := l.now().Add(-l.retention)
+
+	var total int
+	for {
+		var deleted int
+		err := l.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(bucket)
+
+			// keys are collected first, as deleting while iterating may skip some of them
+			var expired [][]byte
+			c := b.Cursor()
+			for k, v := c.First(); k != nil && len(expired) < purgeSize; k, v = c.Next() {
+				var entry Entry
+				if err := json.Unmarshal(v, &entry); err != nil {
+					return fmt.Errorf("entry %d: %w", binary.BigEndian.Uint64(k), err)
+				}
+				// entries are sorted by ID and thus, roughly, by time
+				if !entry.Time.Before(cutoff) {
+					break
+				}
+				expired = append(expired, k)
+			}
+
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+
+			deleted = len(expired)
+			return nil
+		})
+		total += deleted
+
+		if err != nil || deleted < purgeSize {
+			return total, err
+		}
+	}
+}
+
+// Search returns the entries selected by the filter, newest first, along with the value of Before to get the next page, if any.
+func (l *Log) Search(filter Filter) (Page, error) {
+	page := Page{Entries: []Entry{}}
+	if filter.Limit <= 0 {
+		return page, nil
+	}
+
+	err := l.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+
+		k, v := c.Last()
+		if filter.Before > 0 {
+			// Seek returns the first key not lower than Before, if any: the previous one is the first to consider
+			if k, _ = c.Seek(key(filter.Before)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("entry %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+
+			if !filter.From.IsZero() && entry.Time.Before(filter.From) {
+				break
+			}
+
+			if !filter.matches(entry) {
+				continue
+			}
+
+			if len(page.Entries) == filter.Limit {
+				// there is at least another matching entry
+				page.Next = page.Entries[len(page.Entries)-1].ID
+				break
+			}
+			page.Entries = append(page.Entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Page{}, err
+	}
+
+	return page, nil
+}
+
+// Close closes the query log: queued queries that Run has not written yet are lost.
+func (l *Log) Close() error {
+	return l.db.Close()
+}
+
+// key returns the key of the entry with the provided ID, which sorts keys by ID.
+func key(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
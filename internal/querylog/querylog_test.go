@@ -0,0 +1,127 @@
+package querylog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+)
+
+func open(t *testing.T, now func() time.Time) *Log {
+	l, err := Open(filepath.Join(t.TempDir(), "queries.db"), 24*time.Hour, now, slog.Default())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	return l
+}
+
+func TestLog_Search(t *testing.T) {
+	start := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+	sut := open(t, time.Now)
+
+	for i := range 10 {
+		event := dns.Event{
+			Time:     start.Add(time.Duration(i) * time.Minute),
+			Client:   netip.MustParseAddr(fmt.Sprintf("192.168.1.%d", 10+i%2)),
+			Name:     fmt.Sprintf("host%d.example.com", i),
+			Type:     message.TypeA,
+			Upstream: "9.9.9.9:53",
+		}
+		if i%3 == 0 {
+			event.Decision, event.List, event.Upstream, event.RCode = dns.Blocked, "ads", "", message.RCodeNameError
+		}
+		sut.Record(event)
+	}
+	sut.Record(dns.Event{Time: start.Add(time.Hour), Client: netip.MustParseAddr("192.168.1.10"), Name: "Tracker.yyy", Type: message.TypeAAAA, Decision: dns.Dropped})
+	sut.flush()
+
+	page, err := sut.Search(Filter{Limit: 3})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 3)
+	assert.Equal(t, Entry{
+		ID:       11,
+		Time:     start.Add(time.Hour),
+		Client:   "192.168.1.10",
+		Name:     "Tracker.yyy",
+		Type:     "AAAA",
+		Decision: "dropped",
+	}, page.Entries[0])
+	assert.Equal(t, uint64(9), page.Next)
+
+	page, err = sut.Search(Filter{Before: page.Next, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{8, 7, 6}, ids(page))
+
+	page, err = sut.Search(Filter{Decision: "blocked", Client: "192.168.1.10", Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{7, 1}, ids(page))
+	assert.Zero(t, page.Next)
+	assert.Equal(t, "NXDOMAIN", page.Entries[0].RCode)
+	assert.Equal(t, "ads", page.Entries[0].List)
+
+	page, err = sut.Search(Filter{Name: "TRACKER", Type: "aaaa", Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{11}, ids(page))
+
+	page, err = sut.Search(Filter{Upstream: "9.9.9.9:53", RCode: "noerror", From: start.Add(2 * time.Minute), To: start.Add(5 * time.Minute), Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{5, 3}, ids(page))
+}
+
+func TestLog_Purge(t *testing.T) {
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+	sut := open(t, func() time.Time { return now })
+
+	for _, age := range []time.Duration{48 * time.Hour, 25 * time.Hour, time.Hour, 0} {
+		sut.Record(dns.Event{Time: now.Add(-age), Client: netip.MustParseAddr("192.168.1.10"), Name: "example.com", Type: message.TypeA})
+	}
+	sut.flush()
+
+	deleted, err := sut.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	page, err := sut.Search(Filter{Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4, 3}, ids(page))
+}
+
+func TestLog_RunWritesQueuedQueriesOnShutdown(t *testing.T) {
+	sut := open(t, time.Now)
+	sut.Record(dns.Event{Time: time.Now(), Client: netip.MustParseAddr("192.168.1.10"), Name: "example.com", Type: message.TypeA})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, sut.Run(ctx))
+
+	page, err := sut.Search(Filter{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, page.Entries, 1)
+}
+
+func TestLog_RecordDropsQueriesWhenFull(t *testing.T) {
+	sut := open(t, time.Now)
+	for range pendingSize + 1 {
+		sut.Record(dns.Event{Time: time.Now(), Client: netip.MustParseAddr("192.168.1.10"), Name: "example.com", Type: message.TypeA})
+	}
+
+	assert.Len(t, sut.pending, pendingSize)
+}
+
+func ids(page Page) []uint64 {
+	var ids []uint64
+	for _, e := range page.Entries {
+		ids = append(ids, e.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,324 @@
+package records
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+const DefaultTTL = 300
+
+var (
+	ErrUnsupportedType = errors.New("unsupported record type")
+	ErrNotFound        = errors.New("record not found")
+	ErrSave            = errors.New("unable to save records")
+)
+
+// Record is a DNS record of the local network.
+type Record struct {
+	Name  string `json:"name" yaml:"name"`
+	Type  string `json:"type" yaml:"type"` // one of: A, AAAA, CNAME, TXT, PTR
+	Value string `json:"value" yaml:"value"`
+	TTL   uint32 `json:"ttl" yaml:"ttl"`
+}
+
+// Store holds the local records, along with the PTR records automatically generated for their A and AAAA records.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	records []Record
+	byName  map[string][]Record // includes generated PTR records
+}
+
+// New returns a store holding the provided records, e.g. those of the configuration file, after validating them: changes cannot be saved.
+func New(records []Record) (*Store, error) {
+	s := &Store{byName: make(map[string][]Record)}
+	for i, record := range records {
+		record, err := Validate(record)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		s.records = append(s.records, record)
+	}
+	s.index()
+
+	return s, nil
+}
+
+// Load reads the records from the file at path (if it exists), which is also where any change will be saved to.
+//
+// Each line of the file contains a record, in the format `<name> [ttl] <type> <value>`. Blank lines and lines starting with `#` are ignored.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, byName: make(map[string][]Record)}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	s.records = records
+	s.index()
+
+	return s, nil
+}
+
+// Parse reads records from r.
+func Parse(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		record := Record{TTL: DefaultTTL}
+		record.Name, line = cutField(line)
+		record.Type, line = cutField(line)
+		if ttl, err := strconv.ParseUint(record.Type, 10, 32); err == nil {
+			record.TTL = uint32(ttl)
+			record.Type, line = cutField(line)
+		}
+		record.Value = line
+
+		if record.Type == "" || record.Value == "" {
+			return nil, fmt.Errorf("line %d: expected <name> [ttl] <type> <value>", n)
+		}
+
+		if strings.EqualFold(record.Type, "TXT") && strings.HasPrefix(record.Value, `"`) {
+			value, err := strconv.Unquote(record.Value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid TXT value: %w", n, err)
+			}
+			record.Value = value
+		}
+
+		normalized, err := Validate(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		records = append(records, normalized)
+	}
+
+	return records, scanner.Err()
+}
+
+// cutField returns the first whitespace-separated field of s, and the rest of s (without leading whitespace).
+func cutField(s string) (string, string) {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+
+	return s[:i], strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+}
+
+// Validate checks that the record is well-formed, returning its normalised version.
+func Validate(record Record) (Record, error) {
+	name, err := hosts.Normalize(record.Name)
+	if err != nil || strings.HasPrefix(name, "*.") {
+		return record, fmt.Errorf("invalid name %q: %v", record.Name, err)
+	}
+	record.Name = name
+	record.Type = strings.ToUpper(record.Type)
+
+	if record.TTL == 0 {
+		record.TTL = DefaultTTL
+	}
+
+	switch record.Type {
+	case "A", "AAAA":
+		addr, err := netip.ParseAddr(record.Value)
+		if err != nil || (record.Type == "A") != addr.Is4() {
+			return record, fmt.Errorf("invalid %s address: %q", record.Type, record.Value)
+		}
+		record.Value = addr.String()
+	case "CNAME", "PTR":
+		target, err := hosts.Normalize(record.Value)
+		if err != nil {
+			return record, fmt.Errorf("invalid %s target %q: %v", record.Type, record.Value, err)
+		}
+		record.Value = target
+	case "TXT":
+	default:
+		return record, fmt.Errorf("%w: %q", ErrUnsupportedType, record.Type)
+	}
+
+	return record, nil
+}
+
+// Lookup returns the records (including generated PTR records) of name.
+func (s *Store) Lookup(name string) []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.byName[name]
+}
+
+// All returns all records, except the generated ones.
+func (s *Store) All() []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.records)
+}
+
+// Add validates the record and adds it to the store, saving the change.
+func (s *Store) Add(record Record) (Record, error) {
+	record, err := Validate(record)
+	if err != nil {
+		return record, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.Contains(s.records, record) {
+		return record, nil
+	}
+
+	return record, s.update(append(slices.Clone(s.records), record))
+}
+
+// Remove removes the records of name with the provided type (and value, if not empty) from the store, saving the change.
+func (s *Store) Remove(name, type_, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type_ = strings.ToUpper(type_)
+	records := slices.DeleteFunc(slices.Clone(s.records), func(r Record) bool {
+		return r.Name == name && r.Type == type_ && (value == "" || r.Value == value)
+	})
+
+	if len(records) == len(s.records) {
+		return ErrNotFound
+	}
+
+	return s.update(records)
+}
+
+// update saves the records and, if successful, replaces the current ones with them.
+func (s *Store) update(records []Record) error {
+	if s.path == "" {
+		return fmt.Errorf("%w: no records path configured", ErrSave)
+	}
+
+	if err := save(s.path, records); err != nil {
+		return fmt.Errorf("%w: %v", ErrSave, err)
+	}
+
+	s.records = records
+	s.index()
+
+	return nil
+}
+
+// index rebuilds the index of records by name, generating PTR records for A and AAAA records (unless an explicit one exists).
+func (s *Store) index() {
+	byName := make(map[string][]Record)
+	for _, r := range s.records {
+		byName[r.Name] = append(byName[r.Name], r)
+	}
+
+	for _, r := range s.records {
+		if r.Type != "A" && r.Type != "AAAA" {
+			continue
+		}
+
+		reverse := ReverseName(netip.MustParseAddr(r.Value))
+		if slices.ContainsFunc(byName[reverse], func(other Record) bool { return other.Type == "PTR" && other.Value == r.Name }) {
+			continue
+		}
+
+		byName[reverse] = append(byName[reverse], Record{Name: reverse, Type: "PTR", Value: r.Name, TTL: r.TTL})
+	}
+
+	s.byName = byName
+}
+
+// ReverseName returns the name used for reverse lookups of addr (e.g. "10.1.168.192.in-addr.arpa" for 192.168.1.10).
+func ReverseName(addr netip.Addr) string {
+	var b strings.Builder
+	if addr.Is4() {
+		ip := addr.As4()
+		for i := len(ip) - 1; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(ip[i])))
+			b.WriteByte('.')
+		}
+		b.WriteString("in-addr.arpa")
+		return b.String()
+	}
+
+	const digits = "0123456789abcdef"
+	ip := addr.As16()
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(digits[ip[i]&0x0F])
+		b.WriteByte('.')
+		b.WriteByte(digits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+// save atomically writes the records to the file at path.
+func save(path string, records []Record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	for _, r := range records {
+		value := r.Value
+		if r.Type == "TXT" {
+			value = strconv.Quote(value)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s %d %s %s\n", r.Name, r.TTL, r.Type, value); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
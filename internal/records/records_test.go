@@ -0,0 +1,97 @@
+package records
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	input := `
+# local records
+NAS.home A 192.168.1.10
+nas.home 60 AAAA fd00::10
+files.home CNAME nas.home.
+nas.home TXT "backups  at 2am"
+`
+	records, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Name: "nas.home", Type: "A", Value: "192.168.1.10", TTL: DefaultTTL},
+		{Name: "nas.home", Type: "AAAA", Value: "fd00::10", TTL: 60},
+		{Name: "files.home", Type: "CNAME", Value: "nas.home", TTL: DefaultTTL},
+		{Name: "nas.home", Type: "TXT", Value: "backups  at 2am", TTL: DefaultTTL},
+	}, records)
+}
+
+func TestParse_FailsOnInvalidRecords(t *testing.T) {
+	for _, input := range []string{
+		"nas.home A",
+		"nas.home A fd00::10",
+		"nas.home MX mail.home",
+		"-nas.home A 192.168.1.10",
+	} {
+		_, err := Parse(strings.NewReader(input))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestStore_GeneratesPTRRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records")
+	store, err := Load(path)
+	require.NoError(t, err)
+
+	_, err = store.Add(Record{Name: "nas.home", Type: "A", Value: "192.168.1.10"})
+	require.NoError(t, err)
+	_, err = store.Add(Record{Name: "nas.home", Type: "AAAA", Value: "fd00::10"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Record{{Name: "10.1.168.192.in-addr.arpa", Type: "PTR", Value: "nas.home", TTL: DefaultTTL}},
+		store.Lookup("10.1.168.192.in-addr.arpa"))
+
+	reverse := ReverseName(netip.MustParseAddr("fd00::10"))
+	assert.Equal(t, "0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", reverse)
+	assert.Len(t, store.Lookup(reverse), 1)
+
+	// changes are saved, and generated records are not
+	reloaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, store.All(), reloaded.All())
+	assert.Len(t, reloaded.All(), 2)
+
+	require.NoError(t, store.Remove("nas.home", "a", ""))
+	assert.Empty(t, store.Lookup("10.1.168.192.in-addr.arpa"))
+	assert.ErrorIs(t, store.Remove("nas.home", "A", ""), ErrNotFound)
+}
+
+func TestStore_KeepsRecords_WhenSavingFails(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Load(filepath.Join(dir, "missing", "records"))
+	require.NoError(t, err)
+
+	_, err = store.Add(Record{Name: "nas.home", Type: "A", Value: "192.168.1.10"})
+	assert.ErrorIs(t, err, ErrSave)
+	assert.Empty(t, store.All())
+
+	_, err = os.Stat(filepath.Join(dir, "missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNew(t *testing.T) {
+	store, err := New([]Record{{Name: "NAS.home.", Type: "a", Value: "192.168.1.10"}})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{{Name: "nas.home", Type: "A", Value: "192.168.1.10", TTL: DefaultTTL}}, store.Lookup("nas.home"))
+	assert.Len(t, store.Lookup("10.1.168.192.in-addr.arpa"), 1)
+
+	// without a file, changes cannot be saved
+	_, err = store.Add(Record{Name: "printer.home", Type: "A", Value: "192.168.1.11"})
+	assert.ErrorIs(t, err, ErrSave)
+
+	_, err = New([]Record{{Name: "nas.home", Type: "MX", Value: "mail.home"}})
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+}
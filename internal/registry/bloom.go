@@ -0,0 +1,50 @@
+package registry
+
+const (
+	bloomBitsPerKey = 10 // ~1% false positives, using bloomHashes hash functions
+	bloomHashes     = 7
+)
+
+// bloom is a Bloom filter, which tells with certainty when a key is missing from a set.
+type bloom struct {
+	bits []uint64
+}
+
+func newBloom(keys int) *bloom {
+	words := (max(keys, 1)*bloomBitsPerKey + 63) / 64
+	return &bloom{bits: make([]uint64, words)}
+}
+
+func (b *bloom) add(key []byte) {
+	h1, h2 := hash(key)
+	size := uint32(len(b.bits) * 64)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain returns false if key is certainly not in the set.
+func (b *bloom) mayContain(key []byte) bool {
+	h1, h2 := hash(key)
+	size := uint32(len(b.bits) * 64)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hash returns two independent hashes of key (the two halves of its 64-bit FNV-1a hash), to be combined by double hashing.
+func hash(key []byte) (uint32, uint32) {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+
+	return uint32(h), uint32(h>>32) | 1
+}
@@ -0,0 +1,306 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+const (
+	blockSize    = 16   // number of entries per front-coded block
+	minPending   = 4096 // minimum number of pending entries before they are merged into the sorted ones
+	maxKeyLength = 256  // maximum length of a domain name, plus some leeway for wildcards
+)
+
+// Compact is a memory-efficient registry, meant for devices with little RAM.
+//
+// Domains are stored with their labels reversed (e.g. "com.example.www"), so that domains sharing a parent also share a prefix, and sorted.
+// Sorted names are then grouped in blocks, within which each name is front-coded: only the length of the prefix it shares with the
+// previous name and the rest of its bytes are stored. Lookups binary search the first name of each block, then scan a single block.
+//
+// New domains are first added to a small map, which is merged into the sorted blocks once it grows past a fraction of their size.
+// If enabled, a Bloom filter over the sorted names spares most lookups of missing domains (i.e. the vast majority) from scanning blocks.
+type Compact struct {
+	data   []byte   // front-coded blocks
+	blocks []uint32 // offset of each block in data
+	count  int      // number of entries in data
+	bloom  *bloom
+
+	wildcards int
+	pending   map[string]hosts.Rule // entries not merged into data yet, keyed by reversed name
+
+	bloomEnabled bool
+}
+
+func NewCompact(bloomEnabled bool) *Compact {
+	return &Compact{
+		pending:      make(map[string]hosts.Rule),
+		bloomEnabled: bloomEnabled,
+	}
+}
+
+// Add registers a rule for domain, reporting whether the domain was not registered yet.
+func (c *Compact) Add(domain string, rule hosts.Rule) bool {
+	var buf [maxKeyLength]byte
+	key := reverse(buf[:0], domain)
+
+	_, exists := c.pending[string(key)]
+	if !exists {
+		_, exists = c.lookup(key)
+	}
+	if !exists && isWildcard(domain) {
+		c.wildcards++
+	}
+	c.pending[string(key)] = rule
+
+	if len(c.pending) >= max(minPending, c.count/4) {
+		c.merge()
+	}
+
+	return !exists
+}
+
+// Get returns the rule registered for domain, if any.
+func (c *Compact) Get(domain string) (hosts.Rule, bool) {
+	var buf [maxKeyLength]byte
+	key := reverse(buf[:0], domain)
+
+	if rule, ok := c.pending[string(key)]; ok {
+		return rule, true
+	}
+
+	return c.lookup(key)
+}
+
+// Len returns the number of registered domains.
+func (c *Compact) Len() int {
+	n := c.count
+	for key := range c.pending {
+		if _, ok := c.lookup([]byte(key)); !ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Wildcards returns the number of registered wildcard domains (i.e. starting with "*.").
+func (c *Compact) Wildcards() int {
+	return c.wildcards
+}
+
+// Range calls fn for each registered domain and its rule until fn returns false: sorted domains come first, in the order of their reversed
+// labels, followed by the pending ones.
+func (c *Compact) Range(fn func(domain string, rule hosts.Rule) bool) {
+	var buf [maxKeyLength]byte
+	cur := cursor{data: c.data}
+	var key []byte
+	for cur.offset < len(c.data) {
+		var rule hosts.Rule
+		key, rule = cur.next(key)
+
+		// pending entries take precedence over sorted ones
+		if _, ok := c.pending[string(key)]; ok {
+			continue
+		}
+
+		if !fn(string(reverse(buf[:0], string(key))), rule) {
+			return
+		}
+	}
+
+	for key, rule := range c.pending {
+		if !fn(string(reverse(buf[:0], key)), rule) {
+			return
+		}
+	}
+}
+
+// Compact merges any pending entries into the sorted ones: it should be called once all domains have been added.
+func (c *Compact) Compact() {
+	if len(c.pending) > 0 {
+		c.merge()
+	}
+}
+
+// lookup searches key among the sorted entries.
+func (c *Compact) lookup(key []byte) (hosts.Rule, bool) {
+	if len(c.blocks) == 0 {
+		return hosts.Rule{}, false
+	}
+
+	if c.bloom != nil && !c.bloom.mayContain(key) {
+		return hosts.Rule{}, false
+	}
+
+	// find the last block whose first key is not greater than key
+	i := sort.Search(len(c.blocks), func(i int) bool {
+		return bytes.Compare(c.firstKey(i), key) > 0
+	}) - 1
+	if i < 0 {
+		return hosts.Rule{}, false
+	}
+
+	var buf [maxKeyLength]byte
+	current := buf[:0]
+	cur := cursor{data: c.data, offset: int(c.blocks[i])}
+	for n := 0; n < blockSize && cur.offset < len(c.data); n++ {
+		var rule hosts.Rule
+		current, rule = cur.next(current)
+		switch bytes.Compare(current, key) {
+		case 0:
+			return rule, true
+		case 1:
+			return hosts.Rule{}, false
+		}
+	}
+
+	return hosts.Rule{}, false
+}
+
+// firstKey returns the (fully stored) first key of the i-th block.
+func (c *Compact) firstKey(i int) []byte {
+	offset := int(c.blocks[i])
+	_, n := binary.Uvarint(c.data[offset:]) // shared prefix length: always 0
+	offset += n
+	length, n := binary.Uvarint(c.data[offset:])
+	offset += n
+
+	return c.data[offset : offset+int(length)]
+}
+
+// merge rewrites the sorted entries, including the pending ones.
+func (c *Compact) merge() {
+	keys := make([]string, 0, len(c.pending))
+	for key := range c.pending {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	enc := encoder{data: make([]byte, 0, len(c.data)+len(keys)*16)}
+	var b *bloom
+	if c.bloomEnabled {
+		b = newBloom(c.count + len(keys))
+	}
+
+	add := func(key []byte, rule hosts.Rule) {
+		enc.add(key, rule)
+		if b != nil {
+			b.add(key)
+		}
+	}
+
+	cur := cursor{data: c.data}
+	var current []byte
+	var rule hosts.Rule
+	var ok bool
+	next := func() {
+		ok = cur.offset < len(cur.data)
+		if ok {
+			current, rule = cur.next(current)
+		}
+	}
+
+	next()
+	for _, key := range keys {
+		for ok && bytes.Compare(current, []byte(key)) < 0 {
+			add(current, rule)
+			next()
+		}
+
+		// pending entries take precedence over sorted ones
+		if ok && bytes.Equal(current, []byte(key)) {
+			next()
+		}
+		add([]byte(key), c.pending[key])
+	}
+
+	for ok {
+		add(current, rule)
+		next()
+	}
+
+	c.data = slices.Clip(enc.data)
+	c.blocks = slices.Clip(enc.blocks)
+	c.count = enc.count
+	c.bloom = b
+	c.pending = make(map[string]hosts.Rule)
+}
+
+// encoder appends front-coded entries to data: each block starts with a fully stored key.
+type encoder struct {
+	data   []byte
+	blocks []uint32
+	prev   []byte
+	count  int
+}
+
+func (e *encoder) add(key []byte, rule hosts.Rule) {
+	var shared int
+	if e.count%blockSize == 0 {
+		e.blocks = append(e.blocks, uint32(len(e.data)))
+	} else {
+		for shared < len(key) && shared < len(e.prev) && key[shared] == e.prev[shared] {
+			shared++
+		}
+	}
+
+	e.data = binary.AppendUvarint(e.data, uint64(shared))
+	e.data = binary.AppendUvarint(e.data, uint64(len(key)-shared))
+	e.data = append(e.data, key[shared:]...)
+	e.data = append(e.data, byte(rule.Action))
+	if rule.Action == hosts.Rewrite {
+		e.data = binary.AppendUvarint(e.data, uint64(len(rule.Target)))
+		e.data = append(e.data, rule.Target...)
+	}
+
+	e.prev = append(e.prev[:0], key...)
+	e.count++
+}
+
+// cursor decodes front-coded entries, starting at offset (which must be the beginning of a block).
+type cursor struct {
+	data   []byte
+	offset int
+}
+
+// next decodes the entry at offset, returning its key (rebuilt on top of the previous one, prev) and its rule.
+func (c *cursor) next(prev []byte) ([]byte, hosts.Rule) {
+	shared, n := binary.Uvarint(c.data[c.offset:])
+	c.offset += n
+	length, n := binary.Uvarint(c.data[c.offset:])
+	c.offset += n
+
+	key := append(prev[:shared], c.data[c.offset:c.offset+int(length)]...)
+	c.offset += int(length)
+
+	rule := hosts.Rule{Action: hosts.Action(c.data[c.offset])}
+	c.offset++
+	if rule.Action == hosts.Rewrite {
+		length, n := binary.Uvarint(c.data[c.offset:])
+		c.offset += n
+		rule.Target = string(c.data[c.offset : c.offset+int(length)])
+		c.offset += int(length)
+	}
+
+	return key, rule
+}
+
+// reverse appends the labels of domain to dst in reverse order (e.g. "www.example.com" becomes "com.example.www").
+func reverse(dst []byte, domain string) []byte {
+	for end := len(domain); end >= 0; {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		dst = append(dst, domain[start:end]...)
+		if start == 0 {
+			break
+		}
+		dst = append(dst, '.')
+		end = start - 1
+	}
+
+	return dst
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package registry
+
+import (
+	"os"
+)
+
+// mmap reads the whole file at path in memory, since memory-mapping is not supported on this platform.
+func mmap(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return nil }, nil
+}
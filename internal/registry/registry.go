@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"strings"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+// Map is a registry backed by a map: fast, but it needs several dozen bytes per domain.
+type Map struct {
+	entries   map[string]hosts.Rule
+	wildcards int
+}
+
+func NewMap() *Map {
+	return &Map{entries: make(map[string]hosts.Rule)}
+}
+
+// Add registers a rule for domain, reporting whether the domain was not registered yet.
+func (m *Map) Add(domain string, rule hosts.Rule) bool {
+	_, exists := m.entries[domain]
+	if !exists && isWildcard(domain) {
+		m.wildcards++
+	}
+	m.entries[domain] = rule
+
+	return !exists
+}
+
+// Get returns the rule registered for domain, if any.
+func (m *Map) Get(domain string) (hosts.Rule, bool) {
+	rule, ok := m.entries[domain]
+	return rule, ok
+}
+
+// Len returns the number of registered domains.
+func (m *Map) Len() int {
+	return len(m.entries)
+}
+
+// Wildcards returns the number of registered wildcard domains (i.e. starting with "*.").
+func (m *Map) Wildcards() int {
+	return m.wildcards
+}
+
+// Range calls fn for each registered domain and its rule, in no particular order, until fn returns false.
+func (m *Map) Range(fn func(domain string, rule hosts.Rule) bool) {
+	for domain, rule := range m.entries {
+		if !fn(domain, rule) {
+			return
+		}
+	}
+}
+
+func isWildcard(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+type registry interface {
+	Add(domain string, rule hosts.Rule) bool
+	Get(domain string) (hosts.Rule, bool)
+	Len() int
+	Range(fn func(domain string, rule hosts.Rule) bool)
+}
+
+var implementations = map[string]func() registry{
+	"map":           func() registry { return NewMap() },
+	"compact":       func() registry { return NewCompact(false) },
+	"compact+bloom": func() registry { return NewCompact(true) },
+}
+
+func domain(i int) string {
+	return fmt.Sprintf("host-%d.tracker-%d.example.com", i, i%1000)
+}
+
+func TestRegistry_AddAndGet(t *testing.T) {
+	for name, newRegistry := range implementations {
+		t.Run(name, func(t *testing.T) {
+			sut := newRegistry()
+			n := 3 * minPending
+
+			for i := 0; i < n; i++ {
+				assert.True(t, sut.Add(domain(i), hosts.Rule{Action: hosts.Block}))
+			}
+			assert.True(t, sut.Add("rewrite.example.com", hosts.Rule{Action: hosts.Rewrite, Target: "safe.example.com"}))
+			assert.False(t, sut.Add(domain(0), hosts.Rule{Action: hosts.NXDomain}))
+
+			if c, ok := sut.(*Compact); ok {
+				c.Compact()
+			}
+
+			assert.Equal(t, n+1, sut.Len())
+
+			for i := 1; i < n; i++ {
+				rule, ok := sut.Get(domain(i))
+				assert.True(t, ok, domain(i))
+				assert.Equal(t, hosts.Block, rule.Action)
+			}
+
+			rule, ok := sut.Get(domain(0))
+			assert.True(t, ok)
+			assert.Equal(t, hosts.NXDomain, rule.Action)
+
+			rule, ok = sut.Get("rewrite.example.com")
+			assert.True(t, ok)
+			assert.Equal(t, hosts.Rule{Action: hosts.Rewrite, Target: "safe.example.com"}, rule)
+
+			_, ok = sut.Get("example.com")
+			assert.False(t, ok)
+			_, ok = sut.Get("zzz.example.com")
+			assert.False(t, ok)
+			_, ok = sut.Get(domain(n))
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestRegistry_Range(t *testing.T) {
+	for name, newRegistry := range implementations {
+		t.Run(name, func(t *testing.T) {
+			sut := newRegistry()
+			n := minPending + 10 // some sorted, some pending
+
+			for i := 0; i < n; i++ {
+				sut.Add(domain(i), hosts.Rule{Action: hosts.Block})
+			}
+			sut.Add(domain(0), hosts.Rule{Action: hosts.NXDomain})
+
+			seen := make(map[string]hosts.Rule)
+			sut.Range(func(domain string, rule hosts.Rule) bool {
+				_, duplicate := seen[domain]
+				assert.False(t, duplicate, domain)
+				seen[domain] = rule
+				return true
+			})
+
+			assert.Len(t, seen, n)
+			assert.Equal(t, hosts.NXDomain, seen[domain(0)].Action)
+			assert.Equal(t, hosts.Block, seen[domain(n-1)].Action)
+
+			var count int
+			sut.Range(func(string, hosts.Rule) bool {
+				count++
+				return count < 3
+			})
+			assert.Equal(t, 3, count)
+		})
+	}
+}
+
+func TestReverse(t *testing.T) {
+	assert.Equal(t, "com.example.www", string(reverse(nil, "www.example.com")))
+	assert.Equal(t, "com.example.*", string(reverse(nil, "*.example.com")))
+	assert.Equal(t, "localhost", string(reverse(nil, "localhost")))
+}
+
+const benchmarkSize = 200_000
+
+func build(newRegistry func() registry) registry {
+	r := newRegistry()
+	for i := 0; i < benchmarkSize; i++ {
+		r.Add(domain(i), hosts.Rule{Action: hosts.Block})
+	}
+	if c, ok := r.(*Compact); ok {
+		c.Compact()
+	}
+
+	return r
+}
+
+func heapInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse
+}
+
+// BenchmarkRegistry_Memory reports the heap used by each registry, per domain.
+func BenchmarkRegistry_Memory(b *testing.B) {
+	for name, newRegistry := range implementations {
+		b.Run(name, func(b *testing.B) {
+			var perDomain float64
+			for i := 0; i < b.N; i++ {
+				before := heapInUse()
+				r := build(newRegistry)
+				perDomain = float64(heapInUse()-before) / benchmarkSize
+				runtime.KeepAlive(r)
+			}
+			b.ReportMetric(perDomain, "bytes/domain")
+		})
+	}
+}
+
+func BenchmarkRegistry_GetHit(b *testing.B) {
+	benchmarkGet(b, 0)
+}
+
+func BenchmarkRegistry_GetMiss(b *testing.B) {
+	benchmarkGet(b, benchmarkSize)
+}
+
+func benchmarkGet(b *testing.B, offset int) {
+	names := make([]string, 1024)
+	for i := range names {
+		names[i] = domain(offset + i*(benchmarkSize/len(names)))
+	}
+
+	for name, newRegistry := range implementations {
+		b.Run(name, func(b *testing.B) {
+			r := build(newRegistry)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.Get(names[i%len(names)])
+			}
+		})
+	}
+}
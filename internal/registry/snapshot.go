@@ -0,0 +1,255 @@
+package registry
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+// Snapshot format: all integers are little endian.
+//
+//	magic    [4]byte "SNKH"
+//	version  uint32
+//	sections uint32
+//	for each section:
+//	  name      uint16 length + bytes
+//	  source    uint16 length + bytes
+//	  count     uint64
+//	  wildcards uint64
+//	  data      uint64 length + bytes
+//	  blocks    uint64 length + uint32 offsets
+//	  bloom     uint64 length + uint64 words (0 if the Bloom filter is disabled)
+//	checksum uint32 (CRC-32C of all the preceding bytes)
+const (
+	snapshotMagic   = "SNKH"
+	SnapshotVersion = 1
+)
+
+var (
+	ErrInvalidSnapshot  = errors.New("invalid snapshot")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+	le         = binary.LittleEndian
+)
+
+// Section is a registry stored in a snapshot, along with the description of the source it was compiled from.
+type Section struct {
+	Name     string
+	Source   string
+	Registry *Compact
+}
+
+// WriteSnapshot writes the sections to w, compacting their registries first.
+func WriteSnapshot(w io.Writer, sections []Section) error {
+	h := crc32.New(castagnoli)
+	bw := bufio.NewWriter(io.MultiWriter(w, h))
+
+	var buf []byte
+	buf = append(buf, snapshotMagic...)
+	buf = le.AppendUint32(buf, SnapshotVersion)
+	buf = le.AppendUint32(buf, uint32(len(sections)))
+
+	for _, section := range sections {
+		if len(section.Name) > math.MaxUint16 || len(section.Source) > math.MaxUint16 {
+			return fmt.Errorf("section name or source too long: %q", section.Name)
+		}
+
+		c := section.Registry
+		c.Compact()
+
+		buf = appendString(buf, section.Name)
+		buf = appendString(buf, section.Source)
+		buf = le.AppendUint64(buf, uint64(c.count))
+		buf = le.AppendUint64(buf, uint64(c.wildcards))
+		buf = le.AppendUint64(buf, uint64(len(c.data)))
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		if _, err := bw.Write(c.data); err != nil {
+			return err
+		}
+
+		buf = le.AppendUint64(buf[:0], uint64(len(c.blocks)))
+		for _, offset := range c.blocks {
+			buf = le.AppendUint32(buf, offset)
+		}
+
+		var words []uint64
+		if c.bloom != nil {
+			words = c.bloom.bits
+		}
+		buf = le.AppendUint64(buf, uint64(len(words)))
+		for _, word := range words {
+			buf = le.AppendUint64(buf, word)
+		}
+
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		buf = buf[:0]
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(le.AppendUint32(nil, h.Sum32()))
+	return err
+}
+
+// ReadSnapshot verifies and decodes the sections of a snapshot. Their registries reference data, which must therefore never be modified.
+func ReadSnapshot(data []byte) ([]Section, error) {
+	if len(data) < len(snapshotMagic)+12 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+
+	body, checksum := data[:len(data)-4], le.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, castagnoli) != checksum {
+		return nil, ErrSnapshotChecksum
+	}
+
+	r := reader{data: body, offset: len(snapshotMagic)}
+	if version := r.uint32(); version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+
+	var sections []Section
+	for n := r.uint32(); uint32(len(sections)) < n && r.err == nil; {
+		name := r.string()
+		source := r.string()
+		c := &Compact{
+			count:     int(r.uint64()),
+			wildcards: int(r.uint64()),
+			pending:   make(map[string]hosts.Rule),
+		}
+		c.data = r.bytes(int(r.uint64()))
+
+		c.blocks = make([]uint32, r.length(4))
+		for j := range c.blocks {
+			c.blocks[j] = r.uint32()
+		}
+
+		if words := r.length(8); words > 0 {
+			c.bloom = &bloom{bits: make([]uint64, words)}
+			for j := range c.bloom.bits {
+				c.bloom.bits[j] = r.uint64()
+			}
+			c.bloomEnabled = true
+		}
+
+		if r.err != nil {
+			return nil, r.err
+		}
+		sections = append(sections, Section{Name: name, Source: source, Registry: c})
+	}
+
+	if r.err == nil && r.offset != len(body) {
+		return nil, ErrInvalidSnapshot
+	}
+
+	return sections, r.err
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = le.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// reader decodes a snapshot, recording the first out-of-bounds read (after which it only returns zero values).
+type reader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.offset {
+		r.err = ErrInvalidSnapshot
+		return nil
+	}
+
+	b := r.data[r.offset : r.offset+n : r.offset+n]
+	r.offset += n
+	return b
+}
+
+// length reads the number of items of the provided size that follow, making sure that they fit in the remaining data.
+func (r *reader) length(size int) int {
+	n := r.uint64()
+	if n > uint64((len(r.data)-r.offset)/size) {
+		r.err = ErrInvalidSnapshot
+		return 0
+	}
+
+	return int(n)
+}
+
+func (r *reader) string() string {
+	return string(r.bytes(int(r.uint16())))
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return le.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return le.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return le.Uint64(b)
+	}
+	return 0
+}
+
+// Snapshot is a snapshot file mapped in memory.
+type Snapshot struct {
+	Sections []Section
+	unmap    func() error
+}
+
+// OpenSnapshot memory-maps the snapshot at path and decodes its sections, whose registries are only valid until the Snapshot is closed.
+func OpenSnapshot(path string) (*Snapshot, error) {
+	data, unmap, err := mmap(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := ReadSnapshot(data)
+	if err != nil {
+		_ = unmap()
+		return nil, err
+	}
+
+	return &Snapshot{Sections: sections, unmap: unmap}, nil
+}
+
+// Section returns the section with the provided name, if any.
+func (s *Snapshot) Section(name string) (Section, bool) {
+	for _, section := range s.Sections {
+		if section.Name == name {
+			return section, true
+		}
+	}
+
+	return Section{}, false
+}
+
+func (s *Snapshot) Close() error {
+	return s.unmap()
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+)
+
+func TestSnapshot_Roundtrip(t *testing.T) {
+	reg := NewCompact(true)
+	for i := 0; i < 1000; i++ {
+		reg.Add(domain(i), hosts.Rule{Action: hosts.Block})
+	}
+	reg.Add("*.example.org", hosts.Rule{Action: hosts.Rewrite, Target: "safe.example.org"})
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, WriteSnapshot(file, []Section{{Name: "hosts", Source: "hosts:/tmp/hosts", Registry: reg}}))
+	require.NoError(t, file.Close())
+
+	snapshot, err := OpenSnapshot(path)
+	require.NoError(t, err)
+	defer snapshot.Close()
+
+	section, ok := snapshot.Section("hosts")
+	require.True(t, ok)
+	assert.Equal(t, "hosts:/tmp/hosts", section.Source)
+	assert.Equal(t, 1001, section.Registry.Len())
+	assert.Equal(t, 1, section.Registry.Wildcards())
+
+	for i := 0; i < 1000; i++ {
+		_, ok := section.Registry.Get(domain(i))
+		assert.True(t, ok)
+	}
+
+	rule, ok := section.Registry.Get("*.example.org")
+	assert.True(t, ok)
+	assert.Equal(t, "safe.example.org", rule.Target)
+
+	// registries loaded from a snapshot can still be modified
+	assert.True(t, section.Registry.Add("new.example.org", hosts.Rule{Action: hosts.Block}))
+	_, ok = section.Registry.Get("new.example.org")
+	assert.True(t, ok)
+}
+
+func TestReadSnapshot_DetectsCorruption(t *testing.T) {
+	reg := NewCompact(false)
+	reg.Add("example.com", hosts.Rule{Action: hosts.Block})
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, []Section{{Name: "hosts", Registry: reg}}))
+
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xFF
+
+	_, err := ReadSnapshot(data)
+	assert.ErrorIs(t, err, ErrSnapshotChecksum)
+
+	_, err = ReadSnapshot([]byte("not a snapshot"))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+}
@@ -0,0 +1,245 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/metrics"
+	"github.com/fedragon/sinkhole/internal/policy"
+)
+
+const minutesPerDay = 24 * 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule switches lists on (while any of its periods is ongoing) and off (otherwise) for groups of clients.
+type Schedule struct {
+	Name     string
+	Groups   []string // all groups if empty
+	Lists    []string
+	location *time.Location
+	periods  []period
+}
+
+type period struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since midnight
+}
+
+// New returns the schedule defined by def.
+func New(def policy.Schedule) (*Schedule, error) {
+	location := time.Local
+	if def.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(def.Timezone); err != nil {
+			return nil, fmt.Errorf("schedule %q: invalid timezone: %w", def.Name, err)
+		}
+	}
+
+	s := &Schedule{Name: def.Name, Groups: def.Groups, Lists: def.Lists, location: location}
+	for _, p := range def.Periods {
+		period, err := parsePeriod(p)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", def.Name, err)
+		}
+		s.periods = append(s.periods, period)
+	}
+
+	return s, nil
+}
+
+func parsePeriod(p policy.Period) (period, error) {
+	var result period
+
+	if len(p.Days) == 0 {
+		for i := range result.days {
+			result.days[i] = true
+		}
+	}
+
+	for _, day := range p.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return result, fmt.Errorf("invalid day %q", day)
+		}
+		result.days[weekday] = true
+	}
+
+	var err error
+	if result.start, err = parseTime(p.Start); err != nil {
+		return result, err
+	}
+	if result.end, err = parseTime(p.End); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// parseTime parses a time of the day in the format HH:MM (24:00 being the end of the day), returning the minutes since midnight.
+func parseTime(value string) (int, error) {
+	if value == "24:00" {
+		return minutesPerDay, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil || len(value) != len("15:04") {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", value)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active reports whether any period of the schedule is ongoing at t.
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.location)
+	day := t.Weekday()
+	previous := (day + 6) % 7
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, p := range s.periods {
+		if p.start < p.end {
+			if p.days[day] && minute >= p.start && minute < p.end {
+				return true
+			}
+			continue
+		}
+
+		// the period spans midnight (or the whole day, if it ends when it starts)
+		if (p.days[day] && minute >= p.start) || (p.days[previous] && minute < p.end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AppliesTo reports whether the schedule applies to the group.
+func (s *Schedule) AppliesTo(group string) bool {
+	return len(s.Groups) == 0 || slices.Contains(s.Groups, group)
+}
+
+// Status is the current state of a schedule.
+type Status struct {
+	Name     string   `json:"name"`
+	Active   bool     `json:"active"`
+	Groups   []string `json:"groups"`
+	Lists    []string `json:"lists"`
+	Timezone string   `json:"timezone"`
+}
+
+// Scheduler determines which lists are enabled for each group of clients, according to its schedules.
+type Scheduler struct {
+	mu        sync.RWMutex
+	schedules []*Schedule
+	now       func() time.Time
+}
+
+// NewScheduler returns a Scheduler evaluating the schedules at the time returned by now.
+func NewScheduler(now func() time.Time, schedules ...*Schedule) *Scheduler {
+	return &Scheduler{schedules: schedules, now: now}
+}
+
+// Enabled reports whether the list is enabled for the group: lists switched by schedules applying to the group are only enabled while
+// any of those schedules is active, all other lists are always enabled.
+func (s *Scheduler) Enabled(group, list string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scheduled := false
+	for _, schedule := range s.schedules {
+		if !schedule.AppliesTo(group) || !slices.Contains(schedule.Lists, list) {
+			continue
+		}
+
+		if schedule.Active(s.now()) {
+			return true
+		}
+		scheduled = true
+	}
+
+	return !scheduled
+}
+
+// Status returns the current state of all schedules.
+func (s *Scheduler) Status() []Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+
+	statuses := make([]Status, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		statuses = append(statuses, Status{
+			Name:     schedule.Name,
+			Active:   schedule.Active(now),
+			Groups:   schedule.Groups,
+			Lists:    schedule.Lists,
+			Timezone: schedule.location.String(),
+		})
+	}
+
+	return statuses
+}
+
+// Set replaces the schedules.
+func (s *Scheduler) Set(schedules ...*Schedule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, schedule := range s.schedules {
+		metrics.ScheduleActive.DeleteLabelValues(schedule.Name)
+	}
+	s.schedules = schedules
+}
+
+// Run periodically exports the state of the schedules as metrics, until ctx is done.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, status := range s.Status() {
+			active := 0.0
+			if status.Active {
+				active = 1
+			}
+			metrics.ScheduleActive.WithLabelValues(status.Name).Set(active)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NewSchedules returns the schedules defined by the policy.
+func NewSchedules(p policy.Policy) ([]*Schedule, error) {
+	var schedules []*Schedule
+	var errs []error
+	for _, def := range p.Schedules {
+		schedule, err := New(def)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, errors.Join(errs...)
+}
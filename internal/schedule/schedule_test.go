@@ -0,0 +1,108 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fedragon/sinkhole/internal/policy"
+)
+
+func mustNew(t *testing.T, def policy.Schedule) *Schedule {
+	s, err := New(def)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestSchedule_Active(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	assert.NoError(t, err)
+
+	sut := mustNew(t, policy.Schedule{
+		Name:     "school",
+		Lists:    []string{"social"},
+		Timezone: "Europe/Amsterdam",
+		Periods: []policy.Period{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:30", End: "15:00"},
+			{Days: []string{"Sun"}, Start: "21:00", End: "07:00"},
+		},
+	})
+
+	cases := []struct {
+		time   time.Time
+		active bool
+	}{
+		{time.Date(2024, 9, 2, 8, 30, 0, 0, amsterdam), true},    // Monday
+		{time.Date(2024, 9, 2, 14, 59, 59, 0, amsterdam), true},  // Monday
+		{time.Date(2024, 9, 2, 15, 0, 0, 0, amsterdam), false},   // Monday
+		{time.Date(2024, 9, 2, 6, 30, 0, 0, time.UTC), true},     // Monday, 08:30 in Amsterdam
+		{time.Date(2024, 9, 7, 10, 0, 0, 0, amsterdam), false},   // Saturday
+		{time.Date(2024, 9, 8, 20, 59, 0, 0, amsterdam), false},  // Sunday
+		{time.Date(2024, 9, 8, 23, 0, 0, 0, amsterdam), true},    // Sunday
+		{time.Date(2024, 9, 9, 6, 59, 0, 0, amsterdam), true},    // Monday, after Sunday
+		{time.Date(2024, 9, 10, 6, 59, 0, 0, amsterdam), false},  // Tuesday, after Monday
+		{time.Date(2024, 9, 10, 7, 0, 0, 0, amsterdam), false},   // Tuesday
+		{time.Date(2024, 9, 10, 8, 29, 59, 0, amsterdam), false}, // Tuesday
+		{time.Date(2024, 9, 10, 12, 0, 0, 0, time.FixedZone("", 0)), true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.active, sut.Active(c.time), c.time.String())
+	}
+}
+
+func TestNew_RejectsInvalidPeriods(t *testing.T) {
+	for _, period := range []policy.Period{
+		{Days: []string{"monday"}, Start: "08:00", End: "15:00"},
+		{Start: "8:00", End: "15:00"},
+		{Start: "08:00", End: "25:00"},
+		{Start: "08:00"},
+	} {
+		_, err := New(policy.Schedule{Name: "invalid", Lists: []string{"social"}, Periods: []policy.Period{period}})
+		assert.Error(t, err)
+	}
+
+	_, err := New(policy.Schedule{Name: "invalid", Lists: []string{"social"}, Timezone: "Mars/Olympus_Mons"})
+	assert.Error(t, err)
+}
+
+func TestScheduler_Enabled(t *testing.T) {
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC) // Monday
+	school := mustNew(t, policy.Schedule{
+		Name:     "school",
+		Groups:   []string{"kids"},
+		Lists:    []string{"social", "gaming"},
+		Timezone: "UTC",
+		Periods:  []policy.Period{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "15:00"}},
+	})
+	bedtime := mustNew(t, policy.Schedule{
+		Name:     "bedtime",
+		Groups:   []string{"kids"},
+		Lists:    []string{"gaming"},
+		Timezone: "UTC",
+		Periods:  []policy.Period{{Start: "21:00", End: "07:00"}},
+	})
+
+	sut := NewScheduler(func() time.Time { return now }, school, bedtime)
+
+	assert.True(t, sut.Enabled("kids", "social"))
+	assert.True(t, sut.Enabled("kids", "gaming"))
+	assert.True(t, sut.Enabled("kids", "malware"))
+	assert.True(t, sut.Enabled("work", "social"))
+
+	now = now.Add(6 * time.Hour) // 16:00
+	assert.False(t, sut.Enabled("kids", "social"))
+	assert.False(t, sut.Enabled("kids", "gaming"))
+	assert.True(t, sut.Enabled("kids", "malware"))
+	assert.True(t, sut.Enabled("work", "social"))
+
+	now = now.Add(6 * time.Hour) // 22:00
+	assert.False(t, sut.Enabled("kids", "social"))
+	assert.True(t, sut.Enabled("kids", "gaming"))
+
+	assert.Equal(t, []Status{
+		{Name: "school", Active: false, Groups: []string{"kids"}, Lists: []string{"social", "gaming"}, Timezone: "UTC"},
+		{Name: "bedtime", Active: true, Groups: []string{"kids"}, Lists: []string{"gaming"}, Timezone: "UTC"},
+	}, sut.Status())
+}
@@ -0,0 +1,193 @@
+package stats
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+)
+
+const (
+	recentSize     = 1000             // number of recent queries kept in memory
+	bucketDuration = 10 * time.Minute // resolution of the queries over time
+	bucketCount    = 144              // i.e. 24 hours
+	topCapacity    = 200              // keys tracked per bucket by the top domains and clients
+
+	// DefaultWindow is the period over which the top domains and clients are counted, unless specified.
+	DefaultWindow = 24 * time.Hour
+)
+
+// Query is a query handled by the DNS server.
+type Query struct {
+	ID         uint64    `json:"id"` // increasing: queries with a higher ID have been handled later
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Decision   string    `json:"decision"` // one of: forwarded, blocked, dropped, paused, local
+	Group      string    `json:"group,omitempty"`
+	List       string    `json:"list,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// Blocked reports whether the query has been blocked (i.e. answered by the sinkhole, or dropped).
+func (q Query) Blocked() bool {
+	return q.Decision == dns.Blocked.String() || q.Decision == dns.Dropped.String()
+}
+
+// Bucket counts the queries handled over a period of time.
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Total   uint64    `json:"total"`
+	Blocked uint64    `json:"blocked"`
+}
+
+// Count is the estimated number of queries for a domain, or from a client: the actual number differs from it by at most Error.
+type Count struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+// Summary describes the queries handled by the DNS server.
+type Summary struct {
+	Total      uint64   `json:"total"`   // since startup
+	Blocked    uint64   `json:"blocked"` // since startup
+	OverTime   []Bucket `json:"over_time"`
+	Window     string   `json:"window"`      // period over which the top domains and clients are counted, e.g. 1h0m0s
+	TopQueried []Count  `json:"top_queried"` // all domains
+	TopBlocked []Count  `json:"top_blocked"`
+	TopAllowed []Count  `json:"top_allowed"`
+	TopClients []Count  `json:"top_clients"`
+}
+
+// Stats keeps track of the recent queries handled by the DNS server, of their number over the last 24 hours, and of the domains and
+// clients with the most queries: as their number is unbounded, the latter are estimated in bounded memory.
+type Stats struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	nextID  uint64
+	recent  []Query // ring buffer: the oldest query is at index next, once full
+	next    int
+	buckets [bucketCount]Bucket
+	total   uint64
+	blocked uint64
+
+	topQueried *slidingTop
+	topBlocked *slidingTop
+	topAllowed *slidingTop
+	topClients *slidingTop
+}
+
+// New returns empty statistics, whose buckets are aligned to the time returned by now.
+func New(now func() time.Time) *Stats {
+	return &Stats{
+		now:        now,
+		recent:     make([]Query, 0, recentSize),
+		topQueried: newSlidingTop(),
+		topBlocked: newSlidingTop(),
+		topAllowed: newSlidingTop(),
+		topClients: newSlidingTop(),
+	}
+}
+
+// Record records a query handled by the DNS server.
+func (s *Stats) Record(event dns.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	q := Query{
+		ID:         s.nextID,
+		Time:       event.Time,
+		Client:     event.Client.String(),
+		Name:       event.Name,
+		Type:       event.Type.String(),
+		Decision:   event.Decision.String(),
+		Group:      event.Group,
+		List:       event.List,
+		DurationMs: float64(event.Duration.Microseconds()) / 1000,
+	}
+
+	if len(s.recent) < recentSize {
+		s.recent = append(s.recent, q)
+	} else {
+		s.recent[s.next] = q
+		s.next = (s.next + 1) % recentSize
+	}
+
+	b := s.bucket(event.Time)
+	b.Total++
+	s.total++
+	if q.Blocked() {
+		b.Blocked++
+		s.blocked++
+	}
+
+	s.topQueried.add(event.Time, q.Name)
+	if q.Blocked() {
+		s.topBlocked.add(event.Time, q.Name)
+	} else {
+		s.topAllowed.add(event.Time, q.Name)
+	}
+	s.topClients.add(event.Time, q.Client)
+}
+
+// bucket returns the bucket of t, resetting it if it was last used for an earlier period.
+func (s *Stats) bucket(t time.Time) *Bucket {
+	start := t.Truncate(bucketDuration)
+	b := &s.buckets[(start.Unix()/int64(bucketDuration.Seconds()))%bucketCount]
+	if !b.Start.Equal(start) {
+		*b = Bucket{Start: start}
+	}
+
+	return b
+}
+
+// Queries returns up to limit of the recent queries handled after the one with the provided ID, oldest first.
+func (s *Stats) Queries(after uint64, limit int) []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queries := []Query{}
+	for _, q := range s.ordered() {
+		if q.ID > after {
+			queries = append(queries, q)
+		}
+	}
+
+	// the most recent queries are the most interesting ones
+	return queries[max(0, len(queries)-limit):]
+}
+
+// Summary returns the number of queries over the last 24 hours, along with the n domains and clients with the most queries over the
+// window, which is rounded up to a multiple of 10 minutes and capped to 24 hours.
+func (s *Stats) Summary(n int, window time.Duration) Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window = min(max(bucketDuration, (window+bucketDuration-1).Truncate(bucketDuration)), bucketCount*bucketDuration)
+	summary := Summary{Total: s.total, Blocked: s.blocked, Window: window.String()}
+
+	end := s.now().Truncate(bucketDuration)
+	for start := end.Add(-(bucketCount - 1) * bucketDuration); !start.After(end); start = start.Add(bucketDuration) {
+		b := s.buckets[(start.Unix()/int64(bucketDuration.Seconds()))%bucketCount]
+		if !b.Start.Equal(start) {
+			b = Bucket{Start: start}
+		}
+		summary.OverTime = append(summary.OverTime, b)
+	}
+
+	since := end.Add(bucketDuration - window)
+	summary.TopQueried = s.topQueried.top(since, n)
+	summary.TopBlocked = s.topBlocked.top(since, n)
+	summary.TopAllowed = s.topAllowed.top(since, n)
+	summary.TopClients = s.topClients.top(since, n)
+	return summary
+}
+
+// ordered returns the recent queries, oldest first.
+func (s *Stats) ordered() []Query {
+	return append(slices.Clone(s.recent[s.next:]), s.recent[:s.next]...)
+}
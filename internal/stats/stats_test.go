@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+)
+
+func TestStats_Summary(t *testing.T) {
+	now := time.Date(2024, 9, 2, 10, 5, 0, 0, time.UTC)
+	sut := New(func() time.Time { return now })
+
+	record := func(at time.Time, client, name string, decision dns.Decision) {
+		sut.Record(dns.Event{Time: at, Client: netip.MustParseAddr(client), Name: name, Type: message.TypeA, Decision: decision})
+	}
+
+	record(now.Add(-25*time.Hour), "192.168.1.10", "old.yyy", dns.Forwarded)
+	record(now.Add(-20*time.Minute), "192.168.1.10", "ads.yyy", dns.Blocked)
+	record(now, "192.168.1.10", "ads.yyy", dns.Blocked)
+	record(now, "192.168.1.20", "tracker.yyy", dns.Dropped)
+	record(now, "192.168.1.20", "example.com", dns.Forwarded)
+
+	summary := sut.Summary(1, DefaultWindow)
+	assert.Equal(t, uint64(5), summary.Total)
+	assert.Equal(t, uint64(3), summary.Blocked)
+
+	assert.Len(t, summary.OverTime, bucketCount)
+	assert.Equal(t, Bucket{Start: time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC), Total: 3, Blocked: 2}, summary.OverTime[bucketCount-1])
+	assert.Equal(t, Bucket{Start: time.Date(2024, 9, 2, 9, 40, 0, 0, time.UTC), Total: 1, Blocked: 1}, summary.OverTime[bucketCount-3])
+	// queries older than 24 hours are left out
+	assert.Equal(t, Bucket{Start: time.Date(2024, 9, 1, 10, 10, 0, 0, time.UTC)}, summary.OverTime[0])
+
+	// so are they from the top domains and clients
+	assert.Equal(t, "24h0m0s", summary.Window)
+	assert.Equal(t, []Count{{Key: "ads.yyy", Count: 2}}, summary.TopQueried)
+	assert.Equal(t, []Count{{Key: "ads.yyy", Count: 2}}, summary.TopBlocked)
+	assert.Equal(t, []Count{{Key: "example.com", Count: 1}}, summary.TopAllowed)
+	assert.Equal(t, []Count{{Key: "192.168.1.10", Count: 2}}, summary.TopClients)
+
+	// the window is rounded up to the next 10 minutes
+	summary = sut.Summary(3, 5*time.Minute)
+	assert.Equal(t, "10m0s", summary.Window)
+	assert.Equal(t, []Count{{Key: "ads.yyy", Count: 1}, {Key: "example.com", Count: 1}, {Key: "tracker.yyy", Count: 1}}, summary.TopQueried)
+	assert.Equal(t, []Count{{Key: "192.168.1.20", Count: 2}, {Key: "192.168.1.10", Count: 1}}, summary.TopClients)
+}
+
+func TestSpaceSaving(t *testing.T) {
+	sut := newSpaceSaving(3)
+	for _, key := range []string{"a", "b", "a", "c", "a", "d", "b", "a"} {
+		sut.add(key)
+	}
+
+	// d replaced the key with the lowest count (c), inheriting its count as error
+	assert.Len(t, sut.counters, 3)
+	assert.Equal(t, uint64(4), sut.counters["a"].count)
+	assert.Equal(t, uint64(2), sut.counters["b"].count)
+	assert.Equal(t, uint64(2), sut.counters["d"].count)
+	assert.Equal(t, uint64(1), sut.counters["d"].err)
+	assert.NotContains(t, sut.counters, "c")
+	assert.Equal(t, uint64(2), sut.min())
+}
+
+func TestSlidingTop_EstimatesWithinError(t *testing.T) {
+	start := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+	sut := newSlidingTop()
+
+	// a few heavy hitters among many more keys than tracked
+	actual := make(map[string]uint64)
+	for i := range 20_000 {
+		key := fmt.Sprintf("noise-%d.yyy", i%(3*topCapacity))
+		if i%4 == 0 {
+			key = fmt.Sprintf("heavy-%d.yyy", i%3)
+		}
+		at := start.Add(time.Duration(i) * time.Second / 10) // spread over several buckets
+		sut.add(at, key)
+		actual[key]++
+	}
+
+	top := sut.top(start, 3)
+	require.Len(t, top, 3)
+	for _, c := range top {
+		assert.Contains(t, c.Key, "heavy-")
+		assert.LessOrEqual(t, c.Count-c.Error, actual[c.Key], c.Key)
+		assert.GreaterOrEqual(t, c.Count+c.Error, actual[c.Key], c.Key)
+	}
+}
+
+func TestStats_Queries(t *testing.T) {
+	sut := New(time.Now)
+	for range recentSize + 10 {
+		sut.Record(dns.Event{Time: time.Now(), Client: netip.MustParseAddr("192.168.1.10"), Name: "example.com", Type: message.TypeAAAA})
+	}
+
+	queries := sut.Queries(0, 2)
+	assert.Len(t, queries, 2)
+	assert.Equal(t, uint64(recentSize+9), queries[0].ID)
+	assert.Equal(t, "AAAA", queries[1].Type)
+	assert.Equal(t, "forwarded", queries[1].Decision)
+
+	assert.Empty(t, sut.Queries(recentSize+10, 100))
+	assert.Len(t, sut.Queries(recentSize, 100), 10)
+}
@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+	"time"
+)
+
+// counter is the estimated count of a key: it exceeds the actual one by at most err.
+type counter struct {
+	key   string
+	count uint64
+	err   uint64
+	index int // in the heap
+}
+
+// spaceSaving estimates the most frequent keys of a stream in bounded memory, with the Space-Saving algorithm (Metwally et al., 2005):
+// once capacity keys are tracked, a new key replaces the one with the lowest count, inheriting that count as its error.
+type spaceSaving struct {
+	capacity int
+	counters map[string]*counter
+	heap     counterHeap // lowest count first
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, counters: make(map[string]*counter)}
+}
+
+func (s *spaceSaving) add(key string) {
+	if c, ok := s.counters[key]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+
+	if len(s.heap) < s.capacity {
+		c := &counter{key: key, count: 1}
+		s.counters[key] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+
+	c := s.heap[0]
+	delete(s.counters, c.key)
+	c.key, c.err, c.count = key, c.count, c.count+1
+	s.counters[key] = c
+	heap.Fix(&s.heap, 0)
+}
+
+func (s *spaceSaving) reset() {
+	clear(s.counters)
+	s.heap = s.heap[:0]
+}
+
+// min returns the highest count that a key which is not tracked may have.
+func (s *spaceSaving) min() uint64 {
+	if len(s.heap) < s.capacity {
+		return 0
+	}
+
+	return s.heap[0].count
+}
+
+type counterHeap []*counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *counterHeap) Push(x any) {
+	c := x.(*counter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// slidingTop estimates the most frequent keys over a sliding window, keeping a Space-Saving summary per slot of time: the summaries of
+// the slots within the window are merged when queried.
+type slidingTop struct {
+	slots []topSlot
+}
+
+type topSlot struct {
+	start   time.Time
+	summary *spaceSaving
+}
+
+func newSlidingTop() *slidingTop {
+	return &slidingTop{slots: make([]topSlot, bucketCount)}
+}
+
+func (t *slidingTop) add(at time.Time, key string) {
+	start := at.Truncate(bucketDuration)
+	slot := &t.slots[(start.Unix()/int64(bucketDuration.Seconds()))%bucketCount]
+	if !slot.start.Equal(start) {
+		if start.Before(slot.start) {
+			return // the slot is already used for a later period
+		}
+
+		if slot.summary == nil {
+			slot.summary = newSpaceSaving(topCapacity)
+		} else {
+			slot.summary.reset()
+		}
+		slot.start = start
+	}
+
+	slot.summary.add(key)
+}
+
+// top returns the n keys with the highest estimated counts over the slots starting at or after since, sorted by decreasing count (and
+// then by key). Counts are the sums of those of the summaries tracking the keys: the actual ones differ from them by at most Error.
+func (t *slidingTop) top(since time.Time, n int) []Count {
+	type estimate struct {
+		Count
+		covered uint64 // sum of the minimum counts of the summaries tracking the key
+	}
+
+	// a key that a summary does not track may still have been counted up to the minimum count of that summary
+	var mins uint64
+	estimates := make(map[string]*estimate)
+	for _, slot := range t.slots {
+		if slot.summary == nil || slot.start.Before(since) {
+			continue
+		}
+
+		m := slot.summary.min()
+		mins += m
+		for key, c := range slot.summary.counters {
+			e := estimates[key]
+			if e == nil {
+				e = &estimate{Count: Count{Key: key}}
+				estimates[key] = e
+			}
+			e.Count.Count += c.count
+			e.Error += c.err
+			e.covered += m
+		}
+	}
+
+	result := make([]Count, 0, len(estimates))
+	for _, e := range estimates {
+		e.Error += mins - e.covered
+		result = append(result, e.Count)
+	}
+
+	slices.SortFunc(result, func(a, b Count) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Key, b.Key))
+	})
+
+	return result[:min(n, len(result))]
+}
@@ -0,0 +1,141 @@
+// Package systemd implements the parts of the systemd service protocol used by the sinkhole, without depending on libsystemd: readiness
+// and status notifications, watchdog pings (see sd_notify(3)) and socket activation (see sd_listen_fds(3)).
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification states, see sd_notify(3)
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1" // followed by Ready, once reloaded
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// the first file descriptor passed by socket activation, following stdin, stdout and stderr
+const listenFDsStart = 3
+
+// notifier is the connection to the notification socket, opened by the first notification and kept open: once the root of the process
+// is changed (see privileges.Drop), the socket can no longer be reached by its path.
+var notifier struct {
+	mu     sync.Mutex
+	socket string
+	conn   *net.UnixConn
+}
+
+// Status returns the state describing the service, as shown by `systemctl status`.
+func Status(format string, args ...any) string {
+	return "STATUS=" + fmt.Sprintf(format, args...)
+}
+
+// Notify sends states (e.g. Ready) to the service manager, returning false without error if the process has not been started by
+// systemd with a notification socket (i.e. unless Type=notify). The socket is opened by the first call, which must therefore happen
+// before changing root.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	if notifier.conn == nil || notifier.socket != socket {
+		if notifier.conn != nil {
+			_ = notifier.conn.Close()
+			notifier.conn = nil
+		}
+
+		// an initial @ denotes the abstract namespace
+		name := socket
+		if name[0] == '@' {
+			name = "\x00" + name[1:]
+		}
+
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+		if err != nil {
+			return false, err
+		}
+		notifier.socket, notifier.conn = socket, conn
+	}
+
+	if _, err := notifier.conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// WatchdogInterval returns how often the service manager expects Watchdog pings, or false if it does not expect any (i.e. unless
+// WatchdogSec is set). Pings should be sent at least twice as often.
+func WatchdogInterval() (time.Duration, bool, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, false, nil
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false, nil // meant for another process
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false, fmt.Errorf("invalid WATCHDOG_USEC: %q", usec)
+	}
+
+	return time.Duration(n) * time.Microsecond, true, nil
+}
+
+// Files returns the sockets passed by the service manager (socket activation), in the order of the socket unit, or none if the process
+// has not been socket activated. The variables describing them are removed from the environment, so that children do not inherit them.
+func Files() ([]*os.File, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	n, err := listenFDs(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getpid())
+	if err != nil || n == 0 {
+		return nil, err
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make([]*os.File, 0, n)
+	for i := range n {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), name))
+	}
+
+	return files, nil
+}
+
+// listenFDs returns the number of sockets passed to the process with the provided pid, given the values of LISTEN_PID and LISTEN_FDS.
+func listenFDs(listenPID, listenFDs string, pid int) (int, error) {
+	if listenPID == "" {
+		return 0, nil
+	}
+
+	if listenPID != strconv.Itoa(pid) {
+		return 0, nil // meant for another process
+	}
+
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid LISTEN_FDS: %q", listenFDs)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,86 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(Ready)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	sent, err = Notify(Ready, Status("Blocking %d domains", 42))
+	require.NoError(t, err)
+	assert.True(t, sent)
+
+	buffer := make([]byte, 256)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1\nSTATUS=Blocking 42 domains", string(buffer[:n]))
+
+	// the socket stays reachable once it can no longer be found by its path, e.g. after changing root
+	require.NoError(t, os.Remove(path))
+	sent, err = Notify(Watchdog)
+	require.NoError(t, err)
+	assert.True(t, sent)
+
+	n, err = conn.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "WATCHDOG=1", string(buffer[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	_, ok, err := WatchdogInterval()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	interval, ok, err := WatchdogInterval()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, interval)
+
+	t.Setenv("WATCHDOG_PID", "1")
+	_, ok, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "soon")
+	_, _, err = WatchdogInterval()
+	assert.Error(t, err)
+}
+
+func TestListenFDs(t *testing.T) {
+	n, err := listenFDs("", "", 42)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	n, err = listenFDs("42", "2", 42)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = listenFDs("43", "2", 42)
+	assert.NoError(t, err)
+	assert.Zero(t, n, "meant for another process")
+
+	_, err = listenFDs("42", "many", 42)
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const serviceName = "sinkhole"
+
+// NewProvider returns a tracer provider exporting spans in batches to the OTLP/HTTP collector at endpoint (e.g.
+// http://localhost:4318), sampling the ratio of queries provided. The exporter also honours the standard OTEL_EXPORTER_OTLP_* variables,
+// e.g. to set headers.
+func NewProvider(ctx context.Context, endpoint string, sampleRatio float64, version string) (*sdktrace.TracerProvider, error) {
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return nil, fmt.Errorf("invalid sample ratio: %v, must be between 0 and 1", sampleRatio)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP exporter: %w", err)
+	}
+
+	return newProvider(exporter, sampleRatio, version), nil
+}
+
+// newProvider returns a tracer provider exporting spans in batches to exporter: tests use an in-memory one.
+func newProvider(exporter sdktrace.SpanExporter, sampleRatio float64, version string) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", version),
+		)),
+	)
+}
+
+// Enable makes the instrumented packages record their spans with provider: until then, they do nothing.
+func Enable(provider *sdktrace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewProvider(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newProvider(exporter, 1, "v1")
+
+	_, span := provider.Tracer("test").Start(context.Background(), "dns.query")
+	span.End()
+	require.NoError(t, provider.ForceFlush(context.Background())) // exports the pending spans
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "dns.query", spans[0].Name)
+	assert.Contains(t, spans[0].Resource.Attributes(), attribute.String("service.name", "sinkhole"))
+	assert.Contains(t, spans[0].Resource.Attributes(), attribute.String("service.version", "v1"))
+}
+
+func TestNewProvider_SamplesQueries(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newProvider(exporter, 0, "v1")
+
+	_, span := provider.Tracer("test").Start(context.Background(), "dns.query")
+	span.End()
+	require.NoError(t, provider.ForceFlush(context.Background()))
+
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func TestNewProvider_RejectsInvalidRatios(t *testing.T) {
+	_, err := NewProvider(context.Background(), "http://localhost:4318", 1.5, "v1")
+	assert.Error(t, err)
+}
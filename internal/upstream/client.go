@@ -1,46 +1,185 @@
 package upstream
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/metrics"
 )
 
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+
+	DefaultTimeout = time.Second
+
+	// large enough for the responses to queries advertising a bigger buffer via EDNS
+	maxResponseSize = 4096
+)
+
+var ErrIDMismatch = errors.New("response ID does not match query ID")
+
+// tracer records the exchanges with the upstreams, if tracing is enabled.
+var tracer = otel.Tracer("github.com/fedragon/sinkhole/internal/upstream")
+
+// Client exchanges DNS messages with an upstream resolver.
 type Client struct {
-	conn *net.UDPConn
+	transport string
+	addr      string
+	timeout   time.Duration
+
+	mu   sync.Mutex
+	conn *net.UDPConn // only used by the UDP transport
 }
 
-func NewClient(addr string) (io.ReadWriteCloser, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
-	if err != nil {
-		return nil, err
+// NewClient returns a client sending queries to addr over the provided transport (either "udp" or "tcp"), and waiting up to timeout for responses.
+func NewClient(transport, addr string, timeout time.Duration) (*Client, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
 	}
 
-	conn, err := net.DialUDP("udp4", nil, udpAddr)
+	c := &Client{transport: transport, addr: addr, timeout: timeout}
+
+	switch transport {
+	case TransportUDP:
+		udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := net.DialUDP("udp4", nil, udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	case TransportTCP:
+		// connections are established per query
+		if _, err := net.ResolveTCPAddr("tcp4", addr); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown transport: %q", transport)
+	}
+
+	return c, nil
+}
+
+// Addr returns the address of the upstream resolver.
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Exchange sends a query to the upstream resolver, and returns its response.
+func (c *Client) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, io.ErrShortBuffer
+	}
+
+	_, span := tracer.Start(ctx, "upstream.attempt", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", c.addr), attribute.String("network.transport", c.transport)))
+	defer span.End()
+
+	start := time.Now()
+	response, err := c.exchange(query)
+
+	rcode := metrics.RCodeNone
+	if code, err := message.RCodeOf(response); err == nil {
+		rcode = code.String()
+	}
+	metrics.UpstreamDuration.WithLabelValues(c.addr, c.transport, rcode).Observe(time.Since(start).Seconds())
+
+	span.SetAttributes(attribute.String("dns.response.code", rcode))
 	if err != nil {
-		return nil, err
+		span.SetStatus(codes.Error, err.Error())
 	}
 
-	return &Client{conn: conn}, nil
+	return response, err
 }
 
-func (c *Client) Write(data []byte) (int, error) {
-	return c.conn.Write(data)
+// exchange sends a query to the upstream resolver, without recording it in metrics nor traces.
+func (c *Client) exchange(query []byte) ([]byte, error) {
+	if c.transport == TransportTCP {
+		return c.exchangeTCP(query)
+	}
+
+	return c.exchangeUDP(query)
 }
 
-func (c *Client) Read(buffer []byte) (int, error) {
-	if err := c.conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
-		return 0, err
+func (c *Client) exchangeUDP(query []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	// skip late responses to previous queries, which timed out
+	response := make([]byte, maxResponseSize)
+	for {
+		n, _, err := c.conn.ReadFromUDP(response)
+		if err != nil {
+			return nil, err
+		}
+
+		if n >= 2 && response[0] == query[0] && response[1] == query[1] {
+			return response[:n], nil
+		}
 	}
+}
 
-	n, _, err := c.conn.ReadFromUDP(buffer)
+func (c *Client) exchangeTCP(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp4", c.addr, c.timeout)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
 	}
 
-	return n, nil
+	// messages sent over TCP are prefixed by their length
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	if len(response) < 2 || response[0] != query[0] || response[1] != query[1] {
+		return nil, ErrIDMismatch
+	}
+
+	return response, nil
 }
 
 func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+
 	return c.conn.Close()
 }
@@ -0,0 +1,276 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/records"
+)
+
+// Rule forwards the queries for a set of zones (and all their subdomains) to a set of upstream resolvers.
+type Rule struct {
+	Zones     []string
+	Addrs     []string
+	Transport string
+	Timeout   time.Duration
+}
+
+// Set is a set of upstream resolvers, tried in order until one of them answers.
+type Set struct {
+	clients []*Client
+}
+
+func NewSet(transport string, timeout time.Duration, addrs ...string) (*Set, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no upstream addresses")
+	}
+
+	s := &Set{}
+	for _, addr := range addrs {
+		client, err := NewClient(transport, addr, timeout)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("unable to connect to upstream %s: %w", addr, err)
+		}
+		s.clients = append(s.clients, client)
+	}
+
+	return s, nil
+}
+
+// Exchange sends the query to each resolver in turn, returning the first response along with the address of the resolver that sent it
+// (or all errors, if none answers).
+func (s *Set) Exchange(ctx context.Context, query []byte) ([]byte, string, error) {
+	var errs []error
+	for _, client := range s.clients {
+		response, err := client.Exchange(ctx, query)
+		if err == nil {
+			return response, client.Addr(), nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", client.Addr(), err))
+	}
+
+	return nil, "", errors.Join(errs...)
+}
+
+func (s *Set) Close() error {
+	var errs []error
+	for _, client := range s.clients {
+		errs = append(errs, client.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+type zone struct {
+	name      string
+	upstreams *Set
+}
+
+// fallbackZone names the zone of the fallback upstreams, in traces.
+const fallbackZone = "."
+
+// Forwarder forwards each query to the upstreams of the most specific zone its domain belongs to, or to the fallback upstreams.
+type Forwarder struct {
+	zones    []zone // sorted by decreasing length of name, so that the most specific zone matches first
+	fallback *Set
+	sets     []*Set
+}
+
+func NewForwarder(fallback *Set, rules ...Rule) (*Forwarder, error) {
+	f := &Forwarder{fallback: fallback, sets: []*Set{fallback}}
+
+	for _, rule := range rules {
+		upstreams, err := NewSet(rule.Transport, rule.Timeout, rule.Addrs...)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		f.sets = append(f.sets, upstreams)
+
+		for _, name := range rule.Zones {
+			f.zones = append(f.zones, zone{name: name, upstreams: upstreams})
+		}
+	}
+
+	slices.SortStableFunc(f.zones, func(a, b zone) int {
+		return len(b.name) - len(a.name)
+	})
+
+	return f, nil
+}
+
+// Exchange forwards a query for the domain name to the appropriate upstreams, returning their response along with the address of the
+// upstream that sent it.
+func (f *Forwarder) Exchange(ctx context.Context, name string, query []byte) ([]byte, string, error) {
+	z := f.zone(strings.ToLower(name))
+
+	ctx, span := tracer.Start(ctx, "upstream.exchange", trace.WithAttributes(attribute.String("dns.question.name", name),
+		attribute.String("upstream.zone", z.name)))
+	defer span.End()
+
+	response, addr, err := z.upstreams.Exchange(ctx, query)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return response, addr, err
+}
+
+// zone returns the most specific zone the domain name belongs to, or the zone of the fallback upstreams.
+func (f *Forwarder) zone(name string) zone {
+	for _, z := range f.zones {
+		if name == z.name || strings.HasSuffix(name, "."+z.name) {
+			return z
+		}
+	}
+
+	return zone{name: fallbackZone, upstreams: f.fallback}
+}
+
+// clients returns the clients of all upstreams, the fallback ones first.
+func (f *Forwarder) clients() []*Client {
+	var clients []*Client
+	for _, set := range f.sets {
+		clients = append(clients, set.clients...)
+	}
+
+	return clients
+}
+
+func (f *Forwarder) Close() error {
+	var errs []error
+	for _, set := range f.sets {
+		errs = append(errs, set.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// ParseRules reads forwarding rules from r, one per line, in the format `<zone>[,<zone>...] <address>[,<address>...] [transport=udp|tcp] [timeout=<duration>]`.
+// Zones can also be expressed as IP prefixes (e.g. 192.168.1.0/24), which are converted to the zones used for their reverse lookups.
+// Blank lines and lines starting with `#` are ignored.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected <zone> <address> [transport=udp|tcp] [timeout=<duration>]", n)
+		}
+
+		rule := Rule{Addrs: strings.Split(fields[1], ","), Transport: TransportUDP, Timeout: DefaultTimeout}
+		for _, option := range fields[2:] {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "transport":
+				rule.Transport = value
+			case "timeout":
+				timeout, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid timeout: %w", n, err)
+				}
+				rule.Timeout = timeout
+			default:
+				return nil, fmt.Errorf("line %d: unknown option %q", n, key)
+			}
+		}
+
+		for _, name := range strings.Split(fields[0], ",") {
+			zones, err := Zones(name)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			rule.Zones = append(rule.Zones, zones...)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// Zones returns the normalised name of a zone or, if zone is an IP prefix, the names of the zones used for its reverse lookups.
+func Zones(zone string) ([]string, error) {
+	if !strings.Contains(zone, "/") {
+		name, err := hosts.Normalize(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone %q: %w", zone, err)
+		}
+		return []string{name}, nil
+	}
+
+	prefix, err := netip.ParsePrefix(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReverseZones(prefix), nil
+}
+
+// ReverseZones returns the names of the zones used for reverse lookups of the addresses in prefix. Reverse zones are delegated at octet
+// (IPv4) or nibble (IPv6) boundaries, so any other prefix is expanded to all the longer prefixes that fall on the next boundary.
+func ReverseZones(prefix netip.Prefix) []string {
+	prefix = prefix.Masked()
+	unit := 8 // bits per label
+	if prefix.Addr().Is6() {
+		unit = 4
+	}
+
+	// at most 128 zones (an IPv4 prefix one bit longer than an octet boundary)
+	bits := (prefix.Bits() + unit - 1) / unit * unit
+
+	var zones []string
+	for addr := prefix.Addr(); prefix.Contains(addr); {
+		// the reverse name of an address has one label per unit: only keep those of the network part
+		labels := strings.Split(records.ReverseName(addr), ".")
+		hostLabels := (addr.BitLen() - bits) / unit
+		zones = append(zones, strings.Join(labels[hostLabels:], "."))
+
+		next, ok := advance(addr, addr.BitLen()-bits)
+		if !ok {
+			break
+		}
+		addr = next
+	}
+
+	return zones
+}
+
+// advance returns the address that follows addr by 2^shift, reporting false on overflow.
+func advance(addr netip.Addr, shift int) (netip.Addr, bool) {
+	b := addr.AsSlice()
+	carry := 1 << (shift % 8)
+	for i := len(b) - 1 - shift/8; i >= 0 && carry > 0; i-- {
+		sum := int(b[i]) + carry
+		b[i] = byte(sum)
+		carry = sum >> 8
+	}
+
+	if carry > 0 {
+		return netip.Addr{}, false
+	}
+
+	next, _ := netip.AddrFromSlice(b)
+	return next, true
+}
@@ -0,0 +1,203 @@
+package upstream
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestParseRules(t *testing.T) {
+	input := `
+# comment
+corp.example,Other.Example  10.8.0.1:53,10.8.0.2:53 transport=tcp timeout=3s
+192.168.1.0/24              192.168.1.1:53
+`
+	rules, err := ParseRules(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, []Rule{
+		{Zones: []string{"corp.example", "other.example"}, Addrs: []string{"10.8.0.1:53", "10.8.0.2:53"}, Transport: TransportTCP, Timeout: 3 * time.Second},
+		{Zones: []string{"1.168.192.in-addr.arpa"}, Addrs: []string{"192.168.1.1:53"}, Transport: TransportUDP, Timeout: DefaultTimeout},
+	}, rules)
+}
+
+func TestParseRules_RejectsInvalidRules(t *testing.T) {
+	for _, input := range []string{
+		"corp.example",
+		"corp.example 10.8.0.1:53 retries=3",
+		"corp.example 10.8.0.1:53 timeout=soon",
+		"10.0.0.1 10.8.0.1:53",
+		"10.0.0.0/33 10.8.0.1:53",
+	} {
+		_, err := ParseRules(strings.NewReader(input))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestReverseZones(t *testing.T) {
+	cases := []struct {
+		prefix string
+		zones  []string
+	}{
+		{"10.0.0.0/8", []string{"10.in-addr.arpa"}},
+		{"192.168.1.0/24", []string{"1.168.192.in-addr.arpa"}},
+		{"192.168.2.0/23", []string{"2.168.192.in-addr.arpa", "3.168.192.in-addr.arpa"}},
+		{"192.168.1.42/32", []string{"42.1.168.192.in-addr.arpa"}},
+		{"fd00::/8", []string{"d.f.ip6.arpa"}},
+		{"fd00::/7", []string{"c.f.ip6.arpa", "d.f.ip6.arpa"}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.zones, ReverseZones(netip.MustParsePrefix(c.prefix)), c.prefix)
+	}
+}
+
+func TestForwarder_MatchesMostSpecificZone(t *testing.T) {
+	fallback, corp, dev := &Set{}, &Set{}, &Set{}
+	f := &Forwarder{
+		zones: []zone{
+			{name: "dev.corp.example", upstreams: dev},
+			{name: "corp.example", upstreams: corp},
+		},
+		fallback: fallback,
+	}
+
+	assert.Same(t, corp, f.zone("corp.example").upstreams)
+	assert.Same(t, corp, f.zone("www.corp.example").upstreams)
+	assert.Same(t, dev, f.zone("api.dev.corp.example").upstreams)
+	assert.Same(t, fallback, f.zone("notcorp.example").upstreams)
+	assert.Same(t, fallback, f.zone("example.com").upstreams)
+}
+
+func TestForwarder_Exchange(t *testing.T) {
+	corp := serveTCP(t, []byte("corp"))
+	fallback := serveUDP(t, []byte("fallback"))
+
+	set, err := NewSet(TransportUDP, time.Second, fallback)
+	assert.NoError(t, err)
+
+	f, err := NewForwarder(set, Rule{Zones: []string{"corp.example"}, Addrs: []string{corp}, Transport: TransportTCP, Timeout: time.Second})
+	assert.NoError(t, err)
+	defer f.Close()
+
+	query := []byte{0x12, 0x34, 0x01, 0x00}
+
+	response, addr, err := f.Exchange(context.Background(), "WWW.corp.example", query)
+	assert.NoError(t, err)
+	assert.Equal(t, append(query[:2:2], "corp"...), response)
+	assert.Equal(t, corp, addr)
+
+	response, addr, err = f.Exchange(context.Background(), "example.com", query)
+	assert.NoError(t, err)
+	assert.Equal(t, append(query[:2:2], "fallback"...), response)
+	assert.Equal(t, fallback, addr)
+}
+
+func TestSet_TriesNextUpstreamOnError(t *testing.T) {
+	// nothing listens on the first address, so the query times out
+	unreachable := serveUDP(t, nil)
+	reachable := serveUDP(t, []byte("ok"))
+
+	set, err := NewSet(TransportUDP, 100*time.Millisecond, unreachable, reachable)
+	assert.NoError(t, err)
+	defer set.Close()
+
+	response, addr, err := set.Exchange(context.Background(), []byte{0x12, 0x34})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x12, 0x34, 'o', 'k'}, response)
+	assert.Equal(t, reachable, addr)
+}
+
+func TestForwarder_TracesExchanges(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	unreachable := serveUDP(t, nil)
+	reachable := serveUDP(t, []byte("ok"))
+
+	set, err := NewSet(TransportUDP, 100*time.Millisecond, unreachable, reachable)
+	assert.NoError(t, err)
+	f, err := NewForwarder(set)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	_, _, err = f.Exchange(context.Background(), "example.com", []byte{0x12, 0x34})
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	// one attempt per upstream, the first one failing
+	assert.Equal(t, "upstream.attempt", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), attribute.String("server.address", unreachable))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "upstream.attempt", spans[1].Name())
+	assert.Contains(t, spans[1].Attributes(), attribute.String("server.address", reachable))
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+
+	assert.Equal(t, "upstream.exchange", spans[2].Name())
+	assert.Contains(t, spans[2].Attributes(), attribute.String("upstream.zone", fallbackZone))
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID())
+}
+
+// serveUDP starts a server answering each query with its ID followed by payload (or never answering, if payload is nil).
+func serveUDP(t *testing.T, payload []byte) string {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if payload != nil && n >= 2 {
+				_, _ = conn.WriteToUDP(append(buffer[:2:2], payload...), addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// serveTCP starts a server answering each query with its ID followed by payload.
+func serveTCP(t *testing.T, payload []byte) string {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					response := append(query[:2:2], payload...)
+					_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+				}
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return listener.Addr().String()
+}
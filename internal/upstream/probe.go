@@ -0,0 +1,175 @@
+package upstream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fedragon/sinkhole/internal/dns/message"
+)
+
+const (
+	DefaultProbeInterval = 10 * time.Second
+
+	// probes older than this many intervals are stale
+	probeFreshness = 3
+)
+
+// probeResult is the outcome of the last probe of an upstream.
+type probeResult struct {
+	answered time.Time // zero if never
+	rcode    message.RCode
+	err      error
+}
+
+// Prober periodically sends a probe query (for the NS records of the root zone) to each upstream, to tell whether any of them answers.
+// Probes are neither recorded in metrics nor in traces.
+type Prober struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	clients []*Client
+	results []probeResult // one per client
+}
+
+func NewProber(forwarder *Forwarder, interval time.Duration, now func() time.Time) *Prober {
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+
+	clients := forwarder.clients()
+	return &Prober{clients: clients, interval: interval, now: now, results: make([]probeResult, len(clients))}
+}
+
+// SetForwarder replaces the upstreams to probe, keeping the results of those whose address and transport have not changed.
+func (p *Prober) SetForwarder(forwarder *Forwarder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := make(map[[2]string]probeResult, len(p.clients))
+	for i, client := range p.clients {
+		previous[[2]string{client.transport, client.addr}] = p.results[i]
+	}
+
+	p.clients = forwarder.clients()
+	p.results = make([]probeResult, len(p.clients))
+	for i, client := range p.clients {
+		p.results[i] = previous[[2]string{client.transport, client.addr}]
+	}
+}
+
+// Run probes the upstreams right away, and then every interval until ctx is done.
+func (p *Prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.probe()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe sends a probe query to each upstream, concurrently.
+func (p *Prober) probe() {
+	// results are discarded if the upstreams are replaced in the meantime
+	p.mu.Lock()
+	clients, results := p.clients, p.results
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rcode, err := probe(client)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			results[i].rcode, results[i].err = rcode, err
+			if err == nil {
+				results[i].answered = p.now()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe sends a probe query to an upstream, returning the response code of its response: a server failure is an error.
+func probe(client *Client) (message.RCode, error) {
+	query, err := probeQuery()
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := client.exchange(query)
+	if err != nil {
+		return 0, err
+	}
+
+	rcode, err := message.RCodeOf(response)
+	if err != nil {
+		return 0, err
+	}
+	if rcode == message.RCodeServerFailure {
+		return rcode, fmt.Errorf("answered %v", rcode)
+	}
+
+	return rcode, nil
+}
+
+// probeQuery returns a query for the NS records of the root zone, with a random ID.
+func probeQuery() ([]byte, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	query := append(id[:], 0x01, 0x00)              // recursion desired
+	query = binary.BigEndian.AppendUint16(query, 1) // questions
+	query = append(query, make([]byte, 6)...)       // answers, authority and additional records
+	query = append(query, 0)                        // root name
+	query = binary.BigEndian.AppendUint16(query, uint16(message.TypeNS))
+	return binary.BigEndian.AppendUint16(query, uint16(message.ClassInternetAddress)), nil
+}
+
+// Check reports whether at least one upstream answered a recent probe, describing the state of all of them.
+func (p *Prober) Check() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	freshness := probeFreshness * p.interval
+	now := p.now()
+
+	answering := 0
+	states := make([]string, 0, len(p.clients))
+	for i, client := range p.clients {
+		result := p.results[i]
+		switch {
+		case !result.answered.IsZero() && now.Sub(result.answered) <= freshness:
+			answering++
+			states = append(states, fmt.Sprintf("%s answered %v", client.Addr(), result.rcode))
+		case result.err != nil:
+			states = append(states, fmt.Sprintf("%s: %v", client.Addr(), result.err))
+		default:
+			states = append(states, fmt.Sprintf("%s: no recent answer", client.Addr()))
+		}
+	}
+
+	detail := strings.Join(states, "; ")
+	if answering == 0 {
+		return "", fmt.Errorf("no upstream answered a probe in the last %v: %s", freshness, detail)
+	}
+
+	return detail, nil
+}
@@ -0,0 +1,95 @@
+package upstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/dns/message"
+)
+
+func TestProbeQuery(t *testing.T) {
+	raw, err := probeQuery()
+	require.NoError(t, err)
+
+	query, err := message.UnmarshalQuery(raw)
+	require.NoError(t, err)
+	assert.True(t, query.RecursionDesired)
+	assert.Equal(t, "", query.Question.Name)
+	assert.Equal(t, message.TypeNS, query.Question.Type)
+}
+
+func TestProber_Check(t *testing.T) {
+	// response headers (following the ID): NOERROR and SERVFAIL
+	noError := []byte{0x81, 0x80, 0, 0, 0, 0, 0, 0, 0, 0}
+	serverFailure := []byte{0x81, 0x82, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	failing := serveUDP(t, serverFailure)
+	answering := serveTCP(t, noError)
+
+	set, err := NewSet(TransportUDP, 100*time.Millisecond, failing)
+	require.NoError(t, err)
+	f, err := NewForwarder(set, Rule{Zones: []string{"corp.example"}, Addrs: []string{answering}, Transport: TransportTCP, Timeout: time.Second})
+	require.NoError(t, err)
+	defer f.Close()
+
+	now := time.Now()
+	p := NewProber(f, time.Second, func() time.Time { return now })
+
+	_, err = p.Check()
+	assert.Error(t, err, "nothing probed yet")
+
+	p.probe()
+	detail, err := p.Check()
+	require.NoError(t, err)
+	assert.Contains(t, detail, answering+" answered NOERROR")
+	assert.Contains(t, detail, failing+": answered SERVFAIL")
+
+	// the answer is no longer recent
+	now = now.Add(probeFreshness*time.Second + time.Millisecond)
+	_, err = p.Check()
+	assert.ErrorContains(t, err, "no upstream answered")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Run(ctx) // probes once before returning
+	_, err = p.Check()
+	assert.NoError(t, err)
+}
+
+func TestProber_SetForwarder(t *testing.T) {
+	noError := []byte{0x81, 0x80, 0, 0, 0, 0, 0, 0, 0, 0}
+	kept := serveUDP(t, noError)
+
+	previous, err := NewSet(TransportUDP, 100*time.Millisecond, kept)
+	require.NoError(t, err)
+	f, err := NewForwarder(previous)
+	require.NoError(t, err)
+	defer f.Close()
+
+	p := NewProber(f, time.Second, time.Now)
+	p.probe()
+	_, err = p.Check()
+	require.NoError(t, err)
+
+	added := serveUDP(t, noError)
+	set, err := NewSet(TransportUDP, 100*time.Millisecond, kept, added)
+	require.NoError(t, err)
+	replacement, err := NewForwarder(set)
+	require.NoError(t, err)
+	defer replacement.Close()
+
+	// the result of the upstream that has not changed is kept, so that readiness does not depend on the next probe
+	p.SetForwarder(replacement)
+	detail, err := p.Check()
+	require.NoError(t, err)
+	assert.Equal(t, kept+" answered NOERROR; "+added+": no recent answer", detail)
+
+	p.probe()
+	detail, err = p.Check()
+	require.NoError(t, err)
+	assert.Equal(t, kept+" answered NOERROR; "+added+" answered NOERROR", detail)
+}
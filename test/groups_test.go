@@ -0,0 +1,273 @@
+package test
+
+import (
+	"log/slog"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fedragon/sinkhole/internal/clients"
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/hosts"
+	"github.com/fedragon/sinkhole/internal/pause"
+	"github.com/fedragon/sinkhole/internal/policy"
+	"github.com/fedragon/sinkhole/internal/registry"
+	"github.com/fedragon/sinkhole/internal/schedule"
+)
+
+func TestSinkhole_Resolve_EvaluatesClientGroup(t *testing.T) {
+	malware := registry.NewMap()
+	malware.Add("malware.yyy", hosts.Rule{Action: hosts.Block})
+	social := registry.NewMap()
+	social.Add("social.yyy", hosts.Rule{Action: hosts.Block})
+	social.Add("*.video.yyy", hosts.Rule{Action: hosts.Block})
+
+	allowlist := registry.NewMap()
+	allowlist.Add("school.video.yyy", hosts.Rule{Action: hosts.Passthru})
+
+	matcher := clients.NewMatcher(nil)
+	assert.NoError(t, matcher.Add("192.168.1.20", "kids"))
+	assert.NoError(t, matcher.Add("192.168.2.0/24", "work"))
+
+	sut := dns.NewSinkholeWithLists([]dns.List{{Name: "malware", Registry: malware}, {Name: "social", Registry: social}}, slog.Default())
+	sut.SetGroups(matcher,
+		&dns.Group{Name: "kids", Allowlist: allowlist, BlockMode: policy.BlockModeNXDomain},
+		&dns.Group{Name: "work", Lists: []string{"malware"}, BlockMode: policy.BlockModeAddress},
+	)
+
+	query := func(name string) *message.Query {
+		return &message.Query{
+			ID:               1,
+			RecursionDesired: true,
+			Question: message.Question{
+				Name:  name,
+				Type:  message.TypeA,
+				Class: message.ClassInternetAddress,
+			},
+		}
+	}
+	kids := netip.MustParseAddr("192.168.1.20")
+	work := netip.MustParseAddr("192.168.2.30")
+	other := netip.MustParseAddr("192.168.3.40")
+
+	// all lists are enabled, blocked domains do not exist
+	res, ok := sut.Resolve(query("social.yyy"), kids)
+	assert.True(t, ok)
+	assert.Equal(t, message.RCodeNameError, res.RCode())
+
+	res, ok = sut.Resolve(query("cats.video.yyy"), kids)
+	assert.True(t, ok)
+	assert.Equal(t, message.RCodeNameError, res.RCode())
+
+	_, ok = sut.Resolve(query("school.video.yyy"), kids)
+	assert.False(t, ok)
+
+	// only malware is blocked
+	_, ok = sut.Resolve(query("social.yyy"), work)
+	assert.False(t, ok)
+
+	res, ok = sut.Resolve(query("malware.yyy"), work)
+	assert.True(t, ok)
+	assert.Len(t, res.Answers, 1)
+
+	// clients without a group have all lists enabled
+	res, ok = sut.Resolve(query("social.yyy"), other)
+	assert.True(t, ok)
+	assert.Len(t, res.Answers, 1)
+
+	res, ok = sut.Resolve(query("school.video.yyy"), other)
+	assert.True(t, ok)
+	assert.Len(t, res.Answers, 1)
+}
+
+func TestSinkhole_Resolve_AppliesDefaultGroup(t *testing.T) {
+	sut := dns.NewSinkhole(slog.Default())
+	sut.Register("xxx.yyy")
+	sut.SetGroups(clients.NewMatcher(nil), &dns.Group{Name: policy.DefaultGroup, BlockMode: policy.BlockModeRefused})
+
+	query := &message.Query{
+		ID:               1,
+		RecursionDesired: true,
+		Question: message.Question{
+			Name:  "xxx.yyy",
+			Type:  message.TypeAAAA,
+			Class: message.ClassInternetAddress,
+		},
+	}
+
+	res, ok := sut.Resolve(query, client)
+	assert.True(t, ok)
+	assert.Equal(t, message.RCodeRefused, res.RCode())
+	assert.Empty(t, res.Answers)
+}
+
+func TestSinkhole_Resolve_BlocksEveryQueryType(t *testing.T) {
+	const typeHTTPS message.Type = 65
+
+	tests := []struct {
+		mode  string
+		rcode message.RCode
+	}{
+		{policy.BlockModeAddress, message.RCodeNoError},
+		{policy.BlockModeNXDomain, message.RCodeNameError},
+		{policy.BlockModeNoData, message.RCodeNoError},
+		{policy.BlockModeRefused, message.RCodeRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			sut := dns.NewSinkhole(slog.Default())
+			sut.Register("xxx.yyy")
+			sut.SetGroups(clients.NewMatcher(nil), &dns.Group{Name: policy.DefaultGroup, BlockMode: tt.mode})
+
+			for _, typ := range []message.Type{message.TypeTXT, message.TypeCNAME, typeHTTPS} {
+				query := &message.Query{
+					ID:               1,
+					RecursionDesired: true,
+					Question: message.Question{
+						Name:  "xxx.yyy",
+						Type:  typ,
+						Class: message.ClassInternetAddress,
+					},
+				}
+
+				res, ok := sut.Resolve(query, client)
+				assert.True(t, ok)
+				assert.Equal(t, tt.rcode, res.RCode())
+				assert.Empty(t, res.Answers)
+			}
+		})
+	}
+}
+
+func TestSinkhole_Resolve_HonoursSchedules(t *testing.T) {
+	social := registry.NewMap()
+	social.Add("social.yyy", hosts.Rule{Action: hosts.Block})
+
+	school, err := schedule.New(policy.Schedule{
+		Name:     "school",
+		Groups:   []string{policy.DefaultGroup},
+		Lists:    []string{"social"},
+		Timezone: "UTC",
+		Periods:  []policy.Period{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "15:00"}},
+	})
+	assert.NoError(t, err)
+
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC) // Monday
+	sut := dns.NewSinkholeWithLists([]dns.List{{Name: "social", Registry: social}}, slog.Default())
+	sut.SetScheduler(schedule.NewScheduler(func() time.Time { return now }, school))
+
+	query := &message.Query{
+		ID:               1,
+		RecursionDesired: true,
+		Question: message.Question{
+			Name:  "social.yyy",
+			Type:  message.TypeA,
+			Class: message.ClassInternetAddress,
+		},
+	}
+
+	_, ok := sut.Resolve(query, client)
+	assert.True(t, ok)
+
+	now = now.Add(5 * time.Hour)
+	_, ok = sut.Resolve(query, client)
+	assert.False(t, ok)
+
+	now = now.Add(5 * 24 * time.Hour) // Saturday
+	_, ok = sut.Resolve(query, client)
+	assert.False(t, ok)
+}
+
+func TestSinkhole_Evaluate_HonoursPauses(t *testing.T) {
+	malware := registry.NewMap()
+	malware.Add("both.yyy", hosts.Rule{Action: hosts.Block})
+	social := registry.NewMap()
+	social.Add("social.yyy", hosts.Rule{Action: hosts.Block})
+	social.Add("both.yyy", hosts.Rule{Action: hosts.NXDomain})
+
+	now := time.Date(2024, 9, 2, 10, 0, 0, 0, time.UTC)
+	pauses := pause.New(func() time.Time { return now })
+
+	sut := dns.NewSinkholeWithLists([]dns.List{{Name: "social", Registry: social}, {Name: "malware", Registry: malware}}, slog.Default())
+	sut.SetPauser(pauses)
+
+	query := func(name string) *message.Query {
+		return &message.Query{
+			ID:               1,
+			RecursionDesired: true,
+			Question: message.Question{
+				Name:  name,
+				Type:  message.TypeA,
+				Class: message.ClassInternetAddress,
+			},
+		}
+	}
+
+	_, err := pauses.Pause(pause.ScopeList, "social", 5*time.Minute)
+	assert.NoError(t, err)
+
+	result := sut.Evaluate(query("social.yyy"), client)
+	assert.Equal(t, dns.Paused, result.Decision)
+	assert.Equal(t, "social", result.List)
+	assert.Nil(t, result.Response)
+
+	// other lists still apply
+	result = sut.Evaluate(query("both.yyy"), client)
+	assert.Equal(t, dns.Blocked, result.Decision)
+	assert.Equal(t, "malware", result.List)
+	assert.Equal(t, message.RCodeNoError, result.Response.RCode())
+
+	_, err = pauses.Pause(pause.ScopeGroup, policy.DefaultGroup, time.Minute)
+	assert.NoError(t, err)
+
+	result = sut.Evaluate(query("both.yyy"), client)
+	assert.Equal(t, dns.Paused, result.Decision)
+	assert.Equal(t, "social", result.List)
+
+	now = now.Add(5 * time.Minute)
+	result = sut.Evaluate(query("social.yyy"), client)
+	assert.Equal(t, dns.Blocked, result.Decision)
+	assert.Equal(t, policy.DefaultGroup, result.Group)
+}
+
+func TestSinkhole_Evaluate_HonoursCustomEntriesAndDisabledLists(t *testing.T) {
+	blocklist := registry.NewMap()
+	blocklist.Add("custom.yyy", hosts.Rule{Action: hosts.Block})
+	allowlist := registry.NewMap()
+	allowlist.Add("allowed.yyy", hosts.Rule{Action: hosts.Passthru})
+	ads := registry.NewMap()
+	ads.Add("ads.yyy", hosts.Rule{Action: hosts.Block})
+	ads.Add("allowed.yyy", hosts.Rule{Action: hosts.Block})
+
+	matcher := clients.NewMatcher(nil)
+	assert.NoError(t, matcher.Add(client.String(), "work"))
+
+	sut := dns.NewSinkholeWithLists([]dns.List{{Name: policy.CustomList, Registry: blocklist, Always: true}, {Name: "ads", Registry: ads}}, slog.Default())
+	sut.SetAllowlist(allowlist)
+	sut.SetGroups(matcher, &dns.Group{Name: "work", Lists: []string{"ads"}})
+
+	query := func(name string) *message.Query {
+		return &message.Query{
+			ID:               1,
+			RecursionDesired: true,
+			Question: message.Question{
+				Name:  name,
+				Type:  message.TypeA,
+				Class: message.ClassInternetAddress,
+			},
+		}
+	}
+
+	// custom entries apply regardless of the lists enabled for the group
+	assert.Equal(t, dns.Blocked, sut.Evaluate(query("custom.yyy"), client).Decision)
+	assert.Equal(t, dns.Blocked, sut.Evaluate(query("ads.yyy"), client).Decision)
+	assert.Equal(t, dns.Forwarded, sut.Evaluate(query("allowed.yyy"), client).Decision)
+
+	sut.SetLists([]dns.List{{Name: policy.CustomList, Registry: blocklist, Always: true}, {Name: "ads", Registry: ads, Disabled: true}})
+	assert.Equal(t, dns.Forwarded, sut.Evaluate(query("ads.yyy"), client).Decision)
+	assert.Equal(t, []dns.Match{{List: "ads", Rule: hosts.Rule{Action: hosts.Block}}}, sut.Find("ads.yyy"))
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fedragon/sinkhole/internal/dns"
+	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/records"
+)
+
+func TestLocalRecords_Resolve(t *testing.T) {
+	store, err := records.Load(filepath.Join(t.TempDir(), "records"))
+	require.NoError(t, err)
+
+	for _, r := range []records.Record{
+		{Name: "nas.home", Type: "A", Value: "192.168.1.10"},
+		{Name: "files.home", Type: "CNAME", Value: "nas.home"},
+		{Name: "nas.home", Type: "TXT", Value: "hello"},
+	} {
+		_, err := store.Add(r)
+		require.NoError(t, err)
+	}
+
+	sut := dns.NewLocalRecords(store, slog.Default())
+	query := func(name string, type_ message.Type) *message.Query {
+		return &message.Query{
+			ID:               1,
+			RecursionDesired: true,
+			Question: message.Question{
+				Name:  name,
+				Type:  type_,
+				Class: message.ClassInternetAddress,
+			},
+		}
+	}
+
+	res, ok := sut.Resolve(query("federico.is", message.TypeA))
+	assert.False(t, ok)
+	assert.Nil(t, res)
+
+	res, ok = sut.Resolve(query("NAS.home", message.TypeA))
+	assert.True(t, ok)
+	assert.True(t, res.IsAuthoritative())
+	assert.Len(t, res.Answers, 1)
+	assert.Equal(t, "NAS.home", res.Answers[0].DomainName)
+	assert.Equal(t, []byte{192, 168, 1, 10}, res.Answers[0].Data)
+
+	res, ok = sut.Resolve(query("files.home", message.TypeA))
+	assert.True(t, ok)
+	assert.Len(t, res.Answers, 2)
+	assert.Equal(t, message.TypeCNAME, res.Answers[0].Type)
+	assert.Equal(t, message.TypeA, res.Answers[1].Type)
+	assert.Equal(t, "nas.home", res.Answers[1].DomainName)
+
+	res, ok = sut.Resolve(query("10.1.168.192.in-addr.arpa", message.TypePTR))
+	assert.True(t, ok)
+	assert.Len(t, res.Answers, 1)
+	assert.Equal(t, []byte("\x03nas\x04home\x00"), res.Answers[0].Data)
+
+	res, ok = sut.Resolve(query("nas.home", message.TypeTXT))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("\x05hello"), res.Answers[0].Data)
+
+	res, ok = sut.Resolve(query("nas.home", message.TypeAAAA))
+	assert.True(t, ok)
+	assert.Equal(t, message.RCodeNoError, res.RCode())
+	assert.Empty(t, res.Answers)
+}
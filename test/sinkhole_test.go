@@ -2,14 +2,18 @@ package test
 
 import (
 	"log/slog"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/fedragon/sinkhole/internal/dns"
 	"github.com/fedragon/sinkhole/internal/dns/message"
+	"github.com/fedragon/sinkhole/internal/hosts"
 )
 
+var client = netip.MustParseAddr("192.168.1.10")
+
 func TestSinkhole_Contains(t *testing.T) {
 	sut := dns.NewSinkhole(slog.Default())
 	blockedDomain := "xxx.yyy"
@@ -36,7 +40,7 @@ func TestSinkhole_Resolve(t *testing.T) {
 			Class: message.ClassInternetAddress,
 		},
 	}
-	res, ok := sut.Resolve(&query)
+	res, ok := sut.Resolve(&query, client)
 	assert.False(t, ok)
 	assert.Nil(t, res)
 
@@ -51,7 +55,7 @@ func TestSinkhole_Resolve(t *testing.T) {
 		},
 	}
 
-	res, ok = sut.Resolve(&query)
+	res, ok = sut.Resolve(&query, client)
 	assert.True(t, ok)
 	assert.EqualValues(t, 2, res.ID())
 	assert.Len(t, res.Answers, 1)
@@ -72,7 +76,7 @@ func TestSinkhole_Resolve(t *testing.T) {
 		},
 	}
 
-	res, ok = sut.Resolve(&query)
+	res, ok = sut.Resolve(&query, client)
 	assert.True(t, ok)
 	assert.EqualValues(t, 2, res.ID())
 	assert.Len(t, res.Answers, 1)
@@ -82,3 +86,75 @@ func TestSinkhole_Resolve(t *testing.T) {
 	assert.EqualValues(t, dns.NonRoutableAddressIPv6[:], res.Answers[0].Data)
 	assert.EqualValues(t, 16, res.Answers[0].Length)
 }
+
+func TestSinkhole_Resolve_AppliesRules(t *testing.T) {
+	sut := dns.NewSinkhole(slog.Default())
+	sut.RegisterRule("*.nx.yyy", hosts.Rule{Action: hosts.NXDomain})
+	sut.RegisterRule("nodata.yyy", hosts.Rule{Action: hosts.NoData})
+	sut.RegisterRule("ok.nx.yyy", hosts.Rule{Action: hosts.Passthru})
+	sut.RegisterRule("drop.yyy", hosts.Rule{Action: hosts.Drop})
+	sut.RegisterRule("rewrite.yyy", hosts.Rule{Action: hosts.Rewrite, Target: "safe.zzz"})
+
+	query := func(name string) *message.Query {
+		return &message.Query{
+			ID:               1,
+			RecursionDesired: true,
+			Question: message.Question{
+				Name:  name,
+				Type:  message.TypeA,
+				Class: message.ClassInternetAddress,
+			},
+		}
+	}
+
+	res, ok := sut.Resolve(query("nx.yyy"), client)
+	assert.False(t, ok)
+	assert.Nil(t, res)
+
+	res, ok = sut.Resolve(query("a.b.nx.yyy"), client)
+	assert.True(t, ok)
+	assert.Equal(t, message.RCodeNameError, res.RCode())
+	assert.Empty(t, res.Answers)
+
+	res, ok = sut.Resolve(query("nodata.yyy"), client)
+	assert.True(t, ok)
+	assert.Equal(t, message.RCodeNoError, res.RCode())
+	assert.Empty(t, res.Answers)
+
+	res, ok = sut.Resolve(query("ok.nx.yyy"), client)
+	assert.False(t, ok)
+	assert.Nil(t, res)
+
+	res, ok = sut.Resolve(query("drop.yyy"), client)
+	assert.True(t, ok)
+	assert.Nil(t, res)
+
+	res, ok = sut.Resolve(query("rewrite.yyy"), client)
+	assert.True(t, ok)
+	assert.Len(t, res.Answers, 1)
+	assert.Equal(t, message.TypeCNAME, res.Answers[0].Type)
+	assert.Equal(t, []byte("\x04safe\x03zzz\x00"), res.Answers[0].Data)
+}
+
+func TestSinkhole_IgnoresCase(t *testing.T) {
+	sut := dns.NewSinkhole(slog.Default())
+	sut.Register("xxx.yyy")
+
+	// e.g. randomised by resolvers using DNS 0x20
+	for _, name := range []string{"xxx.yyy", "XXX.yyy", "xXx.YyY", "xxx.yyy."} {
+		query := message.Query{
+			ID:               1,
+			RecursionDesired: true,
+			Question: message.Question{
+				Name:  name,
+				Type:  message.TypeA,
+				Class: message.ClassInternetAddress,
+			},
+		}
+
+		_, ok := sut.Resolve(&query, client)
+		assert.True(t, ok, name)
+		assert.True(t, sut.Contains(name), name)
+		assert.Len(t, sut.Find(name), 1, name)
+	}
+}